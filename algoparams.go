@@ -0,0 +1,64 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/intermernet/pitcher/algos"
+)
+
+// paramList collects repeated --param key=value flags.
+type paramList map[string]float64
+
+func (p paramList) String() string {
+	parts := make([]string, 0, len(p))
+	for _, k := range slices.Sorted(maps.Keys(p)) {
+		parts = append(parts, k+"="+strconv.FormatFloat(p[k], 'g', -1, 64))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (p paramList) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return errors.New("want key=value")
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("%s: bad value %q", key, value)
+	}
+	p[key] = v
+	return nil
+}
+
+// addParamFlag registers the --param flag on fs.
+func addParamFlag(fs *flag.FlagSet) paramList {
+	p := paramList{}
+	fs.Var(p, "param", "Set a parameter of --algo as key=value, e.g. lh=13 for stn. Repeat for more parameters")
+	return p
+}
+
+// describeParams formats the parameters of the active algorithm for the
+// running-parameters summary, e.g. "lh 9 frames, lv 21 bins, betaL 0.55".
+func describeParams(ctx *algos.Context) string {
+	schema := ctx.Processor.Params()
+	if len(schema) == 0 {
+		return "None"
+	}
+	parts := make([]string, len(schema))
+	for i, p := range schema {
+		parts[i] = strings.TrimSpace(fmt.Sprintf("%s %g %s", p.Name, ctx.Param(p), p.Unit))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -1,112 +1,235 @@
-/****************************************************************************
-*
-* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
-*
-****************************************************************************/
-
-package algos
-
-import "fmt"
-
-// Defaults holds sane default parameters for an algorithm.
-type Defaults struct {
-	FrameSize    int
-	Oversampling int
-}
-
-// Algorithm describes a pitch-shifting algorithm.
-type Algorithm struct {
-	// FullName is the human-readable name shown in the GUI drop-down.
-	FullName string
-	// ShortName is the short identifier used with the --algo flag.
-	ShortName string
-	// Defaults holds the recommended operating parameters for this algorithm.
-	Defaults Defaults
-	// Process is the per-algorithm audio processing function.
-	Process func(ctx *Context, output, input []byte)
-	// NewState optionally allocates algorithm-specific state stored in Context.AlgoState.
-	// May be nil for stateless algorithms.
-	NewState func(ctx *Context) interface{}
-}
-
-// Algorithms is the ordered list of available algorithms. The first is the default.
-var Algorithms = []Algorithm{
-	{
-		FullName:  "Phase Vocoder",
-		ShortName: "phasvoc",
-		Defaults:  Defaults{FrameSize: 512, Oversampling: 4},
-		Process:   ProcessPhaseVocoder,
-	},
-	{
-		FullName:  "Pitch-Synchronous Overlap-Add (PSOLA)",
-		ShortName: "psola",
-		Defaults:  Defaults{FrameSize: 256, Oversampling: 2},
-		Process:   ProcessPSOLA,
-	},
-	{
-		FullName:  "Sines/Transients/Noise (STN)",
-		ShortName: "stn",
-		Defaults:  Defaults{FrameSize: 2048, Oversampling: 4},
-		Process:   ProcessSTN,
-		NewState:  NewSTNState,
-	},
-	{
-		FullName:  "Low Latency STFT",
-		ShortName: "llstft",
-		Defaults:  Defaults{FrameSize: 512, Oversampling: 4},
-		Process:   ProcessLLSTFT,
-		NewState:  NewLLSTFTState,
-	},
-	{
-		FullName:  "Waveform Similarity Overlap-Add (WSOLA)",
-		ShortName: "wsola",
-		Defaults:  Defaults{FrameSize: 512, Oversampling: 2},
-		Process:   ProcessWSOLA,
-		NewState:  NewWSOLAState,
-	},
-	{
-		FullName:  "Based on Signalsmith Stretch",
-		ShortName: "sss",
-		Defaults:  Defaults{FrameSize: 2048, Oversampling: 4},
-		Process:   ProcessSSS,
-		NewState:  NewSSSState,
-	},
-}
-
-// Find returns the Algorithm matching shortName and whether it was found.
-func Find(shortName string) (Algorithm, bool) {
-	for _, a := range Algorithms {
-		if a.ShortName == shortName {
-			return a, true
-		}
-	}
-	return Algorithm{}, false
-}
-
-// Default returns the first (default) algorithm.
-func Default() Algorithm {
-	return Algorithms[0]
-}
-
-// Names returns all short names, for use in flag help text.
-func Names() []string {
-	names := make([]string, len(Algorithms))
-	for i, a := range Algorithms {
-		names[i] = a.ShortName
-	}
-	return names
-}
-
-// FullNames returns all full names, for use in GUI selectors.
-func FullNames() []string {
-	names := make([]string, len(Algorithms))
-	for i, a := range Algorithms {
-		names[i] = a.FullName
-	}
-	return names
-}
-
-// NamesString returns a formatted string of all short names for help text.
-func NamesString() string {
-	return fmt.Sprintf("%v", Names())
-}
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package algos
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Defaults holds sane default parameters for an algorithm.
+type Defaults struct {
+	FrameSize    int
+	Oversampling int
+}
+
+// Processor is an algorithm running on one Context. The Context is passed
+// to the constructor in Algorithm.New; Process is then called from a single
+// goroutine, one block at a time.
+type Processor interface {
+	// Process shifts one block of interleaved input in the Context's
+	// Format and channel count into output of the same size.
+	Process(output, input []byte)
+	// Reset discards the state carried between blocks, as if the
+	// processor had just been created. It must not allocate, so it can
+	// be called between blocks from the audio callback.
+	Reset()
+	// Latency returns the delay from input to output in samples.
+	Latency() int
+	// Params returns the schema of the algorithm's tunable parameters. The
+	// constructor reads their values with Context.Param.
+	Params() []Param
+}
+
+// ParamKind is the type of a Param's value.
+type ParamKind int
+
+const (
+	// ParamFloat takes any value in range.
+	ParamFloat ParamKind = iota
+	// ParamInt takes whole numbers only.
+	ParamInt
+)
+
+// Param describes a tunable algorithm parameter.
+type Param struct {
+	// Name is the key used with --param.
+	Name string
+	// Usage is a one-line description.
+	Usage string
+	Kind  ParamKind
+	// Unit labels the value, e.g. "bins"; empty for plain numbers.
+	Unit string
+	// Min, Max and Default bound and initialise the value.
+	Min, Max, Default float64
+}
+
+// Check returns an error if v is not a valid value of p.
+func (p Param) Check(v float64) error {
+	switch {
+	case math.IsNaN(v) || v < p.Min || v > p.Max:
+		return fmt.Errorf("parameter %q must be between %g and %g", p.Name, p.Min, p.Max)
+	case p.Kind == ParamInt && v != math.Trunc(v):
+		return fmt.Errorf("parameter %q must be a whole number", p.Name)
+	}
+	return nil
+}
+
+// Algorithm describes a pitch-shifting algorithm.
+type Algorithm struct {
+	// FullName is the human-readable name shown in the GUI drop-down.
+	FullName string
+	// ShortName is the short identifier used with the --algo flag.
+	ShortName string
+	// Defaults holds the recommended operating parameters for this algorithm.
+	Defaults Defaults
+	// New returns a Processor bound to ctx. The Context's framing buffers
+	// are allocated but its Processor is not yet set.
+	New func(ctx *Context) Processor
+}
+
+// framed provides the Processor methods shared by the built-in algorithms,
+// which frame their input through the Context buffers.
+type framed struct {
+	ctx *Context
+}
+
+// Latency is the frame buffering plus the hop waiting in Stack.
+func (f framed) Latency() int { return f.ctx.Latency + f.ctx.Step }
+
+// Reset is a no-op for algorithms whose state lives in the Context.
+func (f framed) Reset() {}
+
+// Params returns no parameters.
+func (f framed) Params() []Param { return nil }
+
+// Algorithms is the ordered list of registered algorithms. The built-ins
+// come first and the first is the default.
+var Algorithms []Algorithm
+
+func init() {
+	for _, a := range []Algorithm{
+		{
+			FullName:  "Phase Vocoder",
+			ShortName: "phasvoc",
+			Defaults:  Defaults{FrameSize: 512, Oversampling: 4},
+			New:       func(ctx *Context) Processor { return phaseVocoder{framed{ctx}} },
+		},
+		{
+			FullName:  "Pitch-Synchronous Overlap-Add (PSOLA)",
+			ShortName: "psola",
+			Defaults:  Defaults{FrameSize: 256, Oversampling: 2},
+			New:       func(ctx *Context) Processor { return psola{framed{ctx}} },
+		},
+		{
+			FullName:  "Time-Domain PSOLA (TD-PSOLA)",
+			ShortName: "tdpsola",
+			Defaults:  Defaults{FrameSize: 2048, Oversampling: 8},
+			New:       func(ctx *Context) Processor { return newTDPSOLAState(ctx) },
+		},
+		{
+			FullName:  "Sines/Transients/Noise (STN)",
+			ShortName: "stn",
+			Defaults:  Defaults{FrameSize: 2048, Oversampling: 4},
+			New:       func(ctx *Context) Processor { return newSTNState(ctx) },
+		},
+		{
+			FullName:  "Low Latency STFT",
+			ShortName: "llstft",
+			Defaults:  Defaults{FrameSize: 512, Oversampling: 4},
+			New:       func(ctx *Context) Processor { return newLLSTFTState(ctx) },
+		},
+		{
+			FullName:  "Waveform Similarity Overlap-Add (WSOLA)",
+			ShortName: "wsola",
+			Defaults:  Defaults{FrameSize: 512, Oversampling: 2},
+			New:       func(ctx *Context) Processor { return newWSOLAState(ctx) },
+		},
+		{
+			FullName:  "Based on Signalsmith Stretch",
+			ShortName: "sss",
+			Defaults:  Defaults{FrameSize: 2048, Oversampling: 4},
+			New:       func(ctx *Context) Processor { return newSSSState(ctx) },
+		},
+		{
+			FullName:  "Frequency Shifter (SSB)",
+			ShortName: "freqshift",
+			Defaults:  Defaults{FrameSize: 512, Oversampling: 4},
+			New:       func(ctx *Context) Processor { return newFreqShiftState(ctx) },
+		},
+		{
+			FullName:  "Delay-Line Doppler Shifter",
+			ShortName: "doppler",
+			Defaults:  Defaults{FrameSize: 512, Oversampling: 4},
+			New:       func(ctx *Context) Processor { return newDopplerState(ctx) },
+		},
+		{
+			FullName:  "Granular",
+			ShortName: "granular",
+			Defaults:  Defaults{FrameSize: 2048, Oversampling: 4},
+			New:       func(ctx *Context) Processor { return newGranularState(ctx) },
+		},
+		{
+			FullName:  "Phase-Locked Vocoder",
+			ShortName: "plvoc",
+			Defaults:  Defaults{FrameSize: 2048, Oversampling: 4},
+			New:       func(ctx *Context) Processor { return newPLVState(ctx) },
+		},
+	} {
+		Register(a)
+	}
+}
+
+// Register adds an algorithm to Algorithms, which makes it available to
+// --algo, the GUI and the harmonizer voices. Packages providing algorithms
+// call it from init. It panics if the short name is empty or taken, if the
+// full name (the short name when empty) is taken, since the GUI selects by
+// it, or if New is nil.
+func Register(a Algorithm) {
+	if a.ShortName == "" || a.New == nil {
+		panic("algos: Register of an algorithm without a short name or constructor")
+	}
+	if _, dup := Find(a.ShortName); dup {
+		panic("algos: Register called twice for " + a.ShortName)
+	}
+	if a.FullName == "" {
+		a.FullName = a.ShortName
+	}
+	if slices.Contains(FullNames(), a.FullName) {
+		panic("algos: Register of " + a.ShortName + " with the taken full name " + a.FullName)
+	}
+	Algorithms = append(Algorithms, a)
+}
+
+// Find returns the Algorithm matching shortName and whether it was found.
+func Find(shortName string) (Algorithm, bool) {
+	for _, a := range Algorithms {
+		if a.ShortName == shortName {
+			return a, true
+		}
+	}
+	return Algorithm{}, false
+}
+
+// Default returns the first (default) algorithm.
+func Default() Algorithm {
+	return Algorithms[0]
+}
+
+// Names returns all short names, for use in flag help text.
+func Names() []string {
+	names := make([]string, len(Algorithms))
+	for i, a := range Algorithms {
+		names[i] = a.ShortName
+	}
+	return names
+}
+
+// FullNames returns all full names, for use in GUI selectors.
+func FullNames() []string {
+	names := make([]string, len(Algorithms))
+	for i, a := range Algorithms {
+		names[i] = a.FullName
+	}
+	return names
+}
+
+// NamesString returns a formatted string of all short names for help text.
+func NamesString() string {
+	return fmt.Sprintf("%v", Names())
+}
@@ -0,0 +1,91 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package algos
+
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/intermernet/pitcher/pitchdetect"
+)
+
+// pitchTrack holds the latest F0 estimate for one channel. It is written by
+// the audio thread and may be read from any goroutine.
+type pitchTrack struct {
+	f0, confidence atomic.Uint64
+}
+
+func (p *pitchTrack) store(e pitchdetect.Estimate) {
+	p.f0.Store(math.Float64bits(e.F0))
+	p.confidence.Store(math.Float64bits(e.Confidence))
+}
+
+func (p *pitchTrack) load() pitchdetect.Estimate {
+	return pitchdetect.Estimate{
+		F0:         math.Float64frombits(p.f0.Load()),
+		Confidence: math.Float64frombits(p.confidence.Load()),
+	}
+}
+
+// Hop runs the analysis shared by every algorithm on the frame of channel c
+// that has just filled. Algorithms call it once per hop, before processing
+// the frame.
+func (c *Context) Hop(channel int) {
+	c.shiftRamp[channel].next(c.PitchShift, c.rampCoeff(c.Step))
+	if c.PitchTracking || c.tuning() {
+		c.pitch[channel].store(c.pitchDetector.Detect(c.Frame[channel]))
+	}
+	if c.tuning() && channel == 0 {
+		c.retune(c.pitch[0].load())
+	}
+}
+
+// track appends sample s to the frame of channel and runs Hop when the frame
+// fills, sliding it on by one hop. It serves algorithms that work sample by
+// sample and keep the frame only for the analysis, and reports whether Hop
+// ran.
+func (c *Context) track(channel int, s float64) bool {
+	c.Frame[channel][c.FrameIndex[channel]] = s
+	c.FrameIndex[channel]++
+	if c.FrameIndex[channel] < c.FFTFrameSize {
+		return false
+	}
+	c.FrameIndex[channel] = c.Latency
+	c.Hop(channel)
+	copyFloat64s(c.Frame[channel][:c.Latency], c.Frame[channel][c.Step:c.Step+c.Latency])
+	return true
+}
+
+// framePitch returns the F0 estimate for the frame of channel that has just
+// filled, running the detector itself when Hop has not already done so.
+// Algorithms that need the pitch call it after Hop.
+func (c *Context) framePitch(channel int) pitchdetect.Estimate {
+	if !c.PitchTracking && !c.tuning() {
+		c.pitch[channel].store(c.pitchDetector.Detect(c.Frame[channel]))
+	}
+	return c.pitch[channel].load()
+}
+
+// Pitch returns the most recent F0 estimate for channel. It is zero until
+// PitchTracking has been enabled for at least one hop, and is safe to call
+// from any goroutine.
+func (c *Context) Pitch(channel int) pitchdetect.Estimate {
+	return c.pitch[channel].load()
+}
+
+// mirrorSpectrum fills the negative-frequency bins of spec with the
+// conjugates of the positive ones so that its inverse transform is real. The
+// Nyquist bin of an even-length spectrum is its own mirror and is made real.
+func mirrorSpectrum(spec []complex128) {
+	n := len(spec)
+	for k := 1; k < (n+1)/2; k++ {
+		spec[n-k] = complex(real(spec[k]), -imag(spec[k]))
+	}
+	if n%2 == 0 {
+		spec[n/2] = complex(real(spec[n/2]), 0)
+	}
+}
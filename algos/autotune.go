@@ -0,0 +1,104 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package algos
+
+import (
+	"math"
+
+	"github.com/intermernet/pitcher/pitchdetect"
+)
+
+// Auto-tune constants.
+const (
+	// tuneVoicedConfidence is the detector confidence below which a hop
+	// is treated as unvoiced and the correction relaxes to zero.
+	tuneVoicedConfidence = 0.8
+	// tuneHysteresis (semitones) keeps the target note from flipping
+	// when the input sits halfway between two notes.
+	tuneHysteresis = 0.15
+	// tuneHumanizeTime (seconds) is the time constant of the slow pitch
+	// average that humanize keeps movement around.
+	tuneHumanizeTime = 0.15
+)
+
+// TuneSettings configures automatic pitch correction.
+type TuneSettings struct {
+	// Scale holds the notes the input is snapped to.
+	Scale Scale
+	// Retune is the time constant in milliseconds with which the
+	// correction follows the target; 0 snaps instantly.
+	Retune float64
+	// Humanize (0–1) is the fraction of the singer's natural pitch
+	// movement, such as vibrato, kept around the target note.
+	Humanize float64
+}
+
+// tunerState is the per-context auto-tune and diatonic interval state,
+// updated once per hop from the pitch of channel 0 so every channel gets the
+// same correction.
+type tunerState struct {
+	correction float64 // semitones added to PitchShift
+	interval   float64 // semitones to the Diatonic degree above the target
+	average    float64 // slow average of the detected MIDI note
+	target     float64 // MIDI note being corrected towards
+	voiced     bool    // whether the previous hop was voiced
+}
+
+// retune updates the auto-tune correction and diatonic interval from the
+// pitch estimate of one hop. The interval holds its last value through
+// unvoiced hops so a harmony does not collapse to unison on consonants.
+func (c *Context) retune(e pitchdetect.Estimate) {
+	t := &c.tuner
+	hop := float64(c.Step) / c.SampleRate
+
+	desired := 0.0
+	if e.Voiced(tuneVoicedConfidence) {
+		n := pitchdetect.MIDINote(e.F0)
+		if !t.voiced {
+			t.average = n
+			t.target = c.Tune.Scale.Nearest(n)
+		} else {
+			t.average += (n - t.average) * (1 - math.Exp(-hop/tuneHumanizeTime))
+			if next := c.Tune.Scale.Nearest(n); math.Abs(n-next)+tuneHysteresis < math.Abs(n-t.target) {
+				t.target = next
+			}
+		}
+		// Snap the slow average to the target and keep Humanize of the
+		// movement around it.
+		desired = t.target - n + c.Tune.Humanize*(n-t.average)
+		t.interval = c.Tune.Scale.Transpose(t.target, c.Diatonic) - t.target
+	}
+	t.voiced = e.Voiced(tuneVoicedConfidence)
+
+	k := 1.0
+	if c.Tune.Retune > 0 {
+		k = 1 - math.Exp(-hop*1000/c.Tune.Retune)
+	}
+	t.correction += (desired - t.correction) * k
+}
+
+// tuning reports whether the per-hop tuner is needed.
+func (c *Context) tuning() bool {
+	return c.AutoTune || c.Diatonic != 0
+}
+
+// Ratio returns the frequency ratio the algorithms apply to the current hop
+// of channel: the ramped PitchShift plus any auto-tune correction and
+// diatonic interval, and the compensation for TimeStretch.
+func (c *Context) Ratio(channel int) float64 {
+	shift := c.shiftRamp[channel].value
+	if c.TimeStretch > 0 {
+		shift += 12 * math.Log2(c.TimeStretch)
+	}
+	if c.AutoTune {
+		shift += c.tuner.correction
+	}
+	if c.Diatonic != 0 {
+		shift += c.tuner.interval
+	}
+	return math.Exp2(shift / 12.0)
+}
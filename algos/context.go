@@ -1,127 +1,393 @@
-/****************************************************************************
-*
-* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
-*
-****************************************************************************/
-
-package algos
-
-import (
-	"encoding/binary"
-	"math"
-
-	"github.com/intermernet/gofftw/fft"
-)
-
-// Context holds all shared DSP state used by pitch-shifting algorithms.
-type Context struct {
-	PitchShift                        float64
-	FFTFrameSize                      int
-	Oversampling                      int
-	SampleRate                        float64
-	BitDepth                          uint16
-	Channels                          uint16
-	Step                              int
-	Latency                           int
-	FreqPerBin                        float64
-	Expected                          float64
-	FrameIndex                        []int
-	Stack, Frame                      [][]float64
-	FFTData                           []complex128
-	Forward, Inverse                  *fft.Plan
-	Magnitudes, Frequencies           []float64
-	SynthMagnitudes, SynthFrequencies []float64
-	LastPhase, SumPhase               [][]float64
-	OutAcc                            [][]float64
-	Window, WindowFactors             []float64
-	Reals, Imags                      []float64
-	F64Buf                            []float64
-	Volume                            float64
-	// Active algorithm
-	AlgoProcess func(ctx *Context, output, input []byte)
-	AlgoName    string
-	AlgoState   interface{}
-}
-
-// NewContext allocates and initialises DSP processing state.
-func NewContext(pitchShift float64, fftFrameSize, oversampling int, sampleRate float64, bitDepth uint16, channels int, algo Algorithm) *Context {
-	c := new(Context)
-	c.PitchShift = pitchShift
-	c.FFTFrameSize = fftFrameSize
-	c.Oversampling = oversampling
-	c.SampleRate = sampleRate
-	c.BitDepth = bitDepth
-	c.Channels = uint16(channels)
-	c.Step = fftFrameSize / oversampling
-	c.Latency = fftFrameSize - c.Step
-	c.FFTData = make([]complex128, fftFrameSize)
-	c.Forward = fft.NewPlan(fftFrameSize, fft.Forward)
-	c.Inverse = fft.NewPlan(fftFrameSize, fft.Backward)
-	c.Magnitudes = make([]float64, fftFrameSize)
-	c.Frequencies = make([]float64, fftFrameSize)
-	c.SynthMagnitudes = make([]float64, fftFrameSize)
-	c.SynthFrequencies = make([]float64, fftFrameSize)
-	c.FrameIndex = make([]int, channels)
-	c.Stack = make([][]float64, channels)
-	c.Frame = make([][]float64, channels)
-	c.LastPhase = make([][]float64, channels)
-	c.SumPhase = make([][]float64, channels)
-	c.OutAcc = make([][]float64, channels)
-	for ch := 0; ch < channels; ch++ {
-		c.FrameIndex[ch] = c.Latency
-		c.Stack[ch] = make([]float64, fftFrameSize)
-		c.Frame[ch] = make([]float64, fftFrameSize)
-		c.LastPhase[ch] = make([]float64, fftFrameSize/2+1)
-		c.SumPhase[ch] = make([]float64, fftFrameSize/2+1)
-		c.OutAcc[ch] = make([]float64, 2*fftFrameSize)
-	}
-	c.Volume = 1.0
-	c.SetAlgorithm(algo)
-
-	c.Expected = 2 * math.Pi * float64(c.Step) / float64(fftFrameSize)
-	c.FreqPerBin = sampleRate / float64(fftFrameSize)
-
-	c.Window = make([]float64, fftFrameSize)
-	c.WindowFactors = make([]float64, fftFrameSize)
-	c.Reals = make([]float64, fftFrameSize)
-	c.Imags = make([]float64, fftFrameSize)
-	c.F64Buf = make([]float64, max(fftFrameSize, 8192))
-	t := 0.0
-	for i := 0; i < fftFrameSize; i++ {
-		// Hanning window
-		w := -0.5*math.Cos(t) + 0.5
-		c.Window[i] = w
-		c.WindowFactors[i] = w * (2.0 / float64(fftFrameSize*oversampling))
-		t += (math.Pi * 2.0) / float64(fftFrameSize)
-	}
-
-	return c
-}
-
-// SetAlgorithm switches the active pitch-shifting algorithm at runtime.
-func (c *Context) SetAlgorithm(a Algorithm) {
-	c.AlgoProcess = a.Process
-	c.AlgoName = a.FullName
-	if a.NewState != nil {
-		c.AlgoState = a.NewState(c)
-	} else {
-		c.AlgoState = nil
-	}
-}
-
-// bytesToFloat64 decodes a single channel from interleaved float32 PCM bytes into dst.
-func bytesToFloat64(dst []float64, data []byte, channels, bitRate uint16, channel int) int {
-	byteDepth := int(bitRate / 8)
-	stride := byteDepth * int(channels)
-	n := 0
-	for i := channel * byteDepth; i+byteDepth <= len(data); i += stride {
-		dst[n] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i : i+4])))
-		n++
-	}
-	return n
-}
-
-// float64ToFloat32Bytes encodes a float64 sample as a little-endian float32 into d[i:i+4].
-func float64ToFloat32Bytes(d []byte, i int, f float64) {
-	binary.LittleEndian.PutUint32(d[i:i+4], math.Float32bits(float32(f)))
-}
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package algos
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/intermernet/gofftw/fft"
+	"github.com/intermernet/pitcher/pitchdetect"
+	"github.com/intermernet/pitcher/wavio"
+)
+
+// MaxBlock is the largest block, in frames, that a Context, Harmonizer or
+// Varispeed processes at once. Their scratch buffers are sized for it when
+// they are built, so processing a block never allocates.
+const MaxBlock = 8192
+
+// Context holds all shared DSP state used by pitch-shifting algorithms.
+type Context struct {
+	PitchShift                        float64
+	FormantShift                      float64
+	FFTFrameSize                      int
+	Oversampling                      int
+	SampleRate                        float64
+	Format                            wavio.SampleFormat
+	BitDepth                          uint16
+	Channels                          uint16
+	Step                              int
+	Latency                           int
+	FreqPerBin                        float64
+	Expected                          float64
+	FrameIndex                        []int
+	Stack, Frame                      [][]float64
+	FFTData                           []complex128
+	Forward, Inverse                  *fft.Plan
+	Magnitudes, Frequencies           []float64
+	SynthMagnitudes, SynthFrequencies []float64
+	LastPhase, SumPhase               [][]float64
+	OutAcc                            [][]float64
+	Window, WindowFactors             []float64
+	Reals, Imags                      []float64
+	F64Buf                            []float64
+	Volume                            float64
+	// WindowShape is the analysis window, set with SetWindow. GrainGain
+	// scales an overlap-add of analysis-windowed grains at Step to unity.
+	WindowShape WindowShape
+	GrainGain   float64
+	// FFTSize is the transform length of the STFT algorithms: a frame of
+	// FFTFrameSize windowed samples zero-padded by a factor of ZeroPad, set
+	// with SetZeroPad.
+	FFTSize, ZeroPad int
+	// Ramp is the time constant in milliseconds with which PitchShift (per
+	// hop) and Volume (per sample) follow changes; 0 applies them at once.
+	Ramp                  float64
+	shiftRamp, volumeRamp []ramp // per channel
+	// FormantPreserve keeps the spectral envelope in place while the STFT
+	// algorithms shift pitch. A non-zero FormantShift (semitones) moves the
+	// envelope instead and implies preservation.
+	FormantPreserve bool
+	formant         *formantState
+	// PitchTracking runs the F0 detector on every analysis frame; the
+	// latest estimate per channel is available from Pitch.
+	PitchTracking bool
+	pitchDetector *pitchdetect.Detector
+	pitch         []pitchTrack
+	// AutoTune corrects the detected pitch of channel 0 towards the nearest
+	// note of Tune.Scale on every hop; PitchShift then transposes the
+	// corrected pitch.
+	AutoTune bool
+	Tune     TuneSettings
+	// Diatonic, when non-zero, adds the interval from the detected note to
+	// the note that many degrees of Tune.Scale above it (below if
+	// negative), e.g. 2 for a diatonic third.
+	Diatonic int
+	tuner    tunerState
+	// TimeStretch is the duration ratio of a Varispeed running ahead of
+	// the algorithm (2 = half tempo). The algorithms shift by its inverse
+	// speed so that only the tempo changes; 0 or 1 disables it.
+	TimeStretch float64
+	// Mix is the wet fraction of the output, from 0 (dry input only) to 1
+	// (shifted signal only). The dry path is delayed by Delay so the two
+	// stay phase-aligned.
+	Mix    float64
+	dry    []delayLine // per channel
+	dryBuf []float64   // delayed dry samples of the current channel
+	// Dither adds TPDF dither before quantising to an integer Format.
+	Dither      bool
+	ditherState dither
+	// Active algorithm
+	Processor Processor
+	AlgoName  string
+	algo      Algorithm
+	params    map[string]float64 // parameter values set with SetParams
+}
+
+// NewContext allocates and initialises DSP processing state.
+// format is the interleaved sample format of the device buffers passed to
+// the algorithm: S16, S24 (packed), S32 or F32.
+func NewContext(pitchShift float64, fftFrameSize, oversampling int, sampleRate float64, format wavio.SampleFormat, channels int, algo Algorithm) *Context {
+	c := new(Context)
+	c.PitchShift = pitchShift
+	c.FFTFrameSize = fftFrameSize
+	c.Oversampling = oversampling
+	c.SampleRate = sampleRate
+	c.Format = format
+	c.BitDepth = uint16(format.Size() * 8)
+	c.ditherState = ditherSeed
+	c.Channels = uint16(channels)
+	c.Step = fftFrameSize / oversampling
+	c.Latency = fftFrameSize - c.Step
+	c.FrameIndex = make([]int, channels)
+	c.Stack = make([][]float64, channels)
+	c.Frame = make([][]float64, channels)
+	c.LastPhase = make([][]float64, channels)
+	c.SumPhase = make([][]float64, channels)
+	c.OutAcc = make([][]float64, channels)
+	for ch := 0; ch < channels; ch++ {
+		c.FrameIndex[ch] = c.Latency
+		c.Stack[ch] = make([]float64, fftFrameSize)
+		c.Frame[ch] = make([]float64, fftFrameSize)
+		c.OutAcc[ch] = make([]float64, 2*fftFrameSize)
+	}
+	c.Volume = 1.0
+	c.Ramp = DefaultRamp
+	c.shiftRamp = make([]ramp, channels)
+	c.volumeRamp = make([]ramp, channels)
+	c.Mix = 1.0
+
+	c.Window = make([]float64, fftFrameSize)
+	c.WindowFactors = make([]float64, fftFrameSize)
+	c.F64Buf = make([]float64, max(fftFrameSize, MaxBlock))
+	c.ZeroPad = 1
+	c.allocSpectrum()
+	c.pitchDetector = pitchdetect.NewDetector(sampleRate, fftFrameSize)
+	c.pitch = make([]pitchTrack, channels)
+	c.dryBuf = make([]float64, len(c.F64Buf))
+	c.WindowShape = DefaultWindow
+	c.makeWindows()
+	c.SetAlgorithm(algo)
+
+	return c
+}
+
+// SetAlgorithm switches the active pitch-shifting algorithm and clears the
+// framing, overlap-add and phase state so it starts from silence rather than
+// from another algorithm's leftovers. The output restarts after Delay
+// samples; a live caller should warm up a second Context instead.
+func (c *Context) SetAlgorithm(a Algorithm) {
+	c.algo = a
+	c.AlgoName = a.FullName
+	c.params = nil
+	c.build()
+}
+
+// SetParams sets parameters of the active algorithm by name and rebuilds its
+// Processor, which restarts from silence as after SetAlgorithm. Parameters
+// not named keep their values. It returns an error, changing nothing, if a
+// name is unknown or a value is out of range.
+func (c *Context) SetParams(values map[string]float64) error {
+	schema := c.Processor.Params()
+	for name, v := range values {
+		i := slices.IndexFunc(schema, func(p Param) bool { return p.Name == name })
+		if i < 0 {
+			return fmt.Errorf("algorithm %s has no parameter %q", c.algo.ShortName, name)
+		}
+		if err := schema[i].Check(v); err != nil {
+			return err
+		}
+	}
+	if c.params == nil {
+		c.params = make(map[string]float64, len(values))
+	}
+	maps.Copy(c.params, values)
+	c.build()
+	return nil
+}
+
+// Param returns the value of p set with SetParams, or its default.
+// Processors call it from their constructor.
+func (c *Context) Param(p Param) float64 {
+	if v, ok := c.params[p.Name]; ok {
+		return v
+	}
+	return p.Default
+}
+
+// MaxZeroPad is the largest zero-padding factor accepted by SetZeroPad.
+const MaxZeroPad = 8
+
+// SetZeroPad sets the factor by which the STFT algorithms zero-pad each
+// windowed frame before transforming it, for finer bin resolution at the
+// same frame length and latency. It rebuilds the Processor, which restarts
+// from silence as after SetAlgorithm, and returns an error, changing
+// nothing, if pad is out of range.
+func (c *Context) SetZeroPad(pad int) error {
+	if pad < 1 || pad > MaxZeroPad {
+		return fmt.Errorf("zero padding must be between 1 and %d", MaxZeroPad)
+	}
+	c.ZeroPad = pad
+	c.allocSpectrum()
+	c.makeWindows()
+	c.build()
+	return nil
+}
+
+// allocSpectrum allocates the transform plans and the buffers sized by
+// FFTSize.
+func (c *Context) allocSpectrum() {
+	n := c.FFTFrameSize * c.ZeroPad
+	c.FFTSize = n
+	c.Expected = 2 * math.Pi * float64(c.Step) / float64(n)
+	c.FreqPerBin = c.SampleRate / float64(n)
+	c.FFTData = make([]complex128, n)
+	c.Forward = fft.NewPlan(n, fft.Forward)
+	c.Inverse = fft.NewPlan(n, fft.Backward)
+	c.Magnitudes = make([]float64, n)
+	c.Frequencies = make([]float64, n)
+	c.SynthMagnitudes = make([]float64, n)
+	c.SynthFrequencies = make([]float64, n)
+	c.Reals = make([]float64, n)
+	c.Imags = make([]float64, n)
+	for ch := range c.LastPhase {
+		c.LastPhase[ch] = make([]float64, n/2+1)
+		c.SumPhase[ch] = make([]float64, n/2+1)
+	}
+	c.formant = newFormantState(n, c.FFTFrameSize, c.SampleRate)
+}
+
+// build creates the Processor of the active algorithm from silence.
+func (c *Context) build() {
+	c.clear()
+	c.Processor = c.algo.New(c)
+	c.dry = newDelayLines(int(c.Channels), c.Delay())
+}
+
+// Reset returns the Context to the state of a fresh one with the same
+// settings: it clears the framing and dry-path state, the ramps, pitch
+// tracks and auto-tune, and resets the Processor. It does not allocate, so
+// it may be called between blocks from the audio callback.
+func (c *Context) Reset() {
+	c.clear()
+	for ch := range c.dry {
+		clear(c.dry[ch].buf)
+		c.dry[ch].pos = 0
+	}
+	clear(c.shiftRamp)
+	clear(c.volumeRamp)
+	for ch := range c.pitch {
+		c.pitch[ch].store(pitchdetect.Estimate{})
+	}
+	c.tuner = tunerState{}
+	c.ditherState = ditherSeed
+	c.Processor.Reset()
+}
+
+// clear zeroes the shared framing, overlap-add and phase buffers.
+func (c *Context) clear() {
+	for ch := range c.Frame {
+		c.FrameIndex[ch] = c.Latency
+		clear(c.Stack[ch])
+		clear(c.Frame[ch])
+		clear(c.LastPhase[ch])
+		clear(c.SumPhase[ch])
+		clear(c.OutAcc[ch])
+	}
+}
+
+// Process runs the active algorithm on one block of interleaved input.
+func (c *Context) Process(output, input []byte) {
+	c.Processor.Process(output, input)
+}
+
+// ditherSeed is the fixed xorshift64 seed for TPDF dither.
+const ditherSeed dither = 0x2545F4914F6CDD1D
+
+// Delay returns the input-to-output delay of the active algorithm in
+// samples. For the built-ins this is Latency samples of frame buffering plus
+// the hop waiting in Stack.
+func (c *Context) Delay() int {
+	return c.Processor.Latency()
+}
+
+// ReadChannel decodes channel c of the interleaved input buffer into F64Buf
+// and returns the number of samples decoded. It also keeps the delayed dry
+// signal for WriteChannel.
+func (c *Context) ReadChannel(input []byte, channel int) int {
+	n := decodeChannel(c.F64Buf, input, c.Format, int(c.Channels), channel)
+	copyFloat64s(c.dryBuf[:n], c.F64Buf[:n])
+	c.dry[channel].process(c.dryBuf[:n])
+	return n
+}
+
+// decodeChannel decodes channel of an interleaved input buffer with the
+// given channel count into dst and returns the number of samples decoded.
+func decodeChannel(dst []float64, input []byte, format wavio.SampleFormat, channels, channel int) int {
+	size := format.Size()
+	stride := size * channels
+	n := 0
+	for i := channel * size; i+size <= len(input); i += stride {
+		dst[n] = format.Decode(input[i:])
+		n++
+	}
+	return n
+}
+
+// WriteChannel blends the first n samples of F64Buf with the dry signal by
+// Mix, scales them by Volume and encodes them into channel c of the
+// interleaved output buffer, applying TPDF dither when Dither is set and
+// Format is an integer encoding.
+func (c *Context) WriteChannel(output []byte, channel, n int) {
+	if c.Mix != 1 {
+		for i, d := range c.dryBuf[:n] {
+			c.F64Buf[i] = c.Mix*c.F64Buf[i] + (1-c.Mix)*d
+		}
+	}
+	c.volumeRamp[channel].apply(c.F64Buf[:n], c.Volume, c.rampCoeff(1))
+	var d *dither
+	if c.Dither {
+		d = &c.ditherState
+	}
+	encodeChannel(output, c.Format, int(c.Channels), channel, c.F64Buf[:n], d)
+}
+
+// encodeChannel encodes samples into channel of an interleaved output
+// buffer with the given channel count. A non-nil d adds TPDF dither when
+// format is an integer encoding.
+func encodeChannel(output []byte, format wavio.SampleFormat, channels, channel int, samples []float64, d *dither) {
+	size := format.Size()
+	stride := size * channels
+	lsb := 0.0
+	if d != nil && !format.IsFloat() {
+		lsb = 1 / float64(uint64(1)<<(size*8-1))
+	}
+	off := channel * size
+	for _, v := range samples {
+		if lsb != 0 {
+			// Difference of two uniform variates: triangular PDF
+			// spanning ±1 LSB.
+			v += (d.rand() - d.rand()) * lsb
+		}
+		format.Encode(output[off:], v)
+		off += stride
+	}
+}
+
+// dither is an xorshift64 state for TPDF dither.
+type dither uint64
+
+// rand returns a uniform random number in [0, 1).
+func (d *dither) rand() float64 {
+	x := uint64(*d)
+	x ^= x << 13
+	x ^= x >> 7
+	x ^= x << 17
+	*d = dither(x)
+	return float64(x>>11) / (1 << 53)
+}
+
+// delayLine delays a signal by a fixed number of samples.
+type delayLine struct {
+	buf []float64
+	pos int
+}
+
+// newDelayLines returns one delay line of delay samples per channel.
+func newDelayLines(channels, delay int) []delayLine {
+	lines := make([]delayLine, channels)
+	for c := range lines {
+		lines[c].buf = make([]float64, delay)
+	}
+	return lines
+}
+
+// process delays x in place.
+func (d *delayLine) process(x []float64) {
+	if len(d.buf) == 0 {
+		return
+	}
+	for i, v := range x {
+		x[i] = d.buf[d.pos]
+		d.buf[d.pos] = v
+		if d.pos++; d.pos == len(d.buf) {
+			d.pos = 0
+		}
+	}
+}
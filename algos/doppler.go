@@ -0,0 +1,202 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+*****************************************************************************
+*
+* Dual delay-line (rotating tape head) pitch shifter.
+*
+* Based on:
+*   U. Zölzer (ed.), "DAFX: Digital Audio Effects", 2nd ed.,
+*   Wiley, 2011, ch. 6 (time-segment processing).
+*
+* Reading a delay line through a delay that changes by 1 − ratio samples per
+* sample plays the input back at ratio times its speed, the Doppler effect of
+* a moving tape head. The delay cannot shrink or grow forever, so each of two
+* read heads sweeps across a window of delays and is then moved back to the
+* other end. The heads are half a sweep apart and crossfaded with sin² and
+* cos² gains, so each jump happens while that head is silent.
+*
+* Nothing is framed: the output lags the input only by the delay the heads
+* read at, on average minDelay + (window + search)/2, which Latency reports.
+* With the default 6 ms window and 2 ms search that is about 4 ms.
+*
+* A head being moved picks, within search samples of the end of its sweep,
+* the delay whose signal best correlates with the head it is about to take
+* over from. Both heads then drift at the same rate, so they stay aligned
+* through the crossfade. Spliced blind (search 0), the heads meet at
+* arbitrary phases, which dips the level and pulls the perceived pitch off
+* by up to half the splice rate; the search should span a period of the
+* lowest note for a clean result.
+*
+*****************************************************************************/
+
+package algos
+
+import "math"
+
+// dopplerMinDelay is the smallest delay a head reads at, in samples; the
+// cubic interpolation needs one newer sample than the read position.
+const dopplerMinDelay = 1
+
+// dopplerState holds shared delay-line shifter state.
+type dopplerState struct {
+	framed
+	ch      []dopplerChanState
+	window  float64   // sweep of each head, in samples
+	search  int       // splice search range, in samples
+	corrLen int       // samples compared for each splice candidate
+	latency int       // mean delay of the heads
+	ref     []float64 // scratch: the taking-over head's signal
+	seg     []float64 // scratch: the splice candidates
+}
+
+// dopplerHead is one read head.
+type dopplerHead struct {
+	delay float64 // samples behind the newest input
+	phase float64 // position in the sweep, 0–1; the gain is sin²(π·phase)
+}
+
+// dopplerChanState holds per-channel delay-line shifter state.
+type dopplerChanState struct {
+	buf   []float64 // ring of the newest input
+	write int       // index of the next input sample in buf
+	heads [2]dopplerHead
+	ratio float64
+}
+
+// newDopplerState allocates delay-line shifter state for the given Context.
+func newDopplerState(ctx *Context) *dopplerState {
+	window := math.Max(2, math.Round(ctx.Param(dopplerWindow)*ctx.SampleRate/1000))
+	search := int(ctx.Param(dopplerSearch) * ctx.SampleRate / 1000)
+	st := &dopplerState{
+		framed:  framed{ctx},
+		ch:      make([]dopplerChanState, ctx.Channels),
+		window:  window,
+		search:  search,
+		corrLen: int(window / 2),
+		latency: int(math.Round(dopplerMinDelay + (window+float64(search))/2)),
+	}
+	st.ref = make([]float64, st.corrLen)
+	st.seg = make([]float64, search+st.corrLen)
+	size := dopplerMinDelay + int(window) + search + st.corrLen + 4
+	for c := range st.ch {
+		st.ch[c].buf = make([]float64, size)
+	}
+	st.Reset()
+	return st
+}
+
+// Delay-line shifter parameters.
+var (
+	dopplerWindow = Param{Name: "window", Usage: "Delay swept by each read head", Kind: ParamFloat, Unit: "ms", Min: 1, Max: 100, Default: 6}
+	dopplerSearch = Param{Name: "search", Usage: "Range searched for a correlated splice point; 0 splices blind", Kind: ParamFloat, Unit: "ms", Min: 0, Max: 20, Default: 2}
+)
+
+// Params returns the window and splice search range.
+func (st *dopplerState) Params() []Param {
+	return []Param{dopplerWindow, dopplerSearch}
+}
+
+// Latency is the mean delay of the read heads.
+func (st *dopplerState) Latency() int { return st.latency }
+
+// Reset empties the delay lines and recentres the heads.
+func (st *dopplerState) Reset() {
+	for c := range st.ch {
+		// Both heads start at the mean delay with head 0 fully up, so an
+		// unshifted signal is a plain delay.
+		d := float64(st.latency)
+		ch := &st.ch[c]
+		clear(ch.buf)
+		ch.write = 0
+		ch.heads = [2]dopplerHead{{delay: d, phase: 0.5}, {delay: d}}
+		ch.ratio = 1
+	}
+}
+
+// Process shifts pitch by sweeping two crossfaded read heads through a
+// delay line.
+func (st *dopplerState) Process(output, input []byte) {
+	ctx := st.ctx
+	maxDelay := float64(len(st.ch[0].buf) - st.corrLen - 4)
+	for c := 0; c < int(ctx.Channels); c++ {
+		ch := &st.ch[c]
+		numSamples := ctx.ReadChannel(input, c)
+
+		for k := 0; k < numSamples; k++ {
+			s := ctx.F64Buf[k]
+			if ctx.track(c, s) {
+				ch.ratio = ctx.Ratio(c)
+			}
+			ch.buf[ch.write] = s
+			ch.write = (ch.write + 1) % len(ch.buf)
+
+			rate := 1 - ch.ratio
+			step := math.Abs(rate) / st.window
+			out := 0.0
+			for h := range ch.heads {
+				head := &ch.heads[h]
+				if head.phase += step; head.phase >= 1 {
+					head.phase--
+					head.delay = st.splice(ch, ch.heads[1-h].delay, rate)
+				}
+				head.delay = math.Min(math.Max(head.delay+rate, dopplerMinDelay), maxDelay)
+				g := math.Sin(math.Pi * head.phase)
+				out += g * g * ch.at(head.delay)
+			}
+			ctx.F64Buf[k] = out
+		}
+
+		ctx.WriteChannel(output, c, numSamples)
+	}
+}
+
+// at interpolates the input delay samples behind the newest one.
+func (ch *dopplerChanState) at(delay float64) float64 {
+	i := int(delay)
+	f := delay - float64(i)
+	y0, y1, y2, y3 := ch.sample(i-1), ch.sample(i), ch.sample(i+1), ch.sample(i+2)
+	return y1 + 0.5*f*(y2-y0+f*(2*y0-5*y1+4*y2-y3+f*(3*(y1-y2)+y3-y0)))
+}
+
+// sample returns the input delay whole samples behind the newest one.
+func (ch *dopplerChanState) sample(delay int) float64 {
+	n := len(ch.buf)
+	return ch.buf[((ch.write-1-delay)%n+n)%n]
+}
+
+// splice returns the delay at which a head restarts its sweep. A shift down
+// grows the delay, so the sweep starts at the shortest; a shift up starts at
+// the longest. Within the search range it takes the delay whose signal
+// correlates best with the other head, at the same fractional offset so the
+// two stay sample-aligned.
+func (st *dopplerState) splice(ch *dopplerChanState, other, rate float64) float64 {
+	base := float64(dopplerMinDelay)
+	if rate < 0 {
+		base += st.window
+	}
+	if st.search == 0 {
+		return base
+	}
+	frac := other - math.Floor(other)
+	from := int(base)
+	for i := range st.ref {
+		st.ref[i] = ch.sample(int(other) + i)
+	}
+	for i := range st.seg {
+		st.seg[i] = ch.sample(from + i)
+	}
+	best, bestScore := 0, math.Inf(-1)
+	for j := 0; j <= st.search; j++ {
+		cand := st.seg[j : j+st.corrLen]
+		energy := dotFloat64s(cand, cand)
+		if energy == 0 {
+			continue
+		}
+		if score := dotFloat64s(st.ref, cand) / math.Sqrt(energy); score > bestScore {
+			best, bestScore = j, score
+		}
+	}
+	return float64(from+best) + frac
+}
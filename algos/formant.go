@@ -0,0 +1,204 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+*****************************************************************************
+*
+* Formant preservation for the STFT-based algorithms.
+*
+* The spectral envelope of each analysis frame is estimated by cepstral
+* smoothing: the real cepstrum of the log-magnitude spectrum is liftered to
+* keep only the low quefrencies (the slowly-varying envelope) and transformed
+* back. The envelope is divided out of the spectrum before bins are remapped
+* and the original envelope is multiplied back in at the output bins, so the
+* harmonics move while the formants stay put. For a formant shift the
+* envelope is warped along the frequency axis before it is reapplied, which
+* moves the formants independently of the pitch.
+*
+*   A. V. Oppenheim & R. W. Schafer, "From frequency to quefrency: a history
+*   of the cepstrum", IEEE Signal Processing Magazine, 2004.
+*
+*   A. Röbel & X. Rodet, "Efficient spectral envelope estimation and its
+*   application to pitch shifting and envelope preservation", DAFx 2005.
+*
+*****************************************************************************/
+
+package algos
+
+import "math"
+
+// formantMaxPitch is the highest fundamental (Hz) the cepstral lifter is
+// designed for. Quefrencies of one period of this pitch and above are
+// treated as harmonic fine structure and discarded.
+const formantMaxPitch = 500.0
+
+// formantFloor keeps the log of silent bins finite.
+const formantFloor = 1e-12
+
+// formantRange is the lowest level, relative to the peak, of the spectrum
+// the envelope is estimated from and of the envelope itself. A null in the
+// spectrum would otherwise drag the smoothed envelope far below the
+// partials around it, and bins far below the formants would be flattened
+// up to the level of the harmonics wherever the envelope is reapplied.
+const formantRange = 1e-3 // -60 dB
+
+// formantMaxGain limits the gain or cut the envelope correction applies to
+// a bin: the ratio of the envelope reapplied at an output bin to the one
+// divided out at the input position the algorithm moves there. A partial
+// shifted from a formant peak into a deep valley, or out of one, is
+// otherwise scaled by the whole depth of the valley.
+const formantMaxGain = 8 // +-18 dB
+
+// formantMaxWarp limits the gain or cut a formant shift applies to a bin,
+// the ratio of the warped envelope to the one already there, so that a
+// formant peak moved over a quiet band does not boost it by the full depth
+// of the valley.
+const formantMaxWarp = 4 // +-12 dB
+
+// formantLobes is the number of window main lobes the envelope smooths
+// over. A Hann main lobe is four bins of the unpadded frame wide, so a
+// shorter frame, whose partials are smeared further, needs a shorter
+// lifter for the envelope to pass over them.
+const formantLobes = 2
+
+// formantState holds scratch buffers for spectral envelope estimation. It is
+// shared by all channels since they are processed one frame at a time.
+type formantState struct {
+	cepstrum []complex128 // [FFTSize] cepstrum scratch
+	env      []float64    // [bins] envelope of the current analysis frame
+	target   []float64    // [bins] envelope reapplied at the output bins
+	mags     []float64    // [bins] magnitudes of a complex spectrum
+	lifter   int          // highest quefrency kept, in samples
+}
+
+func newFormantState(fftSize, frameSize int, sampleRate float64) *formantState {
+	bins := fftSize/2 + 1
+	lifter := int(sampleRate / formantMaxPitch)
+	lifter = max(1, min(lifter, frameSize/(4*formantLobes)))
+	return &formantState{
+		cepstrum: make([]complex128, fftSize),
+		env:      make([]float64, bins),
+		target:   make([]float64, bins),
+		mags:     make([]float64, bins),
+		lifter:   lifter,
+	}
+}
+
+// formantsActive reports whether the STFT algorithms should process the
+// spectral envelope this frame.
+func (c *Context) formantsActive() bool {
+	return c.FormantPreserve || c.FormantShift != 0
+}
+
+// estimateEnvelope computes the cepstrally smoothed envelope of the
+// half-spectrum magnitudes mags into formant.env, and the envelope warped by
+// FormantShift, within formantMaxWarp of it, into formant.target.
+func (c *Context) estimateEnvelope(mags []float64) {
+	f := c.formant
+	N := c.FFTSize
+	half := N / 2
+	floor := formantFloor
+	for _, m := range mags[:half+1] {
+		floor = max(floor, m*formantRange)
+	}
+	for k := 0; k <= half; k++ {
+		v := complex(math.Log(math.Max(mags[k], floor)), 0)
+		f.cepstrum[k] = v
+		if k > 0 && N-k != k {
+			f.cepstrum[N-k] = v
+		}
+	}
+	c.Inverse.Execute(f.cepstrum, f.cepstrum)
+
+	// Lifter: keep quefrencies below the lowest expected pitch period.
+	scale := complex(1/float64(N), 0)
+	for q := range f.cepstrum {
+		if q > f.lifter && q < N-f.lifter {
+			f.cepstrum[q] = 0
+		} else {
+			f.cepstrum[q] *= scale
+		}
+	}
+	c.Forward.Execute(f.cepstrum, f.cepstrum)
+	peak := 0.0
+	for k := 0; k <= half; k++ {
+		f.env[k] = math.Exp(real(f.cepstrum[k]))
+		peak = max(peak, f.env[k])
+	}
+	for k := 0; k <= half; k++ {
+		f.env[k] = max(f.env[k], peak*formantRange, formantFloor)
+	}
+
+	if c.FormantShift == 0 {
+		copy(f.target, f.env)
+		return
+	}
+	// Output bin k takes the envelope of input position k / ratio.
+	inv := math.Exp2(-c.FormantShift / 12.0)
+	for k := 0; k <= half; k++ {
+		pos := float64(k) * inv
+		warped := f.env[half]
+		if lo := int(pos); lo < half {
+			frac := pos - float64(lo)
+			warped = f.env[lo]*(1-frac) + f.env[lo+1]*frac
+		}
+		f.target[k] = min(max(warped, f.env[k]/formantMaxWarp), f.env[k]*formantMaxWarp)
+	}
+}
+
+// limitCorrection keeps the target envelope at each output bin l within
+// formantMaxGain of the envelope at the input position l/ratio, where an
+// algorithm shifting by ratio takes that bin from.
+func (f *formantState) limitCorrection(ratio float64) {
+	half := len(f.env) - 1
+	for l := range f.target {
+		pos := float64(l) / ratio
+		src := f.env[half]
+		if lo := int(pos); lo < half {
+			frac := pos - float64(lo)
+			src = f.env[lo]*(1-frac) + f.env[lo+1]*frac
+		}
+		f.target[l] = min(max(f.target[l], src/formantMaxGain), src*formantMaxGain)
+	}
+}
+
+// flattenMagnitudes estimates the envelope of mags, divides it out and
+// limits the correction for a shift by ratio.
+func (c *Context) flattenMagnitudes(mags []float64, ratio float64) {
+	c.estimateEnvelope(mags)
+	c.formant.limitCorrection(ratio)
+	for k := range mags {
+		mags[k] /= c.formant.env[k]
+	}
+}
+
+// shapeMagnitudes multiplies mags by the target envelope of the last
+// flattened frame.
+func (c *Context) shapeMagnitudes(mags []float64) {
+	for k := range mags {
+		mags[k] *= c.formant.target[k]
+	}
+}
+
+// flattenSpectrum estimates the envelope of the complex half-spectrum spec
+// and divides it out, leaving phases untouched, and limits the correction
+// for a shift by ratio.
+func (c *Context) flattenSpectrum(spec []complex128, ratio float64) {
+	mags := c.formant.mags[:len(spec)]
+	for k, v := range spec {
+		mags[k] = math.Hypot(real(v), imag(v))
+	}
+	c.estimateEnvelope(mags)
+	c.formant.limitCorrection(ratio)
+	for k := range spec {
+		spec[k] /= complex(c.formant.env[k], 0)
+	}
+}
+
+// shapeSpectrum multiplies the complex half-spectrum spec by the target
+// envelope of the last flattened frame.
+func (c *Context) shapeSpectrum(spec []complex128) {
+	for k := range spec {
+		spec[k] *= complex(c.formant.target[k], 0)
+	}
+}
@@ -0,0 +1,145 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+*****************************************************************************
+*
+* Single-sideband (SSB) frequency shifter.
+*
+* Based on:
+*   O. Niemitalo, "Hilbert transform / analytic signal using a polyphase
+*   IIR allpass filter pair", http://yehar.com/blog/?p=368
+*
+* Unlike the other algorithms, which multiply every frequency by a ratio,
+* a frequency shifter adds a fixed offset in Hz: harmonic partials become
+* inharmonic, which is the point for sound design. The input is split into
+* an analytic pair I + jQ by two chains of four second-order allpass
+* sections whose outputs stay 90° apart (within 1.7°, 20 Hz – 23 kHz at
+* 48 kHz). Multiplying by a complex oscillator at the shift frequency and
+* keeping the real part,
+*
+*   y = I·cos(Ωt) − Q·sin(Ωt)
+*
+* moves every component up by Ω (down for a negative shift) with the mirror
+* sideband cancelled. The filters are recursive, so the shifter adds no
+* buffering latency; the frame is only filled for pitch tracking, and
+* PitchShift, auto-tune and harmony intervals do not apply.
+*
+*****************************************************************************/
+
+package algos
+
+import "math"
+
+// Allpass coefficients of the in-phase and quadrature chains. Each section
+// is (a² − z⁻²)/(1 − a²z⁻²); the quadrature chain is followed by a
+// one-sample delay.
+var (
+	hilbertI = [4]float64{0.4021921162426, 0.8561710882420, 0.9722909545651, 0.9952884791278}
+	hilbertQ = [4]float64{0.6923878, 0.9360654322959, 0.9882295226860, 0.9987488452737}
+)
+
+// MaxFrequencyShift is the largest offset, in Hz, of the frequency shifter.
+const MaxFrequencyShift = 5000.0
+
+// allpassChain is one path of the Hilbert filter pair.
+type allpassChain struct {
+	coef [4]float64    // a² of each section
+	x, y [4][2]float64 // last two inputs and outputs of each section
+}
+
+// newAllpassChain returns a chain with the given section coefficients.
+func newAllpassChain(a [4]float64) allpassChain {
+	var ap allpassChain
+	for i, v := range a {
+		ap.coef[i] = v * v
+	}
+	return ap
+}
+
+// next filters one sample.
+func (ap *allpassChain) next(s float64) float64 {
+	for i, a := range ap.coef {
+		out := a*(s+ap.y[i][1]) - ap.x[i][1]
+		ap.x[i][1], ap.x[i][0] = ap.x[i][0], s
+		ap.y[i][1], ap.y[i][0] = ap.y[i][0], out
+		s = out
+	}
+	return s
+}
+
+// freqShiftState holds shared frequency shifter state.
+type freqShiftState struct {
+	framed
+	ch             []freqShiftChanState
+	stepRe, stepIm float64 // oscillator rotation per sample
+}
+
+// freqShiftChanState holds per-channel frequency shifter state.
+type freqShiftChanState struct {
+	i, q         allpassChain
+	lastQ        float64 // quadrature output awaiting its one-sample delay
+	oscRe, oscIm float64 // oscillator phasor
+}
+
+// newFreqShiftState allocates frequency shifter state for the given Context.
+func newFreqShiftState(ctx *Context) *freqShiftState {
+	omega := 2 * math.Pi * ctx.Param(freqShiftHz) / ctx.SampleRate
+	st := &freqShiftState{
+		framed: framed{ctx},
+		ch:     make([]freqShiftChanState, ctx.Channels),
+		stepRe: math.Cos(omega),
+		stepIm: math.Sin(omega),
+	}
+	st.Reset()
+	return st
+}
+
+// freqShiftHz is the shift parameter.
+var freqShiftHz = Param{Name: "hz", Usage: "Frequency offset added to every partial", Kind: ParamFloat, Unit: "Hz", Min: -MaxFrequencyShift, Max: MaxFrequencyShift, Default: 0}
+
+// Params returns the shift.
+func (st *freqShiftState) Params() []Param {
+	return []Param{freqShiftHz}
+}
+
+// Latency is zero: the allpass filters need no lookahead.
+func (st *freqShiftState) Latency() int { return 0 }
+
+// Reset clears the filters and restarts the oscillator.
+func (st *freqShiftState) Reset() {
+	for c := range st.ch {
+		st.ch[c] = freqShiftChanState{
+			i:     newAllpassChain(hilbertI),
+			q:     newAllpassChain(hilbertQ),
+			oscRe: 1,
+		}
+	}
+}
+
+// Process shifts every partial of the input by the hz parameter.
+func (st *freqShiftState) Process(output, input []byte) {
+	ctx := st.ctx
+	for c := 0; c < int(ctx.Channels); c++ {
+		ch := &st.ch[c]
+		numSamples := ctx.ReadChannel(input, c)
+
+		for k := 0; k < numSamples; k++ {
+			s := ctx.F64Buf[k]
+			ctx.track(c, s)
+
+			re := ch.i.next(s)
+			im := ch.lastQ
+			ch.lastQ = ch.q.next(s)
+			ctx.F64Buf[k] = re*ch.oscRe - im*ch.oscIm
+			ch.oscRe, ch.oscIm = ch.oscRe*st.stepRe-ch.oscIm*st.stepIm, ch.oscRe*st.stepIm+ch.oscIm*st.stepRe
+		}
+
+		// Renormalise the phasor against rounding drift.
+		g := 1 / math.Hypot(ch.oscRe, ch.oscIm)
+		ch.oscRe *= g
+		ch.oscIm *= g
+
+		ctx.WriteChannel(output, c, numSamples)
+	}
+}
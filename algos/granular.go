@@ -0,0 +1,214 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+*****************************************************************************
+*
+* Granular pitch shifter.
+*
+* Based on:
+*   C. Roads, "Microsound", MIT Press, 2001, ch. 3 (granular synthesis).
+*
+* Grains of size samples are started every size/density output samples. Each
+* reads size·ratio samples of the current frame, resampled to size with
+* linear interpolation, so it plays back ratio times faster, and is shaped by
+* the grain envelope before being overlap-added into OutAcc. The grain is
+* centred on the frame position matching its output time, so an unshifted,
+* unjittered grain stream reconstructs the input after the usual frame of
+* latency.
+*
+* Position jitter moves each grain's read position forward by a random
+* fraction of the rest of the frame, smearing the sound in time; pitch jitter
+* detunes each grain by a random amount up to the given number of semitones.
+* The random numbers come from an xorshift64 generator seeded by the seed
+* parameter and restarted with the Processor, so a render is reproducible.
+* Every channel uses the same sequence, which keeps a stereo image intact.
+*
+* The envelope is scaled by 1/(density · mean envelope), the inverse of the
+* expected overlap of envelopes at any instant, so the level does not depend
+* on the density or envelope choice.
+*
+*****************************************************************************/
+
+package algos
+
+import "math"
+
+// Grain envelopes, the values of the envelope parameter.
+const (
+	grainHann = iota
+	grainTukey
+	grainTrapezoid
+)
+
+// grainTaper is the fraction of a Tukey or trapezoid grain spent fading in
+// and out, half at each end.
+const grainTaper = 0.5
+
+// granularState holds shared granular shifter state.
+type granularState struct {
+	framed
+	ch          []granularChanState
+	size        int       // grain length in output samples
+	interval    float64   // output samples between grain onsets
+	posJitter   float64   // 0–1 of the rest of the frame the read position may move
+	pitchJitter float64   // semitones of random detune
+	env         []float64 // grain envelope with the overlap-add gain, size samples
+	grain       []float64 // scratch: one resampled grain
+	seed        dither    // initial randomisation state of every channel
+}
+
+// granularChanState holds per-channel granular shifter state.
+type granularChanState struct {
+	rng  dither  // grain randomisation, the same sequence on every channel
+	next float64 // OutAcc offset of the next grain onset
+}
+
+// newGranularState allocates granular shifter state for the given Context.
+func newGranularState(ctx *Context) *granularState {
+	size := int(ctx.Param(granularSize(ctx)))
+	density := ctx.Param(granularDensity)
+	st := &granularState{
+		framed:      framed{ctx},
+		ch:          make([]granularChanState, ctx.Channels),
+		size:        size,
+		interval:    float64(size) / density,
+		posJitter:   ctx.Param(granularPosJitter),
+		pitchJitter: ctx.Param(granularPitchJitter),
+		env:         make([]float64, size),
+		grain:       make([]float64, size),
+	}
+	sum := 0.0
+	for i := range st.env {
+		st.env[i] = grainEnvelope(int(ctx.Param(granularEnvelope)), float64(i)/float64(size))
+		sum += st.env[i]
+	}
+	gain := float64(size) / (density * sum)
+	for i := range st.env {
+		st.env[i] *= gain
+	}
+
+	// Any nonzero state will do; the odd multiplier keeps seed 0 nonzero.
+	st.seed = dither((uint64(ctx.Param(granularSeed)) + 1) * 0x9E3779B97F4A7C15)
+	st.Reset()
+	return st
+}
+
+// grainEnvelope returns the periodic envelope shape at position x (0–1) of
+// the grain.
+func grainEnvelope(shape int, x float64) float64 {
+	// Distance from the nearer end, in units of one taper.
+	edge := min(x, 1-x) / (grainTaper / 2)
+	switch shape {
+	case grainTukey:
+		if edge < 1 {
+			return 0.5 - 0.5*math.Cos(math.Pi*edge)
+		}
+		return 1
+	case grainTrapezoid:
+		return min(edge, 1)
+	}
+	return 0.5 - 0.5*math.Cos(2*math.Pi*x)
+}
+
+// Granular shifter parameters.
+var (
+	granularDensity     = Param{Name: "density", Usage: "Average number of overlapping grains", Kind: ParamFloat, Min: 1, Max: 16, Default: 4}
+	granularPosJitter   = Param{Name: "posJitter", Usage: "Random forward shift of each grain's read position, as a fraction of the rest of the frame", Kind: ParamFloat, Min: 0, Max: 1, Default: 0}
+	granularPitchJitter = Param{Name: "pitchJitter", Usage: "Random detune of each grain", Kind: ParamFloat, Unit: "semitones", Min: 0, Max: 12, Default: 0}
+	granularEnvelope    = Param{Name: "envelope", Usage: "Grain envelope: 0 Hann, 1 Tukey, 2 trapezoid", Kind: ParamInt, Min: grainHann, Max: grainTrapezoid, Default: grainHann}
+	granularSeed        = Param{Name: "seed", Usage: "Seed of the grain jitter", Kind: ParamInt, Min: 0, Max: 65535, Default: 0}
+)
+
+// granularSize is the grain length parameter. A grain is read from a single
+// frame, so it can be at most a frame long.
+func granularSize(ctx *Context) Param {
+	return Param{Name: "size", Usage: "Grain length", Kind: ParamInt, Unit: "samples", Min: 16, Max: float64(max(ctx.FFTFrameSize, 16)), Default: float64(max(ctx.FFTFrameSize/2, 16))}
+}
+
+// Params returns the grain controls.
+func (st *granularState) Params() []Param {
+	return []Param{granularSize(st.ctx), granularDensity, granularPosJitter, granularPitchJitter, granularEnvelope, granularSeed}
+}
+
+// Reset restarts the grain schedule and the random sequence.
+func (st *granularState) Reset() {
+	for c := range st.ch {
+		st.ch[c] = granularChanState{rng: st.seed}
+	}
+}
+
+// Process overlap-adds a stream of resampled, enveloped grains read from the
+// current frame.
+func (st *granularState) Process(output, input []byte) {
+	ctx := st.ctx
+	frameSize := ctx.FFTFrameSize
+	hopSize := ctx.Step
+
+	for c := 0; c < int(ctx.Channels); c++ {
+		ch := &st.ch[c]
+		numSamples := ctx.ReadChannel(input, c)
+		frameIndex := ctx.FrameIndex[c]
+
+		for i := 0; i < numSamples; i++ {
+			ctx.Frame[c][frameIndex] = ctx.F64Buf[i]
+			ctx.F64Buf[i] = ctx.Stack[c][frameIndex-ctx.Latency]
+			frameIndex++
+
+			if frameIndex >= frameSize {
+				frameIndex = ctx.Latency
+				ctx.Hop(c)
+				ratio := ctx.Ratio(c)
+
+				// Start every grain whose onset falls in the hop about to be
+				// drained.
+				for ; ch.next < float64(hopSize); ch.next += st.interval {
+					st.addGrain(c, int(ch.next), ratio)
+				}
+				ch.next -= float64(hopSize)
+
+				// Drain, shift output accumulator, slide input frame.
+				copyFloat64s(ctx.Stack[c][:hopSize], ctx.OutAcc[c][:hopSize])
+				copyFloat64s(ctx.OutAcc[c][:2*frameSize-hopSize], ctx.OutAcc[c][hopSize:2*frameSize])
+				zeroFloat64s(ctx.OutAcc[c][2*frameSize-hopSize : 2*frameSize])
+				copyFloat64s(ctx.Frame[c][:ctx.Latency], ctx.Frame[c][hopSize:hopSize+ctx.Latency])
+			}
+		}
+
+		ctx.FrameIndex[c] = frameIndex
+
+		ctx.WriteChannel(output, c, numSamples)
+	}
+}
+
+// addGrain overlap-adds one grain of channel c starting at OutAcc offset
+// onset.
+func (st *granularState) addGrain(c, onset int, ratio float64) {
+	ctx := st.ctx
+	ch := &st.ch[c]
+	frame := ctx.Frame[c]
+	last := float64(ctx.FFTFrameSize - 1)
+
+	if st.pitchJitter > 0 {
+		ratio *= math.Exp2(st.pitchJitter * (2*ch.rng.rand() - 1) / 12)
+	}
+	span := min(float64(st.size)*ratio, last)
+
+	// Centre the source on the frame position matching the output time,
+	// then jitter it into the newer part of the frame.
+	start := float64(onset) + (float64(st.size)-span)/2
+	start = math.Min(math.Max(start, 0), last-span)
+	if st.posJitter > 0 {
+		start += st.posJitter * ch.rng.rand() * (last - span - start)
+	}
+
+	step := span / float64(st.size)
+	for k := range st.grain {
+		pos := start + float64(k)*step
+		lo := int(pos)
+		hi := min(lo+1, ctx.FFTFrameSize-1)
+		frac := pos - float64(lo)
+		st.grain[k] = frame[lo]*(1-frac) + frame[hi]*frac
+	}
+	mulAddFloat64s(ctx.OutAcc[c][onset:onset+st.size], st.grain, st.env)
+}
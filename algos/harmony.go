@@ -0,0 +1,249 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package algos
+
+import (
+	"math"
+
+	"github.com/intermernet/pitcher/wavio"
+)
+
+// Voice describes one harmonizer voice.
+type Voice struct {
+	// Interval is the shift in semitones or, when Diatonic is set, in
+	// degrees of the harmonizer scale (2 = a third above the sung note).
+	Interval float64
+	Diatonic bool
+	// Gain scales the voice in the mix.
+	Gain float64
+	// Pan places the voice from -1 (left) to +1 (right) when the output
+	// has two or more channels.
+	Pan float64
+	// Algo shifts the voice. The zero value runs the algorithm of the
+	// Context the Harmonizer is built from.
+	Algo Algorithm
+}
+
+// harmonyVoice is a Voice with the Context that renders it.
+type harmonyVoice struct {
+	Voice
+	ctx   *Context
+	delay []delayLine // per input channel, aligning the voice with the slowest
+	gains []float64   // per output channel, from Gain and Pan
+}
+
+// Harmonizer mixes several pitch-shifted voices of one input with the dry
+// signal. Every voice runs its own algorithm state on its own Context; the
+// voices and the dry signal are delayed to line up with the slowest voice.
+//
+// Input is interleaved with InChannels channels and output with
+// OutChannels; input channel c feeds output channels c, c+InChannels, …, so
+// a mono input can be panned across a stereo output.
+type Harmonizer struct {
+	// Dry is the gain of the unshifted input in the mix.
+	Dry float64
+	// Transpose (semitones) is added to every voice.
+	Transpose float64
+	// FormantShift (semitones) moves the formants of every voice as
+	// Context.FormantShift does.
+	FormantShift float64
+	// Mix blends the input, delayed to line up, with the harmonizer mix
+	// as Context.Mix does: 1 plays the dry signal at Dry and the voices,
+	// 0 the input alone.
+	Mix float64
+	// Volume scales the final mix.
+	Volume float64
+	// Ramp smooths Transpose and Volume changes as Context.Ramp does.
+	Ramp float64
+	// FormantPreserve, AutoTune and Tune are applied to every voice. Tune's
+	// scale also sets the diatonic intervals.
+	FormantPreserve bool
+	AutoTune        bool
+	Tune            TuneSettings
+	// Dither adds TPDF dither when Format is an integer encoding.
+	Dither bool
+
+	Format                  wavio.SampleFormat
+	InChannels, OutChannels int
+
+	sampleRate  float64
+	voices      []harmonyVoice
+	dry         []delayLine // per input channel
+	latency     int
+	frameSize   int         // the longest frame of any voice
+	in, out     []byte      // voice input and output, as Float64, MaxBlock frames
+	buf         []float64   // one channel of samples
+	mix         [][]float64 // per output channel
+	volumeRamp  []ramp      // per output channel
+	ditherState dither
+}
+
+// NewHarmonizer allocates a Harmonizer with one Context per voice, taking
+// input in the format and channels of main. Every voice takes main's window
+// and zero padding. A voice running main's algorithm also takes its frame
+// size, oversampling and parameters; any other uses its algorithm's
+// defaults. Only fields fixed when main was built are read, so main may be
+// running on another goroutine; Tune and the other controls are set on the
+// Harmonizer.
+func NewHarmonizer(voices []Voice, main *Context, outChannels int) *Harmonizer {
+	inChannels := int(main.Channels)
+	h := &Harmonizer{
+		Dry:         1,
+		Mix:         1,
+		Volume:      1,
+		Ramp:        DefaultRamp,
+		sampleRate:  main.SampleRate,
+		Format:      main.Format,
+		InChannels:  inChannels,
+		OutChannels: outChannels,
+		voices:      make([]harmonyVoice, len(voices)),
+		in:          make([]byte, MaxBlock*inChannels*wavio.Float64.Size()),
+		out:         make([]byte, MaxBlock*inChannels*wavio.Float64.Size()),
+		buf:         make([]float64, MaxBlock),
+		mix:         make([][]float64, outChannels),
+		volumeRamp:  make([]ramp, outChannels),
+		ditherState: ditherSeed,
+	}
+	for p := range h.mix {
+		h.mix[p] = make([]float64, MaxBlock)
+	}
+	for i, v := range voices {
+		ctx := main.voiceContext(v)
+		h.voices[i] = harmonyVoice{Voice: v, ctx: ctx, gains: panGains(v.Gain, v.Pan, outChannels)}
+		h.latency = max(h.latency, ctx.Delay())
+		h.frameSize = max(h.frameSize, ctx.FFTFrameSize)
+	}
+	for i := range h.voices {
+		h.voices[i].delay = newDelayLines(inChannels, h.latency-h.voices[i].ctx.Delay())
+	}
+	h.dry = newDelayLines(inChannels, h.latency)
+	return h
+}
+
+// voiceContext builds the Float64 Context that renders v with the settings
+// of c, as NewHarmonizer describes.
+func (c *Context) voiceContext(v Voice) *Context {
+	a := v.Algo
+	frameSize, oversampling := a.Defaults.FrameSize, a.Defaults.Oversampling
+	same := a.New == nil || a.ShortName == c.algo.ShortName
+	if same {
+		a, frameSize, oversampling = c.algo, c.FFTFrameSize, c.Oversampling
+	}
+	ctx := NewContext(0, frameSize, oversampling, c.SampleRate, wavio.Float64, int(c.Channels), a)
+	// The window and zero padding were validated on c and fit any frame
+	// size; the parameters fit c's frame size, which same voices share.
+	if c.WindowShape != DefaultWindow {
+		_ = ctx.SetWindow(c.WindowShape)
+	}
+	if c.ZeroPad != 1 {
+		_ = ctx.SetZeroPad(c.ZeroPad)
+	}
+	if same && len(c.params) > 0 {
+		_ = ctx.SetParams(c.params)
+	}
+	if v.Diatonic {
+		ctx.Diatonic = int(v.Interval)
+	}
+	return ctx
+}
+
+// panGains returns the per-output-channel gains of a voice: a constant-power
+// pan across the first two channels, unity on any others.
+func panGains(gain, pan float64, channels int) []float64 {
+	g := make([]float64, channels)
+	for i := range g {
+		g[i] = gain
+	}
+	if channels >= 2 {
+		theta := (pan + 1) * math.Pi / 4
+		g[0] *= math.Sqrt2 * math.Cos(theta)
+		g[1] *= math.Sqrt2 * math.Sin(theta)
+	}
+	return g
+}
+
+// Latency returns the delay of the mix in samples.
+func (h *Harmonizer) Latency() int {
+	return h.latency
+}
+
+// FrameSize returns the longest frame size of the voices.
+func (h *Harmonizer) FrameSize() int {
+	return h.frameSize
+}
+
+// Voices returns the voice descriptions.
+func (h *Harmonizer) Voices() []Voice {
+	vs := make([]Voice, len(h.voices))
+	for i, v := range h.voices {
+		vs[i] = v.Voice
+	}
+	return vs
+}
+
+// Process renders all voices for one block of input and writes the mix to
+// output.
+func (h *Harmonizer) Process(output, input []byte) {
+	size := h.Format.Size()
+	frames := len(input) / (size * h.InChannels)
+	f64 := wavio.Float64.Size()
+	n := frames * h.InChannels * f64
+	in, out, buf := h.in[:n], h.out[:n], h.buf[:frames]
+
+	// The voices run on Float64 so only the final mix is quantised.
+	for i := 0; i < frames*h.InChannels; i++ {
+		wavio.Float64.Encode(in[i*f64:], h.Format.Decode(input[i*size:]))
+	}
+
+	// The voices are scaled by Mix and the dry signal blended towards
+	// unity as Mix falls.
+	dryGain := h.Mix*h.Dry + 1 - h.Mix
+	for c := 0; c < h.InChannels; c++ {
+		decodeChannel(buf, input, h.Format, h.InChannels, c)
+		h.dry[c].process(buf)
+		for p := c; p < h.OutChannels; p += h.InChannels {
+			for i, v := range buf {
+				h.mix[p][i] = dryGain * v
+			}
+		}
+	}
+
+	for v := range h.voices {
+		hv := &h.voices[v]
+		ctx := hv.ctx
+		ctx.PitchShift = h.Transpose
+		if !hv.Diatonic {
+			ctx.PitchShift += hv.Interval
+		}
+		ctx.FormantShift = h.FormantShift
+		ctx.Ramp = h.Ramp
+		ctx.FormantPreserve = h.FormantPreserve
+		ctx.AutoTune = h.AutoTune
+		ctx.Tune = h.Tune
+		ctx.Process(out, in)
+		for c := 0; c < h.InChannels; c++ {
+			decodeChannel(buf, out, wavio.Float64, h.InChannels, c)
+			hv.delay[c].process(buf)
+			for p := c; p < h.OutChannels; p += h.InChannels {
+				g := h.Mix * hv.gains[p]
+				for i, s := range buf {
+					h.mix[p][i] += g * s
+				}
+			}
+		}
+	}
+
+	var d *dither
+	if h.Dither {
+		d = &h.ditherState
+	}
+	k := rampCoeff(1, h.sampleRate, h.Ramp)
+	for p := range h.mix {
+		h.volumeRamp[p].apply(h.mix[p][:frames], h.Volume, k)
+		encodeChannel(output, h.Format, h.OutChannels, p, h.mix[p][:frames], d)
+	}
+}
@@ -0,0 +1,275 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+*****************************************************************************
+*
+* Phase-locked vocoder pitch-shifting algorithm.
+*
+* Based on:
+*   J. Laroche, M. Dolson, "Improved phase vocoder time-scale modification
+*   of audio", IEEE Trans. Speech and Audio Processing 7(3), 1999.
+*
+*   J. Laroche, M. Dolson, "New phase-vocoder techniques for pitch-shifting,
+*   harmonizing and other exotic effects", IEEE WASPAA, 1999.
+*
+* The plain phase vocoder advances the phase of every bin on its own, so the
+* bins that make up one sinusoid drift apart and the sound turns phasey.
+* Here each analysis frame is split into regions of influence around its
+* spectral peaks (bins louder than their two neighbours on either side), with
+* the boundaries at the quietest bin between adjacent peaks. Each region is
+* moved as a whole by the whole number of bins that takes its peak k to
+* round(k·ratio), which keeps the shape of the peak intact. Only the peak's
+* phase is propagated, at ratio times its measured frequency, starting from
+* the synthesis phase of the peak that owned this bin in the previous frame,
+* so a partial gliding across bins keeps a continuous phase (scaled phase
+* locking). Every other bin of the region is locked to the peak:
+*
+*   θ(k + Δ) = θ(peak + Δ) + β · (φ(k) − φ(peak))
+*
+* where φ are the analysis phases. β = 1 is identity phase locking; other
+* values scale the phase offsets.
+*
+* A frame whose bins rise on average by more than the reset threshold over
+* the previous frame (the positive spectral flux, in dB) is taken as an
+* onset: its synthesis phases restart from the analysis phases, which keeps
+* the attack as sharp as in the input instead of smearing it with propagated
+* phases.
+*
+*****************************************************************************/
+
+package algos
+
+import "math"
+
+// plvState holds shared phase-locked vocoder state.
+type plvState struct {
+	framed
+	ch      []plvChanState
+	beta    float64 // phase-locking scale
+	resetDB float64 // onset threshold; 0 disables phase resets
+	phase   []float64
+	peaks   []int // peak bins of the current frame
+	ends    []int // last bin of the region of each peak
+	owner   []int // [bins] output bin of the peak whose region each bin is in
+}
+
+// plvChanState holds per-channel phase-locked vocoder state.
+type plvChanState struct {
+	// prevOut is the output bin of the peak that owned each input bin in
+	// the previous frame, or -1.
+	prevOut []int
+	prevDB  []float64 // [bins] magnitudes of the previous frame in dB
+}
+
+// newPLVState allocates phase-locked vocoder state for the given Context.
+func newPLVState(ctx *Context) *plvState {
+	bins := ctx.FFTSize/2 + 1
+	st := &plvState{
+		framed:  framed{ctx},
+		ch:      make([]plvChanState, ctx.Channels),
+		beta:    ctx.Param(plvBeta),
+		resetDB: ctx.Param(plvReset),
+		phase:   make([]float64, bins),
+		peaks:   make([]int, 0, bins),
+		ends:    make([]int, 0, bins),
+		owner:   make([]int, bins),
+	}
+	for c := range st.ch {
+		st.ch[c].prevOut = make([]int, bins)
+		st.ch[c].prevDB = make([]float64, bins)
+	}
+	st.Reset()
+	return st
+}
+
+// Phase-locked vocoder parameters.
+var (
+	plvBeta  = Param{Name: "beta", Usage: "Scale of each bin's phase offset from its peak; 1 is identity locking", Kind: ParamFloat, Min: 0, Max: 2, Default: 1}
+	plvReset = Param{Name: "reset", Usage: "Mean rise of the bins that resets the phases at an onset; 0 disables", Kind: ParamFloat, Unit: "dB", Min: 0, Max: 24, Default: 6}
+)
+
+// Params returns the locking scale and onset threshold.
+func (st *plvState) Params() []Param {
+	return []Param{plvBeta, plvReset}
+}
+
+// Reset forgets the peaks and energy of the previous frames.
+func (st *plvState) Reset() {
+	for c := range st.ch {
+		for k := range st.ch[c].prevOut {
+			st.ch[c].prevOut[k] = -1
+			st.ch[c].prevDB[k] = plvFloorDB
+		}
+	}
+}
+
+// Process implements phase-locked vocoder pitch shifting (Laroche & Dolson
+// 1999).
+func (st *plvState) Process(output, input []byte) {
+	ctx := st.ctx
+	N, M := ctx.FFTFrameSize, ctx.FFTSize
+	half := M / 2
+	for c := 0; c < int(ctx.Channels); c++ {
+		ch := &st.ch[c]
+		numSamples := ctx.ReadChannel(input, c)
+		frameIndex := ctx.FrameIndex[c]
+
+		for i := 0; i < numSamples; i++ {
+			ctx.Frame[c][frameIndex] = ctx.F64Buf[i]
+			ctx.F64Buf[i] = ctx.Stack[c][frameIndex-ctx.Latency]
+			frameIndex++
+
+			if frameIndex >= N {
+				frameIndex = ctx.Latency
+				ctx.Hop(c)
+				ratio := ctx.Ratio(c)
+
+				// Windowing and zero padding
+				mulFloat64s(ctx.Reals[:N], ctx.Frame[c], ctx.Window)
+				for k := 0; k < N; k++ {
+					ctx.FFTData[k] = complex(ctx.Reals[k], 0)
+				}
+				clear(ctx.FFTData[N:])
+
+				// STFT
+				ctx.Forward.Execute(ctx.FFTData, ctx.FFTData)
+
+				// Analysis
+				for k := 0; k <= half; k++ {
+					ctx.Reals[k] = real(ctx.FFTData[k])
+					ctx.Imags[k] = imag(ctx.FFTData[k])
+				}
+				computeMagnitudes(ctx.Magnitudes[:half+1], ctx.Reals[:half+1], ctx.Imags[:half+1])
+				onset := st.resetDB > 0 && ch.flux(ctx.Magnitudes[:half+1]) > st.resetDB
+
+				for k := 0; k <= half; k++ {
+					st.phase[k] = math.Atan2(ctx.Imags[k], ctx.Reals[k])
+				}
+				st.findPeaks(ctx.Magnitudes[:half+1])
+
+				if ctx.formantsActive() {
+					ctx.flattenMagnitudes(ctx.Magnitudes[:half+1], ratio)
+				}
+
+				// Pitch shifting: move each region with its peak and lock
+				// its phases to the peak's.
+				clear(ctx.FFTData)
+				lo := 0
+				for r, p := range st.peaks {
+					hi := st.ends[r]
+					out := int(math.Round(float64(p) * ratio))
+					shift := out - p
+					owner := out
+					if out > half {
+						owner = -1
+					}
+
+					// Propagate the peak phase from the output bin of the
+					// peak that owned it last frame.
+					theta := st.phase[p]
+					if prev := ch.prevOut[p]; prev >= 0 && !onset {
+						diff := st.phase[p] - ctx.LastPhase[c][p] - float64(p)*ctx.Expected
+						diff -= 2 * math.Pi * math.Round(diff/(2*math.Pi))
+						theta = ctx.SumPhase[c][prev] + ratio*(float64(p)*ctx.Expected+diff)
+					}
+
+					for k := lo; k <= hi; k++ {
+						st.owner[k] = owner
+						l := k + shift
+						if l < 0 || l > half {
+							continue
+						}
+						offset := st.phase[k] - st.phase[p]
+						offset -= 2 * math.Pi * math.Round(offset/(2*math.Pi))
+						ph := theta + st.beta*offset
+						ctx.FFTData[l] += complex(ctx.Magnitudes[k]*math.Cos(ph), ctx.Magnitudes[k]*math.Sin(ph))
+					}
+					if owner >= 0 {
+						ctx.SumPhase[c][owner] = theta
+					}
+					lo = hi + 1
+				}
+				copy(ctx.LastPhase[c], st.phase)
+				if len(st.peaks) == 0 {
+					for k := range st.owner {
+						st.owner[k] = -1
+					}
+				}
+				copy(ch.prevOut, st.owner)
+
+				if ctx.formantsActive() {
+					ctx.shapeSpectrum(ctx.FFTData[:half+1])
+				}
+
+				// Inverse STFT
+				ctx.Inverse.Execute(ctx.FFTData, ctx.FFTData)
+
+				// Windowing of the frame span and add to output accumulator
+				// (SIMD); the padded tail is discarded.
+				for k := 0; k < N; k++ {
+					ctx.Reals[k] = real(ctx.FFTData[k])
+				}
+				mulAddFloat64s(ctx.OutAcc[c][:N], ctx.WindowFactors, ctx.Reals[:N])
+				copyFloat64s(ctx.Stack[c][:ctx.Step], ctx.OutAcc[c][:ctx.Step])
+
+				// Shift output accumulator and buffer (SIMD)
+				copyFloat64s(ctx.OutAcc[c][:N], ctx.OutAcc[c][ctx.Step:ctx.Step+N])
+				copyFloat64s(ctx.Frame[c][:ctx.Latency], ctx.Frame[c][ctx.Step:ctx.Step+ctx.Latency])
+			}
+		}
+
+		ctx.FrameIndex[c] = frameIndex
+
+		// Re-interleave and convert to output bytes
+		ctx.WriteChannel(output, c, numSamples)
+	}
+}
+
+// plvFloorDB keeps the level of silent bins finite.
+const plvFloorDB = -200.0
+
+// flux returns the mean rise in dB of the bins of mags over the previous
+// frame, counting only bins that got louder, and stores mags for the next.
+func (ch *plvChanState) flux(mags []float64) float64 {
+	sum := 0.0
+	for k, m := range mags {
+		db := plvFloorDB
+		if m > 0 {
+			db = math.Max(20*math.Log10(m), plvFloorDB)
+		}
+		sum += math.Max(db-ch.prevDB[k], 0)
+		ch.prevDB[k] = db
+	}
+	return sum / float64(len(mags))
+}
+
+// findPeaks collects the bins of mags that are louder than the two bins on
+// either side into peaks, and the end of each peak's region, the quietest bin
+// before the next peak, into ends.
+func (st *plvState) findPeaks(mags []float64) {
+	st.peaks = st.peaks[:0]
+	n := len(mags)
+	for k, m := range mags {
+		if m == 0 ||
+			(k >= 1 && mags[k-1] >= m) || (k >= 2 && mags[k-2] >= m) ||
+			(k+1 < n && mags[k+1] > m) || (k+2 < n && mags[k+2] > m) {
+			continue
+		}
+		st.peaks = append(st.peaks, k)
+	}
+
+	st.ends = st.ends[:0]
+	for r, a := range st.peaks {
+		end := n - 1
+		if r+1 < len(st.peaks) {
+			end = a
+			for k := a + 1; k < st.peaks[r+1]; k++ {
+				if mags[k] < mags[end] {
+					end = k
+				}
+			}
+		}
+		st.ends = append(st.ends, end)
+	}
+}
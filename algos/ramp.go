@@ -0,0 +1,66 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package algos
+
+import "math"
+
+// DefaultRamp is the Context.Ramp time constant in milliseconds.
+const DefaultRamp = 20.0
+
+// rampSnap is the distance from its target at which a ramp stops.
+const rampSnap = 1e-9
+
+// ramp smooths a control towards its target with a one-pole filter so that
+// changes do not click or zipper. It starts at the first target it is given.
+type ramp struct {
+	value   float64
+	started bool
+}
+
+// next moves the ramp towards target by coefficient k and returns the new
+// value. It snaps to target once within rampSnap.
+func (r *ramp) next(target, k float64) float64 {
+	switch {
+	case !r.started:
+		r.value, r.started = target, true
+	case math.Abs(target-r.value) < rampSnap:
+		r.value = target
+	default:
+		r.value += (target - r.value) * k
+	}
+	return r.value
+}
+
+// apply scales x by the ramp, advancing it one sample at a time.
+func (r *ramp) apply(x []float64, target, k float64) {
+	if r.started && r.value == target {
+		if target != 1 {
+			for i := range x {
+				x[i] *= target
+			}
+		}
+		return
+	}
+	for i := range x {
+		x[i] *= r.next(target, k)
+	}
+}
+
+// rampCoeff returns the one-pole coefficient for a step of n samples at the
+// Ramp time constant.
+func (c *Context) rampCoeff(n int) float64 {
+	return rampCoeff(n, c.SampleRate, c.Ramp)
+}
+
+// rampCoeff returns the one-pole coefficient for a step of n samples at
+// sampleRate with a time constant of ms milliseconds.
+func rampCoeff(n int, sampleRate, ms float64) float64 {
+	if ms <= 0 {
+		return 1
+	}
+	return 1 - math.Exp(-float64(n)*1000/(sampleRate*ms))
+}
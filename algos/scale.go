@@ -0,0 +1,153 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package algos
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ScaleNames lists the built-in scales accepted by ParseScale, for flag
+// help text and GUI selectors. Any other name is parsed as a custom set of
+// note names.
+var ScaleNames = []string{"chromatic", "major", "minor"}
+
+// KeyNames lists the pitch classes from C, as accepted by ParseScale.
+var KeyNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// scaleSteps holds the built-in scales as semitones above the key.
+var scaleSteps = map[string][]int{
+	"chromatic": {0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	"major":     {0, 2, 4, 5, 7, 9, 11},
+	"minor":     {0, 2, 3, 5, 7, 8, 10},
+}
+
+// Scale is a set of pitch classes with a key (tonic). Degrees are counted
+// upwards from the key.
+type Scale struct {
+	Key     int   // tonic pitch class, 0 = C
+	Classes []int // pitch classes in the scale, ascending from Key, mod 12
+}
+
+// ParseScale builds a Scale from a key name such as "C", "F#" or "Bb" and
+// either a built-in scale name (see ScaleNames) or a comma-separated list of
+// note names, e.g. "C,D,E,G,A".
+func ParseScale(key, scale string) (Scale, error) {
+	k, err := parseNoteName(key)
+	if err != nil {
+		return Scale{}, err
+	}
+	s := Scale{Key: k}
+	if steps, ok := scaleSteps[strings.ToLower(scale)]; ok {
+		for _, st := range steps {
+			s.Classes = append(s.Classes, (k+st)%12)
+		}
+		return s, nil
+	}
+
+	var in [12]bool
+	for _, name := range strings.Split(scale, ",") {
+		pc, err := parseNoteName(strings.TrimSpace(name))
+		if err != nil {
+			return Scale{}, fmt.Errorf("unknown scale %q — use one of %v or a list of notes such as C,D,E,G,A", scale, ScaleNames)
+		}
+		in[pc] = true
+	}
+	for i := 0; i < 12; i++ {
+		if pc := (k + i) % 12; in[pc] {
+			s.Classes = append(s.Classes, pc)
+		}
+	}
+	return s, nil
+}
+
+// parseNoteName returns the pitch class of a note name with an optional
+// sharp (#) or flat (b).
+func parseNoteName(name string) (int, error) {
+	if name == "" {
+		return 0, fmt.Errorf("empty note name")
+	}
+	pc := strings.Index("C D EF G A B", strings.ToUpper(name[:1]))
+	if pc < 0 || name[0] == ' ' {
+		return 0, fmt.Errorf("unknown note %q", name)
+	}
+	switch name[1:] {
+	case "":
+	case "#":
+		pc++
+	case "b":
+		pc--
+	default:
+		return 0, fmt.Errorf("unknown note %q", name)
+	}
+	return (pc + 12) % 12, nil
+}
+
+// contains reports whether pitch class pc is in the scale.
+func (s Scale) contains(pc int) bool {
+	for _, c := range s.Classes {
+		if c == pc {
+			return true
+		}
+	}
+	return false
+}
+
+// Nearest returns the in-scale MIDI note closest to the fractional MIDI
+// note n. It returns n unchanged for an empty scale.
+func (s Scale) Nearest(n float64) float64 {
+	best, bestDist := n, math.Inf(1)
+	base := int(math.Round(n))
+	for d := -6; d <= 6; d++ {
+		cand := base + d
+		if !s.contains((cand%12 + 12) % 12) {
+			continue
+		}
+		if dist := math.Abs(float64(cand) - n); dist < bestDist {
+			best, bestDist = float64(cand), dist
+		}
+	}
+	return best
+}
+
+// Transpose moves the MIDI note n by degrees steps of the scale, up for
+// positive degrees and down for negative ones, e.g. 2 degrees is a third.
+// n is first snapped to the nearest note of the scale; an empty scale returns
+// n unchanged.
+func (s Scale) Transpose(n float64, degrees int) float64 {
+	if len(s.Classes) == 0 {
+		return n
+	}
+	note := int(s.Nearest(n))
+	// Position of note within the scale and its semitone offset from the
+	// key, so degree arithmetic can wrap across octaves.
+	above := func(i int) int { return (s.Classes[i] - s.Key + 12) % 12 }
+	idx := 0
+	for i, pc := range s.Classes {
+		if pc == (note%12+12)%12 {
+			idx = i
+		}
+	}
+	steps := len(s.Classes)
+	total := idx + degrees
+	octaves := total / steps
+	if total%steps < 0 {
+		octaves--
+	}
+	j := total - octaves*steps
+	return float64(note - above(idx) + above(j) + 12*octaves)
+}
+
+// String describes the scale, e.g. "C: C D E F G A B".
+func (s Scale) String() string {
+	names := make([]string, len(s.Classes))
+	for i, pc := range s.Classes {
+		names[i] = KeyNames[pc]
+	}
+	return KeyNames[s.Key] + ": " + strings.Join(names, " ")
+}
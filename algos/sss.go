@@ -1,271 +1,465 @@
-/****************************************************************************
-*
-* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
-*
-*****************************************************************************
-*
-* "Based on Signalsmith Stretch" pitch-shifting algorithm.
-*
-* Inspired by and based on the design described in:
-*   G. Luff, "The Design of Signalsmith Stretch", 2023.
-*   https://signalsmith-audio.co.uk/writing/2023/stretch-design/
-*
-* Reference C++ implementation (MIT licence):
-*   https://github.com/Signalsmith-Audio/signalsmith-stretch
-*
-* Core idea: assemble each output STFT spectrum using a weighted blend of
-* multiple complex-valued phase predictions.  Predictions are formed by
-* multiplying a known output complex value by the observed phase change
-* ("twist") between the corresponding points in the input spectrum.  This
-* naturally weights predictions by the signal energy at the relevant
-* input positions, so strong tones dominate the blend and impose correct
-* phase relationships on nearby bins.
-*
-* Two-pass design per frame:
-*
-*   Pass 1 â€“ horizontal prediction (like a standard phase vocoder):
-*     For each output bin b, measure the phase change at the mapped input
-*     position between the previous and current frames, and apply it to the
-*     previous output bin.  This gives a horizontally-coherent first estimate.
-*
-*   Pass 2 â€“ vertical prediction (assembles from neighbouring bins):
-*     Iterate upwards from DC.  For each bin b combine four vertical predictors:
-*       â€¢ short upward:   from pass-2 result at bâˆ’1, using input twist from
-*                         (inBinâˆ’1) to inBin
-*       â€¢ long  upward:   from pass-2 result at bâˆ’L, using input twist from
-*                         (inBinâˆ’L) to inBin
-*       â€¢ short downward: from pass-1 result at b+1, using the reverse of
-*                         b+1's own upward twist
-*       â€¢ long  downward: from pass-1 result at b+L, using the reverse of
-*                         b+L's own upward twist
-*     All vertical twists are measured as fixed 1- or L-step offsets in the
-*     input bin space (not in output bin space), consistent with the reference
-*     implementation.  The final magnitude is set to |curInput[inBin]|.
-*
-* Omissions vs. the reference library:
-*   â€¢ No non-linear frequency map / peak detection
-*   â€¢ No formant preservation
-*   â€¢ No time-stretch (pitch-shift only, time factor = 1)
-*   â€¢ Single-resolution (no adaptive block size)
-*
-*****************************************************************************/
-
-package algos
-
-import "math"
-
-// sssState holds per-channel state for the SSS algorithm.
-type sssState struct {
-	prevInput  [][]complex128 // [ch][bins]: input spectrum from the previous frame
-	prevOutput [][]complex128 // [ch][bins]: pass-2 output from the previous frame
-	pass1Out   [][]complex128 // [ch][bins]: pass-1 (horizontal) output for this frame
-	curInput   [][]complex128 // [ch][bins]: current frame input spectrum (scratch)
-	longStep   int            // long vertical step in bins = round(N / step) = oversampling
-}
-
-// NewSSSState allocates state for the SSS algorithm.
-func NewSSSState(ctx *Context) interface{} {
-	bins := ctx.FFTFrameSize/2 + 1
-	nCh := int(ctx.Channels)
-	longStep := ctx.Oversampling
-	if longStep < 1 {
-		longStep = 1
-	}
-	st := &sssState{
-		prevInput:  make([][]complex128, nCh),
-		prevOutput: make([][]complex128, nCh),
-		pass1Out:   make([][]complex128, nCh),
-		curInput:   make([][]complex128, nCh),
-		longStep:   longStep,
-	}
-	for c := 0; c < nCh; c++ {
-		st.prevInput[c] = make([]complex128, bins)
-		st.prevOutput[c] = make([]complex128, bins)
-		st.pass1Out[c] = make([]complex128, bins)
-		st.curInput[c] = make([]complex128, bins)
-	}
-	return st
-}
-
-// sssInterp linearly interpolates a complex spectrum at fractional bin f.
-// Returns 0 for positions outside [0, len(spec)).
-func sssInterp(spec []complex128, f float64) complex128 {
-	n := len(spec)
-	if f < 0 || f >= float64(n) {
-		return 0
-	}
-	lo := int(f)
-	if lo >= n-1 {
-		return spec[n-1]
-	}
-	frac := f - float64(lo)
-	return complex(
-		real(spec[lo])*(1-frac)+real(spec[lo+1])*frac,
-		imag(spec[lo])*(1-frac)+imag(spec[lo+1])*frac,
-	)
-}
-
-// sssConjMul returns a * conj(b).
-func sssConjMul(a, b complex128) complex128 {
-	ar, ai := real(a), imag(a)
-	br, bi := real(b), imag(b)
-	return complex(ar*br+ai*bi, ai*br-ar*bi)
-}
-
-// sssSetMag returns a complex value with the phase of pred and magnitude |ref|.
-// Falls back to ref itself when pred is near-zero (no prediction signal).
-func sssSetMag(pred, ref complex128) complex128 {
-	pr, pi := real(pred), imag(pred)
-	pm2 := pr*pr + pi*pi
-	rr, ri := real(ref), imag(ref)
-	mag := math.Sqrt(rr*rr + ri*ri)
-	if pm2 < 1e-30 {
-		return ref // no prediction: preserve input phase
-	}
-	scale := mag / math.Sqrt(pm2)
-	return complex(pr*scale, pi*scale)
-}
-
-// ProcessSSS implements the "Based on Signalsmith Stretch" pitch-shifting
-// algorithm (Luff 2023 / Signalsmith-Audio/signalsmith-stretch).
-func ProcessSSS(ctx *Context, output, input []byte) {
-	byteDepth := ctx.BitDepth / 8
-	ratio := math.Exp2(ctx.PitchShift / 12.0)
-	st := ctx.AlgoState.(*sssState)
-	N := ctx.FFTFrameSize
-	bins := N/2 + 1
-	longStep := st.longStep
-
-	for c := 0; c < int(ctx.Channels); c++ {
-		numSamples := bytesToFloat64(ctx.F64Buf, input, ctx.Channels, ctx.BitDepth, c)
-		frameIndex := ctx.FrameIndex[c]
-
-		for i := 0; i < numSamples; i++ {
-			ctx.Frame[c][frameIndex] = ctx.F64Buf[i]
-			ctx.F64Buf[i] = ctx.Stack[c][frameIndex-ctx.Latency]
-			frameIndex++
-
-			if frameIndex >= N {
-				frameIndex = ctx.Latency
-
-				// â”€â”€ Window + forward FFT â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€
-				mulFloat64s(ctx.Reals[:N], ctx.Frame[c], ctx.Window)
-				for k := 0; k < N; k++ {
-					ctx.FFTData[k] = complex(ctx.Reals[k], 0)
-				}
-				ctx.Forward.Execute(ctx.FFTData, ctx.FFTData)
-
-				// Save the current half-spectrum before either pass overwrites
-				// FFTWData (pass 2 writes to FFTWData bin by bin).
-				for k := 0; k < bins; k++ {
-					st.curInput[c][k] = ctx.FFTData[k]
-				}
-
-				// â”€â”€ Pass 1: horizontal (phase-vocoder) prediction â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€
-				//
-				// For each output bin b, measure the phase change at the
-				// mapped input position between prevInput and curInput; apply
-				// it to the previous output value at bin b.  Vertical
-				// coherence is NOT yet enforced.
-				for b := 0; b < bins; b++ {
-					inBin := float64(b) / ratio
-					inC := sssInterp(st.curInput[c], inBin)
-					prevC := sssInterp(st.prevInput[c], inBin)
-					// twist = curInput[inBin] * conj(prevInput[inBin])
-					pred := st.prevOutput[c][b] * sssConjMul(inC, prevC)
-					st.pass1Out[c][b] = sssSetMag(pred, inC)
-				}
-
-				// â”€â”€ Pass 2: vertical predictions â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€
-				//
-				// Iterates upward (b = 0, 1, â€¦, binsâˆ’1).  Four predictors:
-				//
-				//   Upward short/long: use the pass-2 result already written
-				//     at bâˆ’1 / bâˆ’longStep (bins below, already stable).
-				//     Twist is measured from input position (inBin âˆ’ offset)
-				//     to inBin â€” a fixed 1- or L-step shift in input space,
-				//     matching the reference implementation.
-				//
-				//   Downward short/long: use the pass-1 result at b+1 /
-				//     b+longStep (bins above, pass-1 is still intact).
-				//     The twist at the upper bin is computed from its own
-				//     inBin, and then its conjugate is applied to predict
-				//     downward.
-				//
-				// The combined prediction is normalised to |curInput[inBin]|.
-				for b := 0; b < bins; b++ {
-					inBin := float64(b) / ratio
-					inC := sssInterp(st.curInput[c], inBin)
-
-					var phase complex128
-
-					// Upward short (from pass-2 bin bâˆ’1, already written)
-					if b > 0 {
-						inCBelow := sssInterp(st.curInput[c], inBin-1)
-						phase += ctx.FFTData[b-1] * sssConjMul(inC, inCBelow)
-					}
-
-					// Upward long (from pass-2 bin bâˆ’longStep)
-					if b >= longStep {
-						inCBelowL := sssInterp(st.curInput[c], inBin-float64(longStep))
-						phase += ctx.FFTData[b-longStep] * sssConjMul(inC, inCBelowL)
-					}
-
-					// Downward short (from pass-1 bin b+1)
-					if b < bins-1 {
-						inBin1 := float64(b+1) / ratio
-						inC1 := sssInterp(st.curInput[c], inBin1)
-						inC1Below := sssInterp(st.curInput[c], inBin1-1)
-						twistUp1 := sssConjMul(inC1, inC1Below)
-						phase += sssConjMul(st.pass1Out[c][b+1], twistUp1)
-					}
-
-					// Downward long (from pass-1 bin b+longStep)
-					if b+longStep < bins {
-						inBinL := float64(b+longStep) / ratio
-						inCL := sssInterp(st.curInput[c], inBinL)
-						inCLBelow := sssInterp(st.curInput[c], inBinL-float64(longStep))
-						twistUpL := sssConjMul(inCL, inCLBelow)
-						phase += sssConjMul(st.pass1Out[c][b+longStep], twistUpL)
-					}
-
-					ctx.FFTData[b] = sssSetMag(phase, inC)
-				}
-
-				// Save pass-2 output and current input for the next frame.
-				for k := 0; k < bins; k++ {
-					st.prevOutput[c][k] = ctx.FFTData[k]
-					st.prevInput[c][k] = st.curInput[c][k]
-				}
-
-				// â”€â”€ Mirror conjugate + inverse FFT + OLA â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€
-				for k := 1; k < N/2; k++ {
-					ctx.FFTData[N-k] = complex(
-						real(ctx.FFTData[k]),
-						-imag(ctx.FFTData[k]),
-					)
-				}
-				ctx.FFTData[N/2] = complex(real(ctx.FFTData[N/2]), 0)
-
-				ctx.Inverse.Execute(ctx.FFTData, ctx.FFTData)
-
-				for k := 0; k < N; k++ {
-					ctx.Reals[k] = real(ctx.FFTData[k])
-				}
-				mulAddFloat64s(ctx.OutAcc[c][:N], ctx.WindowFactors, ctx.Reals[:N])
-				copyFloat64s(ctx.Stack[c][:ctx.Step], ctx.OutAcc[c][:ctx.Step])
-				copyFloat64s(ctx.OutAcc[c][:N], ctx.OutAcc[c][ctx.Step:ctx.Step+N])
-				copyFloat64s(ctx.Frame[c][:ctx.Latency], ctx.Frame[c][ctx.Step:ctx.Step+ctx.Latency])
-			}
-		}
-
-		ctx.FrameIndex[c] = frameIndex
-
-		stride := int(byteDepth * ctx.Channels)
-		off := c * int(byteDepth)
-		for i := 0; i < numSamples; i++ {
-			float64ToFloat32Bytes(output, off, ctx.F64Buf[i]*ctx.Volume)
-			off += stride
-		}
-	}
-}
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+*****************************************************************************
+*
+* "Based on Signalsmith Stretch" pitch-shifting algorithm.
+*
+* Inspired by and based on the design described in:
+*   G. Luff, "The Design of Signalsmith Stretch", 2023.
+*   https://signalsmith-audio.co.uk/writing/2023/stretch-design/
+*
+* Reference C++ implementation (MIT licence):
+*   https://github.com/Signalsmith-Audio/signalsmith-stretch
+*
+* Core idea: assemble each output STFT spectrum using a weighted blend of
+* multiple complex-valued phase predictions.  Predictions are formed by
+* multiplying a known output complex value by the observed phase change
+* ("twist") between the corresponding points in the input spectrum.  This
+* naturally weights predictions by the signal energy at the relevant
+* input positions, so strong tones dominate the blend and impose correct
+* phase relationships on nearby bins.
+*
+* Two-pass design per frame:
+*
+*   Pass 1 â€“ horizontal prediction (like a standard phase vocoder):
+*     For each output bin b, measure the phase change at the mapped input
+*     position between the previous and current frames, and apply it to the
+*     previous output bin.  This gives a horizontally-coherent first estimate.
+*
+*   Pass 2 â€“ vertical prediction (assembles from neighbouring bins):
+*     Iterate upwards from DC.  For each bin b combine four vertical predictors:
+*       â€¢ short upward:   from pass-2 result at bâˆ’1, using input twist from
+*                         (inBinâˆ’1) to inBin
+*       â€¢ long  upward:   from pass-2 result at bâˆ’L, using input twist from
+*                         (inBinâˆ’L) to inBin
+*       â€¢ short downward: from pass-1 result at b+1, using the reverse of
+*                         b+1's own upward twist
+*       â€¢ long  downward: from pass-1 result at b+L, using the reverse of
+*                         b+L's own upward twist
+*     All vertical twists are measured as fixed 1- or L-step offsets in the
+*     input bin space (not in output bin space), consistent with the reference
+*     implementation.  The final magnitude is set to |curInput[inBin]|.
+*
+*   Non-linear frequency map (tonality limit, as in the reference library):
+*     above an effective limit F = tonality / sqrt(ratio) partials are moved
+*     by the fixed offset (ratio - 1) * F instead of being multiplied by the
+*     ratio, so noisy high bands keep their spectral shape.  Each output
+*     bin reads the input at the inverse of this map.
+*
+*   Energy-weighted blend (blend > 0): the pass-1 horizontal prediction,
+*     scaled by the energy of its input bin and by blend, joins the four
+*     vertical predictors in pass 2, so each bin mixes the two in
+*     proportion to the energy behind them and steady tones keep their
+*     horizontal phase advance.  Pass 1 then advances each bin at the mapped
+*     frequency measured at its input position, rather than at the input
+*     frequency.
+*
+*   Split computation (split = 1): each frame is captured when it fills
+*     and its analysis, two passes and synthesis are spread in sssUnits
+*     steps over the following hop, which evens out the CPU load per block
+*     at the cost of one more hop of latency.  The output is otherwise
+*     identical.
+*
+*   With the parameters at their defaults the algorithm is unchanged.
+*
+* Omissions vs. the reference library:
+*   - No time-stretch (pitch-shift only, time factor = 1)
+*   - Single-resolution (no adaptive block size)
+*
+*****************************************************************************/
+
+package algos
+
+import "math"
+
+// sssChunks is the number of bin ranges each pass is split into when the
+// computation is spread over a hop; sssUnits counts all the steps of a frame:
+// analysis, both passes and synthesis.
+const (
+	sssChunks = 4
+	sssUnits  = 2*sssChunks + 2
+)
+
+// sssState holds shared state for the SSS algorithm.
+type sssState struct {
+	framed
+	ch       []sssChanState
+	longStep int     // long vertical step in bins, FFTSize / Step by default
+	tonality float64 // tonality limit in bins, 0 for a linear map
+	blend    float64 // weight of the horizontal prediction in pass 2
+	split    bool    // spread each frame over the following hop
+}
+
+// sssChanState holds per-channel state for the SSS algorithm.
+type sssChanState struct {
+	prevInput  []complex128 // [bins]: input spectrum from the previous frame
+	prevOutput []complex128 // [bins]: pass-2 output from the previous frame
+	pass1Out   []complex128 // [bins]: pass-1 (horizontal) output for this frame
+	curInput   []complex128 // [bins]: current frame input spectrum
+	spec       []complex128 // [FFTSize]: transform and pass-2 output
+	frame      []float64    // [FFTFrameSize]: captured input frame
+	inBins     []float64    // [bins]: input position read by each output bin
+	target     []float64    // [bins]: formant envelope of the captured frame
+	ratio      float64      // ratio of the captured frame
+	stage      int          // next unit of the captured frame to run
+}
+
+// newSSSState allocates state for the SSS algorithm.
+func newSSSState(ctx *Context) *sssState {
+	bins := ctx.FFTSize/2 + 1
+	st := &sssState{
+		framed:   framed{ctx},
+		ch:       make([]sssChanState, ctx.Channels),
+		longStep: int(ctx.Param(sssLongStep(ctx))),
+		tonality: ctx.Param(sssTonality) / ctx.FreqPerBin,
+		blend:    ctx.Param(sssBlend),
+		split:    ctx.Param(sssSplit) != 0,
+	}
+	for c := range st.ch {
+		st.ch[c] = sssChanState{
+			prevInput:  make([]complex128, bins),
+			prevOutput: make([]complex128, bins),
+			pass1Out:   make([]complex128, bins),
+			curInput:   make([]complex128, bins),
+			spec:       make([]complex128, ctx.FFTSize),
+			frame:      make([]float64, ctx.FFTFrameSize),
+			inBins:     make([]float64, bins),
+			target:     make([]float64, bins),
+		}
+	}
+	st.Reset()
+	return st
+}
+
+// sssInterp linearly interpolates a complex spectrum at fractional bin f.
+// Returns 0 for positions outside [0, len(spec)).
+func sssInterp(spec []complex128, f float64) complex128 {
+	n := len(spec)
+	if f < 0 || f >= float64(n) {
+		return 0
+	}
+	lo := int(f)
+	if lo >= n-1 {
+		return spec[n-1]
+	}
+	frac := f - float64(lo)
+	return complex(
+		real(spec[lo])*(1-frac)+real(spec[lo+1])*frac,
+		imag(spec[lo])*(1-frac)+imag(spec[lo+1])*frac,
+	)
+}
+
+// sssConjMul returns a * conj(b).
+func sssConjMul(a, b complex128) complex128 {
+	ar, ai := real(a), imag(a)
+	br, bi := real(b), imag(b)
+	return complex(ar*br+ai*bi, ai*br-ar*bi)
+}
+
+// sssSetMag returns a complex value with the phase of pred and magnitude |ref|.
+// Falls back to ref itself when pred is near-zero (no prediction signal).
+func sssSetMag(pred, ref complex128) complex128 {
+	pr, pi := real(pred), imag(pred)
+	pm2 := pr*pr + pi*pi
+	rr, ri := real(ref), imag(ref)
+	mag := math.Sqrt(rr*rr + ri*ri)
+	if pm2 < 1e-30 {
+		return ref // no prediction: preserve input phase
+	}
+	scale := mag / math.Sqrt(pm2)
+	return complex(pr*scale, pi*scale)
+}
+
+// sssLongStep is the long vertical step parameter. Its default, FFTSize /
+// Step, is the oversampling times the zero padding; the range grows to
+// include it when that exceeds 64.
+func sssLongStep(ctx *Context) Param {
+	def := max(ctx.FFTSize/ctx.Step, 1)
+	return Param{Name: "longStep", Usage: "Long vertical prediction step", Kind: ParamInt, Unit: "bins", Min: 1, Max: float64(max(def, 64)), Default: float64(def)}
+}
+
+// SSS parameters beyond the long step; their defaults leave the algorithm
+// as it was before they were added.
+var (
+	sssTonality = Param{Name: "tonality", Usage: "Tonality limit above which partials are offset rather than scaled; 0 scales all", Kind: ParamFloat, Unit: "Hz", Min: 0, Max: 20000, Default: 0}
+	sssBlend    = Param{Name: "blend", Usage: "Weight of the energy-weighted horizontal prediction in pass 2; 0 uses vertical predictions only", Kind: ParamFloat, Min: 0, Max: 2, Default: 0}
+	sssSplit    = Param{Name: "split", Usage: "Spread each frame's computation over the next hop: 1 on, 0 off", Kind: ParamInt, Min: 0, Max: 1, Default: 0}
+)
+
+// Params returns the long vertical step, tonality limit, blend and split.
+func (st *sssState) Params() []Param {
+	return []Param{sssLongStep(st.ctx), sssTonality, sssBlend, sssSplit}
+}
+
+// Latency adds the hop of the split computation.
+func (st *sssState) Latency() int {
+	if st.split {
+		return st.framed.Latency() + st.ctx.Step
+	}
+	return st.framed.Latency()
+}
+
+// Reset discards the previous spectra.
+func (st *sssState) Reset() {
+	for c := range st.ch {
+		ch := &st.ch[c]
+		clear(ch.prevInput)
+		clear(ch.prevOutput)
+		clear(ch.pass1Out)
+		clear(ch.curInput)
+		clear(ch.spec)
+		clear(ch.frame)
+		clear(ch.inBins)
+		clear(ch.target)
+		ch.ratio = 0
+		ch.stage = sssUnits
+	}
+}
+
+// Process implements the "Based on Signalsmith Stretch" pitch-shifting
+// algorithm (Luff 2023 / Signalsmith-Audio/signalsmith-stretch).
+func (st *sssState) Process(output, input []byte) {
+	ctx := st.ctx
+	N := ctx.FFTFrameSize
+
+	for c := 0; c < int(ctx.Channels); c++ {
+		numSamples := ctx.ReadChannel(input, c)
+		frameIndex := ctx.FrameIndex[c]
+
+		for i := 0; i < numSamples; i++ {
+			ctx.Frame[c][frameIndex] = ctx.F64Buf[i]
+			ctx.F64Buf[i] = ctx.Stack[c][frameIndex-ctx.Latency]
+			frameIndex++
+
+			if st.split {
+				// Keep pace with the hop: by its last sample every unit of
+				// the captured frame has run.
+				st.run(c, (frameIndex-ctx.Latency)*sssUnits/ctx.Step)
+			}
+
+			if frameIndex >= N {
+				frameIndex = ctx.Latency
+				ctx.Hop(c)
+				st.capture(c, ctx.Ratio(c))
+				if !st.split {
+					st.run(c, sssUnits)
+				}
+
+				copyFloat64s(ctx.Stack[c][:ctx.Step], ctx.OutAcc[c][:ctx.Step])
+				copyFloat64s(ctx.OutAcc[c][:N], ctx.OutAcc[c][ctx.Step:ctx.Step+N])
+				copyFloat64s(ctx.Frame[c][:ctx.Latency], ctx.Frame[c][ctx.Step:ctx.Step+ctx.Latency])
+			}
+		}
+
+		ctx.FrameIndex[c] = frameIndex
+
+		ctx.WriteChannel(output, c, numSamples)
+	}
+}
+
+// capture takes the frame of channel c that has just filled, and maps each
+// output bin to the input position it reads for ratio.
+func (st *sssState) capture(c int, ratio float64) {
+	ch := &st.ch[c]
+	copyFloat64s(ch.frame, st.ctx.Frame[c])
+	ch.ratio = ratio
+	ch.stage = 0
+
+	limit := st.tonality / math.Sqrt(ratio)
+	for b := range ch.inBins {
+		f := float64(b)
+		if st.tonality == 0 || f <= limit*ratio {
+			ch.inBins[b] = f / ratio
+		} else {
+			ch.inBins[b] = f - (ratio-1)*limit
+		}
+	}
+}
+
+// mapBin returns the output position of input position f under the
+// frequency map of channel c.
+func (st *sssState) mapBin(c int, f float64) float64 {
+	ratio := st.ch[c].ratio
+	limit := st.tonality / math.Sqrt(ratio)
+	if st.tonality == 0 || f <= limit {
+		return f * ratio
+	}
+	return f + (ratio-1)*limit
+}
+
+// run runs the units of the captured frame of channel c up to, but not
+// including, unit upto.
+func (st *sssState) run(c, upto int) {
+	ch := &st.ch[c]
+	bins := len(ch.inBins)
+	for ; ch.stage < min(upto, sssUnits); ch.stage++ {
+		switch u := ch.stage; {
+		case u == 0:
+			st.analyse(c)
+		case u <= sssChunks:
+			st.horizontal(c, (u-1)*bins/sssChunks, u*bins/sssChunks)
+		case u <= 2*sssChunks:
+			u -= sssChunks
+			st.vertical(c, (u-1)*bins/sssChunks, u*bins/sssChunks)
+		default:
+			st.synthesise(c)
+		}
+	}
+}
+
+// analyse windows and transforms the captured frame of channel c.
+func (st *sssState) analyse(c int) {
+	ctx := st.ctx
+	ch := &st.ch[c]
+	N := ctx.FFTFrameSize
+
+	// â”€â”€ Window + forward FFT â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€
+	mulFloat64s(ctx.Reals[:N], ch.frame, ctx.Window)
+	for k := 0; k < N; k++ {
+		ch.spec[k] = complex(ctx.Reals[k], 0)
+	}
+	clear(ch.spec[N:])
+	ctx.Forward.Execute(ch.spec, ch.spec)
+
+	// Save the current half-spectrum before pass 2 overwrites spec bin by
+	// bin. The formant envelope is kept per channel, as another channel may
+	// be analysed before this one is synthesised.
+	copy(ch.curInput, ch.spec)
+	if ctx.formantsActive() {
+		ctx.flattenSpectrum(ch.curInput, ch.ratio)
+		copy(ch.target, ctx.formant.target)
+	}
+}
+
+// horizontal runs pass 1 of channel c over output bins [lo, hi).
+func (st *sssState) horizontal(c, lo, hi int) {
+	ctx := st.ctx
+	ch := &st.ch[c]
+
+	// â”€â”€ Pass 1: horizontal (phase-vocoder) prediction â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€
+	//
+	// For each output bin b, measure the phase change at the
+	// mapped input position between prevInput and curInput; apply
+	// it to the previous output value at bin b.  Vertical
+	// coherence is NOT yet enforced.
+	//
+	// With blend on, the twist is replaced by the phase advance of the
+	// mapped frequency measured at the input position.
+	for b := lo; b < hi; b++ {
+		inBin := ch.inBins[b]
+		inC := sssInterp(ch.curInput, inBin)
+		prevC := sssInterp(ch.prevInput, inBin)
+		// twist = curInput[inBin] * conj(prevInput[inBin])
+		twist := sssConjMul(inC, prevC)
+		if st.blend > 0 {
+			dev := math.Atan2(imag(twist), real(twist)) - inBin*ctx.Expected
+			dev -= 2 * math.Pi * math.Round(dev/(2*math.Pi))
+			advance := st.mapBin(c, inBin+dev/ctx.Expected) * ctx.Expected
+			mag := math.Hypot(real(twist), imag(twist))
+			twist = complex(mag*math.Cos(advance), mag*math.Sin(advance))
+		}
+		pred := ch.prevOutput[b] * twist
+		ch.pass1Out[b] = sssSetMag(pred, inC)
+	}
+}
+
+// vertical runs pass 2 of channel c over output bins [lo, hi), which must
+// follow the bins below.
+func (st *sssState) vertical(c, lo, hi int) {
+	ch := &st.ch[c]
+	bins := len(ch.inBins)
+	longStep := st.longStep
+
+	// â”€â”€ Pass 2: vertical predictions â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€
+	//
+	// Iterates upward (b = 0, 1, â€¦, binsâˆ’1).  Four predictors:
+	//
+	//   Upward short/long: use the pass-2 result already written
+	//     at bâˆ’1 / bâˆ’longStep (bins below, already stable).
+	//     Twist is measured from input position (inBin âˆ’ offset)
+	//     to inBin â€” a fixed 1- or L-step shift in input space,
+	//     matching the reference implementation.
+	//
+	//   Downward short/long: use the pass-1 result at b+1 /
+	//     b+longStep (bins above, pass-1 is still intact).
+	//     The twist at the upper bin is computed from its own
+	//     inBin, and then its conjugate is applied to predict
+	//     downward.
+	//
+	// The combined prediction is normalised to |curInput[inBin]|.
+	//
+	// With blend on, the pass-1 prediction for b joins them, weighted by
+	// blend and by the energy |curInput[inBin]|^2 of its input position,
+	// which puts it on the same scale as the vertical predictors.
+	for b := lo; b < hi; b++ {
+		inBin := ch.inBins[b]
+		inC := sssInterp(ch.curInput, inBin)
+
+		var phase complex128
+
+		// Upward short (from pass-2 bin bâˆ’1, already written)
+		if b > 0 {
+			inCBelow := sssInterp(ch.curInput, inBin-1)
+			phase += ch.spec[b-1] * sssConjMul(inC, inCBelow)
+		}
+
+		// Upward long (from pass-2 bin bâˆ’longStep)
+		if b >= longStep {
+			inCBelowL := sssInterp(ch.curInput, inBin-float64(longStep))
+			phase += ch.spec[b-longStep] * sssConjMul(inC, inCBelowL)
+		}
+
+		// Downward short (from pass-1 bin b+1)
+		if b < bins-1 {
+			inBin1 := ch.inBins[b+1]
+			inC1 := sssInterp(ch.curInput, inBin1)
+			inC1Below := sssInterp(ch.curInput, inBin1-1)
+			twistUp1 := sssConjMul(inC1, inC1Below)
+			phase += sssConjMul(ch.pass1Out[b+1], twistUp1)
+		}
+
+		// Downward long (from pass-1 bin b+longStep)
+		if b+longStep < bins {
+			inBinL := ch.inBins[b+longStep]
+			inCL := sssInterp(ch.curInput, inBinL)
+			inCLBelow := sssInterp(ch.curInput, inBinL-float64(longStep))
+			twistUpL := sssConjMul(inCL, inCLBelow)
+			phase += sssConjMul(ch.pass1Out[b+longStep], twistUpL)
+		}
+
+		if st.blend > 0 {
+			phase += complex(st.blend*(real(inC)*real(inC)+imag(inC)*imag(inC)), 0) * ch.pass1Out[b]
+		}
+
+		ch.spec[b] = sssSetMag(phase, inC)
+	}
+}
+
+// synthesise transforms pass 2 of channel c back and overlap-adds it.
+func (st *sssState) synthesise(c int) {
+	ctx := st.ctx
+	ch := &st.ch[c]
+	N := ctx.FFTFrameSize
+	bins := len(ch.inBins)
+
+	// Save pass-2 output and current input for the next frame.
+	copy(ch.prevOutput, ch.spec[:bins])
+	copy(ch.prevInput, ch.curInput)
+	if ctx.formantsActive() {
+		for k := range ch.target {
+			ch.spec[k] *= complex(ch.target[k], 0)
+		}
+	}
+
+	// â”€â”€ Mirror conjugate + inverse FFT + OLA â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€
+	mirrorSpectrum(ch.spec)
+
+	ctx.Inverse.Execute(ch.spec, ch.spec)
+
+	for k := 0; k < N; k++ {
+		ctx.Reals[k] = real(ch.spec[k])
+	}
+	mulAddFloat64s(ctx.OutAcc[c][:N], ctx.WindowFactors, ctx.Reals[:N])
+}
@@ -0,0 +1,246 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+*****************************************************************************
+*
+* Time-Domain Pitch-Synchronous Overlap-Add (TD-PSOLA) pitch-shifting
+* algorithm.
+*
+* Based on:
+*   E. Moulines & F. Charpentier,
+*   "Pitch-synchronous waveform processing techniques for text-to-speech
+*    synthesis using diphones", Speech Communication 9(5-6), 1990.
+*
+* Unlike PSOLA, which resamples fixed-length grains, TD-PSOLA works
+* period by period:
+*
+*   1. Analysis marks are placed one detected period apart, each snapped to
+*      the largest sample (the glottal pulse) within a quarter period of its
+*      predicted position.
+*   2. A grain of two local periods, Hann-windowed and centred on an
+*      analysis mark, is extracted for every synthesis mark.
+*   3. Synthesis marks are spaced at the analysis mark spacing divided by the
+*      pitch ratio; each takes the grain of the nearest analysis mark.
+*      Grains are not resampled, so the spectral envelope (formants) is
+*      preserved.
+*
+* Unvoiced or silent input falls back to marks at a fixed short spacing with
+* synthesis marks at the same positions, i.e. unshifted grains.
+*
+* Timeline: all marks are absolute sample times. Frame[c][k] and
+* OutAcc[c][k] both correspond to time frameStart + k, matching the other
+* algorithms, so a synthesis grain at time s is added at OutAcc[s −
+* frameStart]. A grain must be complete before its start is drained, which
+* bounds the longest usable period to (FFTFrameSize − Step) / 4; longer
+* periods are treated as unvoiced.
+*
+*****************************************************************************/
+
+package algos
+
+import "math"
+
+// tdpsolaVoicedConfidence is the detector confidence below which a frame is
+// treated as unvoiced.
+const tdpsolaVoicedConfidence = 0.8
+
+// tdpsolaUnvoicedPeriod is the mark spacing for unvoiced input, in seconds.
+const tdpsolaUnvoicedPeriod = 0.005
+
+// tdpsolaMark is an analysis mark at absolute input time pos.
+type tdpsolaMark struct {
+	pos    int64
+	period int
+	voiced bool
+}
+
+// tdpsolaState holds shared TD-PSOLA state.
+type tdpsolaState struct {
+	framed
+	ch        []tdpsolaChanState
+	maxPeriod int // longest period whose grains fit the pipeline
+	uvPeriod  int // mark spacing for unvoiced input
+}
+
+// tdpsolaChanState holds per-channel TD-PSOLA state.
+type tdpsolaChanState struct {
+	hist       []float64     // last 2·N input samples, newest last
+	marks      []tdpsolaMark // pending analysis marks in ascending time
+	nextSynth  float64       // absolute time of the next synthesis mark
+	frameStart int64         // absolute time of Frame[c][0]
+}
+
+// newTDPSOLAState allocates TD-PSOLA state for the given Context.
+func newTDPSOLAState(ctx *Context) *tdpsolaState {
+	maxPeriod := (ctx.FFTFrameSize - ctx.Step) / 4
+	uvPeriod := min(int(ctx.SampleRate*tdpsolaUnvoicedPeriod), maxPeriod)
+	st := &tdpsolaState{
+		framed:    framed{ctx},
+		ch:        make([]tdpsolaChanState, ctx.Channels),
+		maxPeriod: maxPeriod,
+		uvPeriod:  max(uvPeriod, 1),
+	}
+	for c := range st.ch {
+		st.ch[c] = tdpsolaChanState{
+			hist:  make([]float64, 2*ctx.FFTFrameSize),
+			marks: make([]tdpsolaMark, 0, ctx.FFTFrameSize),
+		}
+	}
+	st.Reset()
+	return st
+}
+
+// Reset discards the pitch marks and input history.
+func (st *tdpsolaState) Reset() {
+	for c := range st.ch {
+		// Frame[c][0] starts Latency samples before the first input
+		// sample; the first synthesis mark coincides with the first
+		// analysis mark.
+		ch := &st.ch[c]
+		clear(ch.hist)
+		ch.marks = ch.marks[:0]
+		ch.nextSynth = float64(st.uvPeriod - st.ctx.Latency)
+		ch.frameStart = int64(-st.ctx.Latency)
+	}
+}
+
+// Process implements Time-Domain Pitch-Synchronous Overlap-Add pitch
+// shifting (Moulines & Charpentier 1990).
+func (st *tdpsolaState) Process(output, input []byte) {
+	ctx := st.ctx
+	N := ctx.FFTFrameSize
+	H := len(st.ch[0].hist)
+
+	for c := 0; c < int(ctx.Channels); c++ {
+		ch := &st.ch[c]
+		numSamples := ctx.ReadChannel(input, c)
+		frameIndex := ctx.FrameIndex[c]
+
+		for i := 0; i < numSamples; i++ {
+			ctx.Frame[c][frameIndex] = ctx.F64Buf[i]
+			ctx.F64Buf[i] = ctx.Stack[c][frameIndex-ctx.Latency]
+			frameIndex++
+
+			if frameIndex >= N {
+				frameIndex = ctx.Latency
+				ctx.Hop(c)
+				ratio := ctx.Ratio(c)
+
+				frameEnd := ch.frameStart + int64(N)
+				histStart := frameEnd - int64(H)
+				copyFloat64s(ch.hist[:H-ctx.Step], ch.hist[ctx.Step:])
+				copyFloat64s(ch.hist[H-ctx.Step:], ctx.Frame[c][N-ctx.Step:N])
+				sample := func(t int64) float64 {
+					if t < histStart || t >= frameEnd {
+						return 0
+					}
+					return ch.hist[t-histStart]
+				}
+
+				// ── Pitch estimate for this frame ──────────────────────────
+				period := 0
+				if est := ctx.framePitch(c); est.Voiced(tdpsolaVoicedConfidence) {
+					period = int(math.Round(ctx.SampleRate / est.F0))
+					if period < 2 || period > st.maxPeriod {
+						period = 0
+					}
+				}
+
+				// ── Extend analysis marks up to the newest usable sample ──
+				for {
+					last := ch.frameStart
+					lastVoiced := false
+					if n := len(ch.marks); n > 0 {
+						last = ch.marks[n-1].pos
+						lastVoiced = ch.marks[n-1].voiced
+					}
+					var m tdpsolaMark
+					if period > 0 {
+						// Snap to the glottal pulse: the largest sample
+						// within a quarter period of the prediction, or
+						// within the next period when voicing starts.
+						lo, hi := last+int64(period-period/4), last+int64(period+period/4)
+						if !lastVoiced {
+							lo, hi = last+1, last+int64(period)
+						}
+						// Wait until the whole search range and the grain
+						// around it are available.
+						if hi+int64(period) > frameEnd {
+							break
+						}
+						m = tdpsolaMark{pos: lo, period: period, voiced: true}
+						for t := lo + 1; t <= hi; t++ {
+							if sample(t) > sample(m.pos) {
+								m.pos = t
+							}
+						}
+					} else {
+						m = tdpsolaMark{pos: last + int64(st.uvPeriod), period: st.uvPeriod}
+						if m.pos+int64(m.period) > frameEnd {
+							break
+						}
+					}
+					if len(ch.marks) == cap(ch.marks) {
+						break
+					}
+					ch.marks = append(ch.marks, m)
+				}
+
+				// ── Overlap-add a grain at each synthesis mark ─────────────
+				for {
+					// The nearest analysis mark must have a successor to
+					// set the synthesis spacing.
+					k := -1
+					for j := 0; j+1 < len(ch.marks); j++ {
+						if float64(ch.marks[j+1].pos) > ch.nextSynth {
+							k = j
+							if float64(ch.marks[j+1].pos)-ch.nextSynth < ch.nextSynth-float64(ch.marks[j].pos) {
+								k = j + 1
+							}
+							break
+						}
+					}
+					if k < 0 || k+1 >= len(ch.marks) {
+						break
+					}
+					a := ch.marks[k]
+					spacing := float64(ch.marks[k+1].pos - a.pos)
+					if a.voiced {
+						spacing /= ratio
+					}
+					p := a.period
+					gain := spacing / float64(p)
+					s := int64(math.Round(ch.nextSynth))
+					for j := -p; j < p; j++ {
+						idx := s + int64(j) - ch.frameStart
+						if idx < 0 || idx >= int64(len(ctx.OutAcc[c])) {
+							continue
+						}
+						w := 0.5 - 0.5*math.Cos(math.Pi*float64(j+p)/float64(p))
+						ctx.OutAcc[c][idx] += gain * w * sample(a.pos+int64(j))
+					}
+					ch.nextSynth += spacing
+				}
+
+				// Drop marks that can no longer be the nearest.
+				drop := 0
+				for drop+1 < len(ch.marks) && float64(ch.marks[drop+1].pos) <= ch.nextSynth {
+					drop++
+				}
+				ch.marks = append(ch.marks[:0], ch.marks[drop:]...)
+
+				// Drain hop-sized chunk and slide the buffers.
+				copyFloat64s(ctx.Stack[c][:ctx.Step], ctx.OutAcc[c][:ctx.Step])
+				copyFloat64s(ctx.OutAcc[c][:2*N-ctx.Step], ctx.OutAcc[c][ctx.Step:2*N])
+				zeroFloat64s(ctx.OutAcc[c][2*N-ctx.Step : 2*N])
+				copyFloat64s(ctx.Frame[c][:ctx.Latency], ctx.Frame[c][ctx.Step:ctx.Step+ctx.Latency])
+				ch.frameStart += int64(ctx.Step)
+			}
+		}
+
+		ctx.FrameIndex[c] = frameIndex
+
+		ctx.WriteChannel(output, c, numSamples)
+	}
+}
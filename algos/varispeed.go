@@ -0,0 +1,199 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+*****************************************************************************
+*
+* Varispeed resampler for time stretching.
+*
+* The input is read back at Speed input samples per output sample with
+* 4-point Catmull-Rom interpolation, like a tape played at a different
+* speed: duration scales by 1/Speed and pitch by Speed. Running the result
+* through a pitch-shifting algorithm with Context.TimeStretch = 1/Speed
+* restores the original pitch, so tempo and pitch become independent.
+*
+* Offline, Write and Read exchange whole blocks and the output simply grows
+* or shrinks. Live, input and output rates are fixed, so Process keeps at
+* most Span samples of input buffered: when the read head catches up with
+* the input it jumps back half a span (repeating material), and when it
+* falls a full span behind it jumps forward half a span (skipping material),
+* with an equal-power crossfade between the old and new read positions.
+*
+*****************************************************************************/
+
+package algos
+
+import (
+	"math"
+
+	"github.com/intermernet/pitcher/wavio"
+)
+
+// varispeedFade is the crossfade length of a live read-head jump, in
+// seconds.
+const varispeedFade = 0.02
+
+// Time stretch limits accepted by the CLI and GUI.
+const (
+	MinTimeStretch = 0.25
+	MaxTimeStretch = 4.0
+)
+
+// Varispeed resamples a multichannel signal at a variable speed.
+type Varispeed struct {
+	// Speed is the number of input samples consumed per output sample.
+	Speed float64
+	// Span is the most input, in samples, that Process keeps buffered.
+	Span int
+
+	format   wavio.SampleFormat
+	channels int
+	buf      [][]float64 // per-channel ring of the newest input
+	write    int64       // absolute time of the next input sample
+	read     float64     // absolute time of the read head
+	old      float64     // read head being faded out after a jump
+	fadeLeft int         // samples of crossfade remaining
+	fade     int         // crossfade length
+	planes   [][]float64 // Process scratch
+}
+
+// NewVarispeed returns a Varispeed at unity speed for channels channels of
+// format, buffering at most span samples of input in Process.
+func NewVarispeed(format wavio.SampleFormat, channels, span int, sampleRate float64) *Varispeed {
+	v := &Varispeed{
+		Speed:    1,
+		Span:     span,
+		format:   format,
+		channels: channels,
+		buf:      make([][]float64, channels),
+		planes:   make([][]float64, channels),
+		fade:     max(1, min(int(sampleRate*varispeedFade), span/4)),
+	}
+	for c := range v.buf {
+		// Twice the span leaves room for the block written before each
+		// Process call and for the fading read head.
+		v.buf[c] = make([]float64, 2*span+4)
+		v.planes[c] = make([]float64, MaxBlock)
+	}
+	return v
+}
+
+// Reset empties the buffer and restarts the read head, for when the
+// varispeed is brought back into a signal path.
+func (v *Varispeed) Reset() {
+	for c := range v.buf {
+		clear(v.buf[c])
+	}
+	v.write, v.read, v.fadeLeft = 0, 0, 0
+}
+
+// sample returns input sample t of channel c, or 0 outside the buffer.
+func (v *Varispeed) sample(c int, t int64) float64 {
+	n := int64(len(v.buf[c]))
+	if t < 0 || t >= v.write || t < v.write-n {
+		return 0
+	}
+	return v.buf[c][t%n]
+}
+
+// at interpolates channel c at fractional time t.
+func (v *Varispeed) at(c int, t float64) float64 {
+	i := int64(math.Floor(t))
+	f := t - float64(i)
+	y0, y1, y2, y3 := v.sample(c, i-1), v.sample(c, i), v.sample(c, i+1), v.sample(c, i+2)
+	return y1 + 0.5*f*(y2-y0+f*(2*y0-5*y1+4*y2-y3+f*(3*(y1-y2)+y3-y0)))
+}
+
+// Write appends the first n samples of each plane to the input.
+func (v *Varispeed) Write(planes [][]float64, n int) {
+	size := int64(len(v.buf[0]))
+	for c := range v.buf {
+		for i, s := range planes[c][:n] {
+			v.buf[c][(v.write+int64(i))%size] = s
+		}
+	}
+	v.write += int64(n)
+}
+
+// Flush appends enough silence for Read to consume every input sample.
+func (v *Varispeed) Flush() {
+	size := int64(len(v.buf[0]))
+	for c := range v.buf {
+		for t := v.write; t < v.write+3; t++ {
+			v.buf[c][t%size] = 0
+		}
+	}
+	v.write += 3
+}
+
+// Read resamples up to len(planes[0]) output samples that the buffered
+// input fully covers into planes and returns how many it wrote. It never
+// jumps, so the output length tracks the input length divided by Speed.
+func (v *Varispeed) Read(planes [][]float64) int {
+	n := 0
+	for n < len(planes[0]) && int64(math.Floor(v.read))+2 < v.write {
+		for c := range planes {
+			planes[c][n] = v.at(c, v.read)
+		}
+		v.read += v.Speed
+		n++
+	}
+	return n
+}
+
+// Process writes one block of interleaved input and reads the same number
+// of frames back into output, jumping the read head to stay within Span.
+func (v *Varispeed) Process(output, input []byte) {
+	size := v.format.Size()
+	frames := len(input) / (size * v.channels)
+	for c := range v.planes {
+		v.planes[c] = v.planes[c][:frames]
+		decodeChannel(v.planes[c], input, v.format, v.channels, c)
+	}
+	if v.write == 0 {
+		// Start far enough behind the input that unity speed never
+		// jumps.
+		v.read = -float64(v.fade + 4)
+	}
+	v.Write(v.planes, frames)
+
+	for i := 0; i < frames; i++ {
+		if v.fadeLeft == 0 {
+			lag := float64(v.write) - v.read
+			switch {
+			case lag < v.Speed*float64(v.fade)+4:
+				// Never jump back before the first input sample.
+				v.jump(-min(float64(v.Span/2), math.Max(v.read, 0)))
+			case lag > float64(v.Span):
+				v.jump(float64(v.Span / 2))
+			}
+		}
+		g := 1.0
+		if v.fadeLeft > 0 {
+			g = math.Sin(0.5 * math.Pi * float64(v.fade-v.fadeLeft) / float64(v.fade))
+		}
+		for c := range v.planes {
+			s := g * v.at(c, v.read)
+			if v.fadeLeft > 0 {
+				s += math.Sqrt(1-g*g) * v.at(c, v.old)
+			}
+			v.planes[c][i] = s
+		}
+		if v.fadeLeft > 0 {
+			v.old += v.Speed
+			v.fadeLeft--
+		}
+		v.read += v.Speed
+	}
+
+	for c := range v.planes {
+		encodeChannel(output, v.format, v.channels, c, v.planes[c], nil)
+	}
+}
+
+// jump moves the read head by d samples, fading out of the old position.
+func (v *Varispeed) jump(d float64) {
+	v.old = v.read
+	v.read += d
+	v.fadeLeft = v.fade
+}
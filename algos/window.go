@@ -0,0 +1,203 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+*****************************************************************************
+*
+* Analysis and synthesis windows.
+*
+* The frame-based algorithms window each analysis frame with
+* Context.Window. The STFT algorithms window the resynthesised frame again
+* with WindowFactors before overlap-adding it, and the grain algorithms
+* overlap-add the analysis grains directly, scaled by GrainGain. TD-PSOLA
+* builds its own pitch-synchronous grains and ignores the choice.
+*
+* Rather than a fixed constant tuned for Hann, the synthesis window is the
+* least-squares dual of the analysis window (Griffin & Lim 1984): each
+* position is divided by the sum of the squared windows that overlap it at
+* the current hop, so an unmodified spectrum reconstructs the input exactly,
+* at a fixed gain, for any window and oversampling. Where that sum nearly
+* vanishes (a Hann window with no overlap) it is floored to keep the edges
+* from being boosted without limit.
+*
+* Asymmetric windows place the peak late in the frame. The newest input then
+* dominates each analysis, which shortens the effective delay of the
+* spectral estimate while keeping the frame length.
+*
+*****************************************************************************/
+
+package algos
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// WindowNames lists the window shapes accepted by ParseWindow.
+var WindowNames = []string{"hann", "hamming", "blackmanharris", "kaiser", "sqrthann", "asym"}
+
+// Window shape parameter defaults.
+const (
+	// DefaultKaiserBeta gives sidelobes close to Blackman-Harris.
+	DefaultKaiserBeta = 10.0
+	// DefaultAsymPeak is the peak position of "asym" as a fraction of the
+	// frame.
+	DefaultAsymPeak = 0.75
+)
+
+// windowFloor is the fraction of the largest squared-window overlap sum
+// below which the synthesis normalisation stops growing.
+const windowFloor = 0.01
+
+// stftGain is the level of the STFT algorithms relative to their input.
+// They were tuned with a Hann synthesis factor of 2/(N·oversampling) against
+// a squared-window overlap of 3·oversampling/8, which plays at three
+// quarters of the input level; keeping it means a window change alters the
+// reconstruction but not the loudness.
+const stftGain = 0.75
+
+// DefaultWindow is the window of a new Context.
+var DefaultWindow = WindowShape{Name: "hann"}
+
+// WindowShape selects the analysis window of a Context. Param is the beta
+// of "kaiser" and the peak position (0–1 of the frame) of "asym"; the other
+// shapes ignore it.
+type WindowShape struct {
+	Name  string
+	Param float64
+}
+
+// ParseWindow parses a window as name[:param], e.g. "kaiser:8" or
+// "asym:0.8". Without a param, kaiser and asym use DefaultKaiserBeta and
+// DefaultAsymPeak.
+func ParseWindow(spec string) (WindowShape, error) {
+	name, param, hasParam := strings.Cut(spec, ":")
+	w := WindowShape{Name: strings.ToLower(name)}
+	switch w.Name {
+	case "kaiser":
+		w.Param = DefaultKaiserBeta
+	case "asym":
+		w.Param = DefaultAsymPeak
+	}
+	if hasParam {
+		v, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return WindowShape{}, fmt.Errorf("window %q: bad parameter %q", spec, param)
+		}
+		w.Param = v
+	}
+	if err := w.check(); err != nil {
+		return WindowShape{}, err
+	}
+	return w, nil
+}
+
+// String returns the ParseWindow spec of w.
+func (w WindowShape) String() string {
+	switch w.Name {
+	case "kaiser", "asym":
+		return w.Name + ":" + strconv.FormatFloat(w.Param, 'g', -1, 64)
+	}
+	return w.Name
+}
+
+// check validates the name and parameter of w.
+func (w WindowShape) check() error {
+	switch w.Name {
+	case "hann", "hamming", "blackmanharris", "sqrthann":
+	case "kaiser":
+		if !(w.Param >= 0 && w.Param <= 50) {
+			return fmt.Errorf("window %s: beta must be between 0 and 50", w)
+		}
+	case "asym":
+		if !(w.Param >= 0.5 && w.Param <= 0.95) {
+			return fmt.Errorf("window %s: peak must be between 0.5 and 0.95", w)
+		}
+	default:
+		return fmt.Errorf("unknown window %q — valid options: %v", w.Name, WindowNames)
+	}
+	return nil
+}
+
+// fill writes the periodic window of len(dst) samples into dst.
+func (w WindowShape) fill(dst []float64) {
+	n := float64(len(dst))
+	for i := range dst {
+		t := 2 * math.Pi * float64(i) / n
+		switch w.Name {
+		case "hann":
+			dst[i] = 0.5 - 0.5*math.Cos(t)
+		case "hamming":
+			dst[i] = 0.54 - 0.46*math.Cos(t)
+		case "blackmanharris":
+			dst[i] = 0.35875 - 0.48829*math.Cos(t) + 0.14128*math.Cos(2*t) - 0.01168*math.Cos(3*t)
+		case "kaiser":
+			x := 2*float64(i)/n - 1
+			dst[i] = besselI0(w.Param*math.Sqrt(1-x*x)) / besselI0(w.Param)
+		case "sqrthann":
+			dst[i] = math.Sqrt(0.5 - 0.5*math.Cos(t))
+		case "asym":
+			// Rising half-Hann up to the peak, falling half-Hann after it.
+			peak := w.Param * n
+			if x := float64(i); x < peak {
+				dst[i] = 0.5 - 0.5*math.Cos(math.Pi*x/peak)
+			} else {
+				dst[i] = 0.5 + 0.5*math.Cos(math.Pi*(x-peak)/(n-peak))
+			}
+		}
+	}
+}
+
+// besselI0 returns the modified Bessel function of the first kind of order
+// zero, summed from its power series.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	q := x * x / 4
+	for k := 1.0; term > sum*1e-17; k++ {
+		term *= q / (k * k)
+		sum += term
+	}
+	return sum
+}
+
+// SetWindow switches the analysis window, recomputes the synthesis
+// normalisation and rebuilds the Processor, which restarts from silence as
+// after SetAlgorithm. It returns an error, changing nothing, if w is not a
+// valid window.
+func (c *Context) SetWindow(w WindowShape) error {
+	if err := w.check(); err != nil {
+		return err
+	}
+	c.WindowShape = w
+	c.makeWindows()
+	c.build()
+	return nil
+}
+
+// makeWindows fills Window with WindowShape and derives WindowFactors and
+// GrainGain for the current frame size and oversampling.
+func (c *Context) makeWindows() {
+	n := c.FFTFrameSize
+	c.WindowShape.fill(c.Window)
+
+	// Squared-window overlap sum at every position of the frame, built in
+	// WindowFactors and then divided into the window in place.
+	overlap := c.WindowFactors
+	clear(overlap)
+	peak, sum := 0.0, 0.0
+	for i, w := range c.Window {
+		for k := 0; k < c.Oversampling; k++ {
+			overlap[(i+k*c.Step)%n] += w * w
+		}
+		sum += w
+	}
+	for _, o := range overlap {
+		peak = max(peak, o)
+	}
+	for i, w := range c.Window {
+		c.WindowFactors[i] = w * stftGain / (float64(c.FFTSize) * max(overlap[i], windowFloor*peak))
+	}
+	c.GrainGain = float64(c.Step) / sum
+}
@@ -1,180 +1,239 @@
-/****************************************************************************
-*
-* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
-*
-*****************************************************************************
-*
-* Waveform Similarity Overlap-Add (WSOLA) pitch-shifting algorithm.
-*
-* Based on:
-*   W. Verhelst, M. Roelands,
-*   "An Overlap-Add Technique Based on Waveform Similarity (WSOLA)
-*    for High Quality Time-Scale Modification of Speech",
-*   IEEE ICASSP, 1993.
-*
-* The key difference from basic PSOLA: instead of always taking the analysis
-* grain from the ideal position, WSOLA searches backward by up to delta =
-* Step samples to find the starting point whose beginning most closely
-* matches the current synthesis overlap region (maximum cross-correlation).
-* This suppresses discontinuities at grain boundaries and produces noticeably
-* cleaner output on voiced speech and tonal instruments.
-*
-* For pitch shifting, the best-matching grain is then resampled to the target
-* ratio via linear interpolation â€” identical to the PSOLA resampling step.
-*
-* Buffer layout at each frame:
-*
-*   searchBuf:  [ prevDelta (delta samples) | Frame[c] (N samples) ]
-*                 t-(N+delta-1)  â€¦  t-N      t-(N-1)  â€¦  t
-*
-*   Grain at offset d (d âˆˆ [0, delta]):
-*     d = delta â†’ Frame[c] exactly  (ideal, no backward shift)
-*     d = 0     â†’ delta samples before Frame[c] start  (maximum backward shift)
-*
-*   CC(d) = Î£_k  prevOut[k] Â· searchBuf[d + k],  k âˆˆ [0, Step)
-*
-*****************************************************************************/
-
-package algos
-
-import "math"
-
-// wsolaState holds shared WSOLA algorithm state.
-type wsolaState struct {
-	ch        []wsolaChanState
-	delta     int       // search radius = Step (set by NewWSOLAState)
-	searchBuf []float64 // scratch: [prevDelta | Frame[c]], length = delta + N
-}
-
-// wsolaChanState holds per-channel WSOLA state.
-type wsolaChanState struct {
-	// delta oldest samples from the previous frame fire; forms the backward
-	// extension of searchBuf beyond Frame[c][0].
-	prevDelta []float64
-	// hopSize OLA output samples saved after the current grain is added.
-	// Used as the waveform-similarity reference for the next frame.
-	prevOut []float64
-	hasRef  bool
-}
-
-// NewWSOLAState allocates WSOLA state for the given Context.
-func NewWSOLAState(ctx *Context) interface{} {
-	delta := ctx.Step
-	st := &wsolaState{
-		ch:        make([]wsolaChanState, ctx.Channels),
-		delta:     delta,
-		searchBuf: make([]float64, ctx.FFTFrameSize+delta),
-	}
-	for c := range st.ch {
-		st.ch[c] = wsolaChanState{
-			prevDelta: make([]float64, delta),
-			prevOut:   make([]float64, ctx.Step),
-		}
-	}
-	return st
-}
-
-// ProcessWSOLA implements Waveform Similarity Overlap-Add pitch shifting
-// (Verhelst & Roelands, ICASSP 1993).
-func ProcessWSOLA(ctx *Context, output, input []byte) {
-	byteDepth := ctx.BitDepth / 8
-	ratio := math.Exp2(ctx.PitchShift / 12.0)
-	st := ctx.AlgoState.(*wsolaState)
-	grainSize := ctx.FFTFrameSize
-	hopSize := ctx.Step
-	delta := st.delta
-
-	for c := 0; c < int(ctx.Channels); c++ {
-		ch := &st.ch[c]
-		numSamples := bytesToFloat64(ctx.F64Buf, input, ctx.Channels, ctx.BitDepth, c)
-		frameIndex := ctx.FrameIndex[c]
-
-		for i := 0; i < numSamples; i++ {
-			ctx.Frame[c][frameIndex] = ctx.F64Buf[i]
-			ctx.F64Buf[i] = ctx.Stack[c][frameIndex-ctx.Latency]
-			frameIndex++
-
-			if frameIndex >= grainSize {
-				frameIndex = ctx.Latency
-
-				// Build search buffer:
-				//   searchBuf[0:delta]          = prevDelta  (older samples)
-				//   searchBuf[delta:delta+N]    = Frame[c]   (current frame)
-				copyFloat64s(st.searchBuf[:delta], ch.prevDelta)
-				copyFloat64s(st.searchBuf[delta:delta+grainSize], ctx.Frame[c][:grainSize])
-
-				// Search for the best backward offset d âˆˆ [0, delta].
-				// d = delta â†’ ideal grain (= Frame[c], no shift).
-				// d < delta â†’ grain starts earlier in time (better waveform match).
-				//
-				// CC(d) = Î£_k prevOut[k] Â· searchBuf[d+k], maximised over the
-				// overlap region (hopSize samples) per Verhelst & Roelands Â§2.
-				bestD := delta
-				if ch.hasRef {
-					var bestVal float64
-					for d := 0; d <= delta; d++ {
-						cc := dotFloat64s(ch.prevOut[:hopSize], st.searchBuf[d:d+hopSize])
-						if absCC := math.Abs(cc); d == 0 || absCC > bestVal {
-							bestVal = absCC
-							bestD = d
-						}
-					}
-				}
-
-				// Window the chosen grain into ctx.Reals.
-				mulFloat64s(ctx.Reals[:grainSize], st.searchBuf[bestD:bestD+grainSize], ctx.Window)
-
-				// Resample to the synthesis grain length (same as PSOLA).
-				// Scale = 1.0: Hanning OLA at 50% overlap gives perfect
-				// reconstruction for ratio = 1 without any additional scaling.
-				synGrainLen := int(math.Round(float64(grainSize) / ratio))
-				if synGrainLen < 1 {
-					synGrainLen = 1
-				}
-				if synGrainLen > 2*grainSize {
-					synGrainLen = 2 * grainSize
-				}
-				for k := 0; k < synGrainLen && k < len(ctx.OutAcc[c]); k++ {
-					var srcPos float64
-					if synGrainLen > 1 {
-						srcPos = float64(k) * float64(grainSize-1) / float64(synGrainLen-1)
-					}
-					lo := int(srcPos)
-					hi := lo + 1
-					if hi >= grainSize {
-						hi = grainSize - 1
-					}
-					frac := srcPos - float64(lo)
-					ctx.OutAcc[c][k] += ctx.Reals[lo]*(1-frac) + ctx.Reals[hi]*frac
-				}
-
-				// Save the OLA overlap region as the CC reference for the next
-				// frame. This must happen after adding the current grain but
-				// before draining, so it reflects the overlap the next grain
-				// will be joined to.
-				copyFloat64s(ch.prevOut[:hopSize], ctx.OutAcc[c][:hopSize])
-				ch.hasRef = true
-
-				// Save the delta oldest samples of Frame[c] before the input
-				// frame shift overwrites them. These become the backward
-				// extension of searchBuf in the next frame fire.
-				copyFloat64s(ch.prevDelta, ctx.Frame[c][:delta])
-
-				// Drain, shift output accumulator, slide input frame.
-				copyFloat64s(ctx.Stack[c][:hopSize], ctx.OutAcc[c][:hopSize])
-				copyFloat64s(ctx.OutAcc[c][:2*grainSize-hopSize], ctx.OutAcc[c][hopSize:2*grainSize])
-				zeroFloat64s(ctx.OutAcc[c][2*grainSize-hopSize : 2*grainSize])
-				copyFloat64s(ctx.Frame[c][:ctx.Latency], ctx.Frame[c][hopSize:hopSize+ctx.Latency])
-			}
-		}
-
-		ctx.FrameIndex[c] = frameIndex
-
-		stride := int(byteDepth * ctx.Channels)
-		off := c * int(byteDepth)
-		for i := 0; i < numSamples; i++ {
-			float64ToFloat32Bytes(output, off, ctx.F64Buf[i]*ctx.Volume)
-			off += stride
-		}
-	}
-}
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+*****************************************************************************
+*
+* Waveform Similarity Overlap-Add (WSOLA) pitch-shifting algorithm.
+*
+* Based on:
+*   W. Verhelst, M. Roelands,
+*   "An Overlap-Add Technique Based on Waveform Similarity (WSOLA)
+*    for High Quality Time-Scale Modification of Speech",
+*   IEEE ICASSP, 1993.
+*
+* The key difference from basic PSOLA: instead of always taking the analysis
+* grain from the ideal position, WSOLA searches a tolerance region of delta
+* samples (the delta parameter, one hop by default) for the starting point
+* whose grain best continues the previous one: the grain is compared with
+* the natural progression of the previous grain, the samples that would have
+* followed it in the output had it been longer (maximum normalised
+* cross-correlation).
+* This suppresses discontinuities at grain boundaries and produces noticeably
+* cleaner output on voiced speech and tonal instruments.
+*
+* For pitch shifting, the best-matching grain is then resampled to the target
+* ratio via linear interpolation â€” identical to the PSOLA resampling step.
+*
+* Buffer layout at each frame:
+*
+*   searchBuf:  [ prevDelta (delta samples) | Frame[c] (N samples) ]
+*                 t-(N+delta-1)  â€¦  t-N      t-(N-1)  â€¦  t
+*
+*   Grain at offset d (d âˆˆ [0, delta]):
+*     d = delta â†’ Frame[c] exactly  (no backward shift)
+*     d = 0     â†’ delta samples before Frame[c] start  (maximum backward shift)
+*
+* The search is centred on d = delta/2, where the first grain is taken and
+* where every grain stays while the signal is unshifted, so the algorithm
+* delays its output by delta - delta/2 samples more than PSOLA; Latency
+* reports the sum. Shifted, the grains wander either side of the centre.
+*
+*   CC(d) = sum_k ref[k] * grain_d[k] / |grain_d|,  k in [0, Step)
+*
+* where ref is the natural progression of the previous grain and grain_d the
+* start of the candidate grain, both resampled to the output rate.
+*
+*****************************************************************************/
+
+package algos
+
+import "math"
+
+// wsolaState holds shared WSOLA algorithm state.
+type wsolaState struct {
+	framed
+	ch        []wsolaChanState
+	delta     int       // search range, at most Step
+	centre    int       // offset of the grain while unshifted, delta/2
+	searchBuf []float64 // scratch: [prevDelta | Frame[c]], length = delta + N
+	cand      []float64 // scratch: the start of a candidate grain
+}
+
+// wsolaChanState holds per-channel WSOLA state.
+type wsolaChanState struct {
+	// delta samples preceding Frame[c][0], saved at the previous frame fire;
+	// forms the backward extension of searchBuf.
+	prevDelta []float64
+	// Natural progression of the previous grain: up to hopSize resampled
+	// input samples following its first hop. Used as the
+	// waveform-similarity reference for the next frame.
+	ref    []float64
+	refLen int // valid samples of ref; 0 before the first grain
+}
+
+// newWSOLAState allocates WSOLA state for the given Context.
+func newWSOLAState(ctx *Context) *wsolaState {
+	delta := int(ctx.Param(wsolaDelta(ctx)))
+	st := &wsolaState{
+		framed:    framed{ctx},
+		ch:        make([]wsolaChanState, ctx.Channels),
+		delta:     delta,
+		centre:    delta / 2,
+		searchBuf: make([]float64, ctx.FFTFrameSize+delta),
+		cand:      make([]float64, ctx.Step),
+	}
+	for c := range st.ch {
+		st.ch[c] = wsolaChanState{
+			prevDelta: make([]float64, delta),
+			ref:       make([]float64, ctx.Step),
+		}
+	}
+	return st
+}
+
+// wsolaDelta is the search range parameter. Only the last hop of the
+// previous frame is kept, so it can reach back at most one hop.
+func wsolaDelta(ctx *Context) Param {
+	return Param{Name: "delta", Usage: "Search range for the best-matching grain", Kind: ParamInt, Unit: "samples", Min: 0, Max: float64(ctx.Step), Default: float64(ctx.Step)}
+}
+
+// Params returns the search range.
+func (st *wsolaState) Params() []Param {
+	return []Param{wsolaDelta(st.ctx)}
+}
+
+// Latency adds the backward offset of the centre of the search to the
+// framed delay.
+func (st *wsolaState) Latency() int {
+	return st.framed.Latency() + st.delta - st.centre
+}
+
+// Reset discards the similarity references.
+func (st *wsolaState) Reset() {
+	for c := range st.ch {
+		ch := &st.ch[c]
+		clear(ch.prevDelta)
+		clear(ch.ref)
+		ch.refLen = 0
+	}
+}
+
+// Process implements Waveform Similarity Overlap-Add pitch shifting
+// (Verhelst & Roelands, ICASSP 1993).
+func (st *wsolaState) Process(output, input []byte) {
+	ctx := st.ctx
+	grainSize := ctx.FFTFrameSize
+	hopSize := ctx.Step
+	delta := st.delta
+
+	for c := 0; c < int(ctx.Channels); c++ {
+		ch := &st.ch[c]
+		numSamples := ctx.ReadChannel(input, c)
+		frameIndex := ctx.FrameIndex[c]
+
+		for i := 0; i < numSamples; i++ {
+			ctx.Frame[c][frameIndex] = ctx.F64Buf[i]
+			ctx.F64Buf[i] = ctx.Stack[c][frameIndex-ctx.Latency]
+			frameIndex++
+
+			if frameIndex >= grainSize {
+				frameIndex = ctx.Latency
+				ctx.Hop(c)
+				ratio := ctx.Ratio(c)
+
+				// Build search buffer:
+				//   searchBuf[0:delta]          = prevDelta  (older samples)
+				//   searchBuf[delta:delta+N]    = Frame[c]   (current frame)
+				copyFloat64s(st.searchBuf[:delta], ch.prevDelta)
+				copyFloat64s(st.searchBuf[delta:delta+grainSize], ctx.Frame[c][:grainSize])
+
+				// Resampling step from the synthesis grain length (same as
+				// PSOLA). GrainGain = 1 for Hann OLA at 50% overlap, which
+				// gives perfect reconstruction for ratio = 1.
+				synGrainLen := int(math.Round(float64(grainSize) / ratio))
+				if synGrainLen < 1 {
+					synGrainLen = 1
+				}
+				if synGrainLen > 2*grainSize {
+					synGrainLen = 2 * grainSize
+				}
+				var step float64
+				if synGrainLen > 1 {
+					step = float64(grainSize-1) / float64(synGrainLen-1)
+				}
+
+				// Search d in [0, delta] for the grain whose start best
+				// matches the natural progression of the previous grain.
+				// The centre wins unless another offset correlates better,
+				// so an unshifted signal keeps a constant delay.
+				bestD := st.centre
+				if n := ch.refLen; n > 0 {
+					bestVal := math.Inf(-1)
+					for d := range delta + 1 {
+						for k := range n {
+							st.cand[k] = st.at(float64(d) + float64(k)*step)
+						}
+						energy := dotFloat64s(st.cand[:n], st.cand[:n])
+						if energy == 0 {
+							continue
+						}
+						cc := dotFloat64s(ch.ref[:n], st.cand[:n]) / math.Sqrt(energy)
+						if cc > bestVal || (cc == bestVal && d == st.centre) {
+							bestVal, bestD = cc, d
+						}
+					}
+				}
+
+				// Window the chosen grain into ctx.Reals.
+				mulFloat64s(ctx.Reals[:grainSize], st.searchBuf[bestD:bestD+grainSize], ctx.Window)
+
+				// Resample to the synthesis grain length.
+				for k := 0; k < synGrainLen && k < len(ctx.OutAcc[c]); k++ {
+					srcPos := float64(k) * step
+					lo := int(srcPos)
+					hi := lo + 1
+					if hi >= grainSize {
+						hi = grainSize - 1
+					}
+					frac := srcPos - float64(lo)
+					ctx.OutAcc[c][k] += ctx.GrainGain * (ctx.Reals[lo]*(1-frac) + ctx.Reals[hi]*frac)
+				}
+
+				// Save the natural progression of this grain, unwindowed, as
+				// the reference for the next frame: the samples that follow
+				// its first hop, as far as the grain reaches.
+				ch.refLen = max(min(hopSize, synGrainLen-hopSize), 0)
+				for k := range ch.refLen {
+					ch.ref[k] = st.at(float64(bestD) + float64(hopSize+k)*step)
+				}
+
+				// Save the delta samples that will precede Frame[c][0] after
+				// the input frame shift overwrites them. These become the
+				// backward extension of searchBuf in the next frame fire.
+				copyFloat64s(ch.prevDelta, ctx.Frame[c][hopSize-delta:hopSize])
+
+				// Drain, shift output accumulator, slide input frame.
+				copyFloat64s(ctx.Stack[c][:hopSize], ctx.OutAcc[c][:hopSize])
+				copyFloat64s(ctx.OutAcc[c][:2*grainSize-hopSize], ctx.OutAcc[c][hopSize:2*grainSize])
+				zeroFloat64s(ctx.OutAcc[c][2*grainSize-hopSize : 2*grainSize])
+				copyFloat64s(ctx.Frame[c][:ctx.Latency], ctx.Frame[c][hopSize:hopSize+ctx.Latency])
+			}
+		}
+
+		ctx.FrameIndex[c] = frameIndex
+
+		ctx.WriteChannel(output, c, numSamples)
+	}
+}
+
+// at interpolates searchBuf at pos, holding the last sample beyond its end.
+func (st *wsolaState) at(pos float64) float64 {
+	last := len(st.searchBuf) - 1
+	lo := int(pos)
+	if lo >= last {
+		return st.searchBuf[last]
+	}
+	frac := pos - float64(lo)
+	return st.searchBuf[lo]*(1-frac) + st.searchBuf[lo+1]*frac
+}
@@ -1,199 +1,491 @@
-/****************************************************************************
-*
-* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
-*
-****************************************************************************/
-
-package main
-
-import (
-	"fmt"
-	"log"
-	"strconv"
-	"strings"
-
-	"fyne.io/fyne/v2"
-	"fyne.io/fyne/v2/app"
-	"fyne.io/fyne/v2/container"
-	"fyne.io/fyne/v2/data/binding"
-	"fyne.io/fyne/v2/widget"
-	"github.com/intermernet/gominiaudio"
-	"github.com/intermernet/pitcher/algos"
-)
-
-var window fyne.Window
-
-func gui(s *shifter, inputs, outputs []gominiaudio.DeviceInfo, initialInputIdx, initialOutputIdx int, restartAudio func(*gominiaudio.DeviceID, *gominiaudio.DeviceID)) fyne.Window {
-	shiftApp := app.New()
-
-	// Define app icon and set window title / size
-	icon, err := fyne.LoadResourceFromPath("resource/audio-waveform-icon-8.jpg")
-	if err != nil {
-		log.Fatalln("icon file not found")
-	}
-	shiftApp.SetIcon(icon)
-	w := shiftApp.NewWindow("Pitcher")
-	w.Resize(fyne.NewSize(800, 320))
-
-	// Static device info
-	excl := "No"
-	if s.exclusive {
-		excl = "Yes"
-	}
-	info := widget.NewLabel(fmt.Sprintf(
-		"Channels: %d  |  Sample Rate: %d Hz  |  Periods: %d  |  Buffer: %d frames  |  Exclusive: %s",
-		s.Channels, int(s.SampleRate), s.periods, s.bufferSize, excl))
-	info.Wrapping = fyne.TextWrapWord
-
-	// Latency display — updated whenever frame size or oversampling changes
-	latencyStr := binding.NewString()
-	updateLatency := func() {
-		latencyStr.Set(fmt.Sprintf("Latency: %.1f ms", float64(s.Latency)/s.SampleRate*1000.0))
-	}
-	updateLatency()
-	latencyLabel := widget.NewLabelWithData(latencyStr)
-
-	// Track current DSP settings so each selector can pass the other's value
-	// when calling ReinitContext.
-	currentFrameSize := s.FFTFrameSize
-	currentOversampling := s.Oversampling
-
-	// Frame size selector
-	frameSizeSelect := widget.NewSelect([]string{"256", "512", "1024", "2048", "4096"}, nil)
-	frameSizeSelect.SetSelected(strconv.Itoa(s.FFTFrameSize))
-	frameSizeSelect.OnChanged = func(v string) {
-		fs, _ := strconv.Atoi(v)
-		s.ReinitContext(fs, currentOversampling)
-		currentFrameSize = fs
-		updateLatency()
-	}
-
-	// Oversampling selector
-	oversamplingSelect := widget.NewSelect([]string{"1", "2", "4", "8"}, nil)
-	oversamplingSelect.SetSelected(strconv.Itoa(s.Oversampling))
-	oversamplingSelect.OnChanged = func(v string) {
-		ov, _ := strconv.Atoi(v)
-		s.ReinitContext(currentFrameSize, ov)
-		currentOversampling = ov
-		updateLatency()
-	}
-
-	dspRow := container.NewHBox(
-		widget.NewLabel("Frame Size:"),
-		frameSizeSelect,
-		widget.NewLabel("  Oversampling:"),
-		oversamplingSelect,
-		widget.NewLabel("  "),
-		latencyLabel,
-	)
-
-	// Pitch slider — use NewFloat+listener so the binding survives a ReinitContext
-	pitch := binding.NewFloat()
-	pitch.Set(float64(*shift))
-	pitch.AddListener(binding.NewDataListener(func() {
-		v, _ := pitch.Get()
-		s.PitchShift = v
-	}))
-	pitchSlider := widget.NewSliderWithData(-12.0, 12.0, pitch)
-	pitchSlider.Step = 0.01
-	pitchText := binding.FloatToStringWithFormat(pitch, "Pitch = %0.2f")
-
-	// Volume slider
-	vol := binding.NewFloat()
-	vol.Set(1.0)
-	vol.AddListener(binding.NewDataListener(func() {
-		v, _ := vol.Get()
-		s.Volume = v
-	}))
-	volSlider := widget.NewSliderWithData(0.0, 1.0, vol)
-	volSlider.Step = 0.01
-	volText := binding.FloatToStringWithFormat(vol, "Volume = %0.1f")
-
-	// Algorithm selector
-	algoLabel := widget.NewLabel("Algorithm: " + s.AlgoName)
-	algoSelect := widget.NewSelect(algos.FullNames(), func(selected string) {
-		for _, a := range algos.Algorithms {
-			if a.FullName == selected {
-				s.SetAlgorithm(a)
-				algoLabel.SetText("Algorithm: " + s.AlgoName)
-				break
-			}
-		}
-	})
-	algoSelect.SetSelected(s.AlgoName)
-
-	// Device selectors
-	deviceOptionNames := func(devices []gominiaudio.DeviceInfo) []string {
-		names := make([]string, len(devices))
-		for i, d := range devices {
-			if d.IsDefault {
-				names[i] = fmt.Sprintf("%d: %s [default]", i, d.Name)
-			} else {
-				names[i] = fmt.Sprintf("%d: %s", i, d.Name)
-			}
-		}
-		return names
-	}
-	inputNames := deviceOptionNames(inputs)
-	outputNames := deviceOptionNames(outputs)
-
-	// Track current device IDs so each dropdown can preserve the other on restart.
-	currentCaptureID := inputs[initialInputIdx].ID
-	currentPlaybackID := outputs[initialOutputIdx].ID
-
-	parseDeviceIdx := func(selected string) int {
-		parts := strings.SplitN(selected, ":", 2)
-		idx, _ := strconv.Atoi(strings.TrimSpace(parts[0]))
-		return idx
-	}
-
-	inputSelect := widget.NewSelect(inputNames, nil)
-	if initialInputIdx < len(inputNames) {
-		inputSelect.SetSelected(inputNames[initialInputIdx])
-	}
-	inputSelect.OnChanged = func(selected string) {
-		idx := parseDeviceIdx(selected)
-		if idx < 0 || idx >= len(inputs) {
-			return
-		}
-		currentCaptureID = inputs[idx].ID
-		pid := currentPlaybackID
-		restartAudio(&currentCaptureID, &pid)
-	}
-
-	outputSelect := widget.NewSelect(outputNames, nil)
-	if initialOutputIdx < len(outputNames) {
-		outputSelect.SetSelected(outputNames[initialOutputIdx])
-	}
-	outputSelect.OnChanged = func(selected string) {
-		idx := parseDeviceIdx(selected)
-		if idx < 0 || idx >= len(outputs) {
-			return
-		}
-		currentPlaybackID = outputs[idx].ID
-		cid := currentCaptureID
-		restartAudio(&cid, &currentPlaybackID)
-	}
-
-	deviceRow := container.NewHBox(
-		widget.NewLabel("Input:"),
-		inputSelect,
-		widget.NewLabel("  Output:"),
-		outputSelect,
-	)
-
-	// Layout
-	w.SetContent(container.NewVBox(
-		info,
-		deviceRow,
-		dspRow,
-		algoLabel,
-		algoSelect,
-		widget.NewLabelWithData(pitchText),
-		pitchSlider,
-		widget.NewLabelWithData(volText),
-		volSlider,
-	))
-
-	return w
-}
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/widget"
+	"github.com/intermernet/gominiaudio"
+	"github.com/intermernet/pitcher/algos"
+)
+
+var window fyne.Window
+
+func gui(s *shifter, inputs, outputs []gominiaudio.DeviceInfo, initialInputIdx, initialOutputIdx int, restartAudio func(*gominiaudio.DeviceID, *gominiaudio.DeviceID)) fyne.Window {
+	shiftApp := app.New()
+
+	// Define app icon and set window title / size
+	icon, err := fyne.LoadResourceFromPath("resource/audio-waveform-icon-8.jpg")
+	if err != nil {
+		log.Fatalln("icon file not found")
+	}
+	shiftApp.SetIcon(icon)
+	w := shiftApp.NewWindow("Pitcher")
+	w.Resize(fyne.NewSize(800, 320))
+
+	// Static device info
+	excl := "No"
+	if s.exclusive {
+		excl = "Yes"
+	}
+	info := widget.NewLabel(fmt.Sprintf(
+		"Layout: %s (%d processed)  |  Sample Rate: %d Hz  |  Format: %v  |  Periods: %d  |  Buffer: %d frames  |  Exclusive: %s",
+		s.layout, s.layout.process, int(s.sampleRate), s.format, s.periods, s.bufferSize, excl))
+	info.Wrapping = fyne.TextWrapWord
+
+	// Latency display — updated whenever frame size or oversampling changes
+	latencyStr := binding.NewString()
+	updateLatency := func() {
+		latencyStr.Set(fmt.Sprintf("Latency: %.1f ms", float64(s.latency())/s.sampleRate*1000.0))
+	}
+	updateLatency()
+	latencyLabel := widget.NewLabelWithData(latencyStr)
+
+	// Algorithm parameter sliders, generated from the schema of the active
+	// algorithm whenever a Context is published. Every change builds a new
+	// Context, so values are applied when a drag ends.
+	paramBox := container.NewVBox()
+	updateParams := func() {
+		ctx := s.context()
+		paramBox.RemoveAll()
+		for _, p := range ctx.Processor.Params() {
+			label := widget.NewLabel("")
+			setLabel := func(v float64) {
+				label.SetText(strings.TrimSpace(fmt.Sprintf("%s (%s) = %g %s", p.Usage, p.Name, v, p.Unit)))
+			}
+			slider := widget.NewSlider(p.Min, p.Max)
+			slider.Step = (p.Max - p.Min) / 100
+			if p.Kind == algos.ParamInt {
+				slider.Step = 1
+			}
+			slider.SetValue(ctx.Param(p))
+			setLabel(slider.Value)
+			slider.OnChanged = setLabel
+			slider.OnChangeEnded = func(v float64) {
+				go func() {
+					if err := s.SetParams(map[string]float64{p.Name: v}); err != nil {
+						log.Println(err)
+					}
+					fyne.Do(updateLatency)
+				}()
+			}
+			paramBox.Add(label)
+			paramBox.Add(slider)
+		}
+	}
+	updateParams()
+	contextChanged := func() {
+		updateLatency()
+		updateParams()
+	}
+
+	// Track current DSP settings so each selector can pass the other's value
+	// when calling ReinitContext. Contexts are built off the GUI goroutine
+	// and the latency is refreshed once the new one is published.
+	ctx := s.context()
+	currentFrameSize := ctx.FFTFrameSize
+	currentOversampling := ctx.Oversampling
+
+	// Frame size selector; 480 and 960 are 10 and 20 ms at 48 kHz
+	frameSizeSelect := widget.NewSelect([]string{"256", "480", "512", "960", "1024", "2048", "4096"}, nil)
+	frameSizeSelect.SetSelected(strconv.Itoa(currentFrameSize))
+	frameSizeSelect.OnChanged = func(v string) {
+		fs, _ := strconv.Atoi(v)
+		ov := currentOversampling
+		currentFrameSize = fs
+		go func() {
+			s.ReinitContext(fs, ov)
+			fyne.Do(contextChanged)
+		}()
+	}
+
+	// Oversampling selector
+	oversamplingSelect := widget.NewSelect([]string{"1", "2", "4", "8"}, nil)
+	oversamplingSelect.SetSelected(strconv.Itoa(currentOversampling))
+	oversamplingSelect.OnChanged = func(v string) {
+		ov, _ := strconv.Atoi(v)
+		fs := currentFrameSize
+		currentOversampling = ov
+		go func() {
+			s.ReinitContext(fs, ov)
+			fyne.Do(contextChanged)
+		}()
+	}
+
+	// Zero-padding selector
+	zeroPadSelect := widget.NewSelect([]string{"1", "2", "4", "8"}, nil)
+	zeroPadSelect.SetSelected(strconv.Itoa(ctx.ZeroPad))
+	zeroPadSelect.OnChanged = func(v string) {
+		pad, _ := strconv.Atoi(v)
+		go func() {
+			if err := s.SetZeroPad(pad); err != nil {
+				log.Println(err)
+			}
+			fyne.Do(contextChanged)
+		}()
+	}
+
+	// Window selector; kaiser and asym use their default parameter
+	windowSelect := widget.NewSelect(algos.WindowNames, nil)
+	windowSelect.SetSelected(ctx.WindowShape.Name)
+	windowSelect.OnChanged = func(v string) {
+		w, err := algos.ParseWindow(v)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		go func() {
+			if err := s.SetWindow(w); err != nil {
+				log.Println(err)
+			}
+			fyne.Do(contextChanged)
+		}()
+	}
+
+	dspRow := container.NewHBox(
+		widget.NewLabel("Frame Size:"),
+		frameSizeSelect,
+		widget.NewLabel("  Oversampling:"),
+		oversamplingSelect,
+		widget.NewLabel("  Zero pad:"),
+		zeroPadSelect,
+		widget.NewLabel("  Window:"),
+		windowSelect,
+		widget.NewLabel("  "),
+		latencyLabel,
+	)
+
+	// Pitch slider — use NewFloat+listener so the binding survives a ReinitContext
+	pitch := binding.NewFloat()
+	pitch.Set(float64(*shift))
+	pitch.AddListener(binding.NewDataListener(func() {
+		v, _ := pitch.Get()
+		s.SetPitchShift(v)
+	}))
+	pitchSlider := widget.NewSliderWithData(-12.0, 12.0, pitch)
+	pitchSlider.Step = 0.01
+	pitchText := binding.FloatToStringWithFormat(pitch, "Pitch = %0.2f")
+
+	// Formant slider — shifts the spectral envelope independently of pitch
+	formant := binding.NewFloat()
+	formant.Set(s.params.formantShift.Load())
+	formant.AddListener(binding.NewDataListener(func() {
+		v, _ := formant.Get()
+		s.SetFormantShift(v)
+	}))
+	formantSlider := widget.NewSliderWithData(-12.0, 12.0, formant)
+	formantSlider.Step = 0.01
+	formantText := binding.FloatToStringWithFormat(formant, "Formant = %0.2f")
+
+	// Volume slider
+	vol := binding.NewFloat()
+	vol.Set(1.0)
+	vol.AddListener(binding.NewDataListener(func() {
+		v, _ := vol.Get()
+		s.SetVolume(v)
+	}))
+	volSlider := widget.NewSliderWithData(0.0, 1.0, vol)
+	volSlider.Step = 0.01
+	volText := binding.FloatToStringWithFormat(vol, "Volume = %0.1f")
+
+	// Dry/wet mix slider
+	mix := binding.NewFloat()
+	mix.Set(s.params.mix.Load())
+	mix.AddListener(binding.NewDataListener(func() {
+		v, _ := mix.Get()
+		s.SetMix(v)
+	}))
+	mixSlider := widget.NewSliderWithData(0.0, 1.0, mix)
+	mixSlider.Step = 0.01
+	mixText := binding.FloatToStringWithFormat(mix, "Mix (wet) = %0.2f")
+
+	// Time-stretch slider — live varispeed with the pitch restored
+	stretch := binding.NewFloat()
+	stretch.Set(s.params.stretch.Load())
+	stretch.AddListener(binding.NewDataListener(func() {
+		v, _ := stretch.Get()
+		s.SetStretch(v)
+	}))
+	stretchSlider := widget.NewSliderWithData(algos.MinTimeStretch, algos.MaxTimeStretch, stretch)
+	stretchSlider.Step = 0.01
+	stretchText := binding.FloatToStringWithFormat(stretch, "Stretch = %0.2fx")
+
+	// Algorithm selector
+	algoLabel := widget.NewLabel("Algorithm: " + ctx.AlgoName)
+	algoSelect := widget.NewSelect(algos.FullNames(), func(selected string) {
+		for _, a := range algos.Algorithms {
+			if a.FullName == selected {
+				algoLabel.SetText("Algorithm: " + a.FullName)
+				go func() {
+					s.SetAlgorithm(a)
+					fyne.Do(contextChanged)
+				}()
+				break
+			}
+		}
+	})
+	algoSelect.SetSelected(ctx.AlgoName)
+
+	// Formant preservation toggle (STFT-based algorithms only)
+	formantCheck := widget.NewCheck("Preserve formants", func(on bool) {
+		s.SetFormantPreserve(on)
+	})
+	formantCheck.SetChecked(s.params.formants.Load())
+
+	// Pitch detector readout, refreshed four times a second
+	pitchReadout := widget.NewLabel("Detected pitch: off")
+	detectCheck := widget.NewCheck("Detect pitch", func(on bool) {
+		s.SetPitchTracking(on)
+	})
+	detectCheck.SetChecked(s.params.tracking.Load())
+	go func() {
+		for range time.Tick(250 * time.Millisecond) {
+			text := "Detected pitch: off"
+			if s.params.tracking.Load() {
+				text = "Detected pitch: " + formatPitch(s.DetectedPitch())
+			}
+			fyne.Do(func() { pitchReadout.SetText(text) })
+		}
+	}()
+	detectRow := container.NewHBox(detectCheck, pitchReadout)
+
+	// Auto-tune controls. The GUI keeps its own copy of the settings and
+	// hands the whole set to the shifter whenever one of them changes.
+	tuneOn, tuneSettings := s.tune()
+	applyTune := func() { s.SetTune(tuneOn, tuneSettings) }
+	tuneCheck := widget.NewCheck("Auto-tune", func(on bool) {
+		tuneOn = on
+		applyTune()
+	})
+	tuneCheck.SetChecked(tuneOn)
+
+	keyName := algos.KeyNames[tuneSettings.Scale.Key]
+	scaleName, customNotes := "custom", ""
+	for _, name := range algos.ScaleNames {
+		if sc, _ := algos.ParseScale(keyName, name); slices.Equal(sc.Classes, tuneSettings.Scale.Classes) {
+			scaleName = name
+			break
+		}
+	}
+	if scaleName == "custom" {
+		notes := make([]string, len(tuneSettings.Scale.Classes))
+		for i, pc := range tuneSettings.Scale.Classes {
+			notes[i] = algos.KeyNames[pc]
+		}
+		customNotes = strings.Join(notes, ",")
+	}
+	keySelect := widget.NewSelect(algos.KeyNames, nil)
+	keySelect.SetSelected(keyName)
+	scaleSelect := widget.NewSelect(append(slices.Clone(algos.ScaleNames), "custom"), nil)
+	scaleSelect.SetSelected(scaleName)
+	customEntry := widget.NewEntry()
+	customEntry.SetPlaceHolder("C,D,E,G,A")
+	customEntry.SetText(customNotes)
+	updateScale := func() {
+		notes := scaleSelect.Selected
+		if notes == "custom" {
+			notes = customEntry.Text
+		}
+		sc, err := algos.ParseScale(keySelect.Selected, notes)
+		if err != nil {
+			return
+		}
+		tuneSettings.Scale = sc
+		applyTune()
+	}
+	keySelect.OnChanged = func(string) { updateScale() }
+	scaleSelect.OnChanged = func(string) { updateScale() }
+	customEntry.OnChanged = func(string) {
+		if scaleSelect.Selected == "custom" {
+			updateScale()
+		}
+	}
+
+	retune := binding.NewFloat()
+	retune.Set(tuneSettings.Retune)
+	retune.AddListener(binding.NewDataListener(func() {
+		tuneSettings.Retune, _ = retune.Get()
+		applyTune()
+	}))
+	retuneSlider := widget.NewSliderWithData(0, 500, retune)
+	retuneSlider.Step = 1
+	retuneText := binding.FloatToStringWithFormat(retune, "Retune = %0.0f ms")
+
+	humanize := binding.NewFloat()
+	humanize.Set(tuneSettings.Humanize)
+	humanize.AddListener(binding.NewDataListener(func() {
+		tuneSettings.Humanize, _ = humanize.Get()
+		applyTune()
+	}))
+	humanizeSlider := widget.NewSliderWithData(0, 1, humanize)
+	humanizeSlider.Step = 0.01
+	humanizeText := binding.FloatToStringWithFormat(humanize, "Humanize = %0.2f")
+
+	tuneRow := container.NewHBox(
+		tuneCheck,
+		widget.NewLabel("  Key:"),
+		keySelect,
+		widget.NewLabel("  Scale:"),
+		scaleSelect,
+		customEntry,
+	)
+
+	// Harmonizer voices, in --voice syntax separated by spaces. The voices
+	// are rebuilt when Enter is pressed, if the text parses and differs from
+	// the running voices, since each rebuild plans every voice's FFTs; the
+	// Harmonizer is built off the GUI goroutine like the Contexts. Voices
+	// without an algorithm use the selected one. Harmony mode does not
+	// time-stretch.
+	var voiceSpecs []string
+	for _, v := range s.harmonyVoices() {
+		voiceSpecs = append(voiceSpecs, formatVoice(v))
+	}
+	if len(voiceSpecs) > 0 {
+		stretchSlider.Disable()
+	}
+	voicesEntry := widget.NewEntry()
+	voicesEntry.SetPlaceHolder("+4:0.7:-0.5 +2d:0.7:0.5")
+	voicesEntry.SetText(strings.Join(voiceSpecs, " "))
+	voicesEntry.OnSubmitted = func(text string) {
+		var voices []algos.Voice
+		for _, spec := range strings.Fields(text) {
+			v, err := parseVoice(spec)
+			if err != nil {
+				return
+			}
+			voices = append(voices, v)
+		}
+		if sameVoices(voices, s.harmonyVoices()) {
+			return
+		}
+		go func() {
+			s.SetHarmony(voices)
+			fyne.Do(func() {
+				if len(s.harmonyVoices()) > 0 {
+					stretchSlider.Disable()
+				} else {
+					stretchSlider.Enable()
+				}
+				updateLatency()
+			})
+		}()
+	}
+
+	dry := binding.NewFloat()
+	dry.Set(s.params.harmonyDry.Load())
+	dry.AddListener(binding.NewDataListener(func() {
+		v, _ := dry.Get()
+		s.SetHarmonyDry(v)
+	}))
+	drySlider := widget.NewSliderWithData(0, 1, dry)
+	drySlider.Step = 0.01
+	dryText := binding.FloatToStringWithFormat(dry, "Harmony dry = %0.2f")
+
+	harmonyRow := container.NewBorder(nil, nil, widget.NewLabel("Harmony voices:"), nil, voicesEntry)
+
+	// Device selectors
+	deviceOptionNames := func(devices []gominiaudio.DeviceInfo) []string {
+		names := make([]string, len(devices))
+		for i, d := range devices {
+			if d.IsDefault {
+				names[i] = fmt.Sprintf("%d: %s [default]", i, d.Name)
+			} else {
+				names[i] = fmt.Sprintf("%d: %s", i, d.Name)
+			}
+		}
+		return names
+	}
+	inputNames := deviceOptionNames(inputs)
+	outputNames := deviceOptionNames(outputs)
+
+	// Track current device IDs so each dropdown can preserve the other on restart.
+	currentCaptureID := inputs[initialInputIdx].ID
+	currentPlaybackID := outputs[initialOutputIdx].ID
+
+	parseDeviceIdx := func(selected string) int {
+		parts := strings.SplitN(selected, ":", 2)
+		idx, _ := strconv.Atoi(strings.TrimSpace(parts[0]))
+		return idx
+	}
+
+	inputSelect := widget.NewSelect(inputNames, nil)
+	if initialInputIdx < len(inputNames) {
+		inputSelect.SetSelected(inputNames[initialInputIdx])
+	}
+	inputSelect.OnChanged = func(selected string) {
+		idx := parseDeviceIdx(selected)
+		if idx < 0 || idx >= len(inputs) {
+			return
+		}
+		currentCaptureID = inputs[idx].ID
+		pid := currentPlaybackID
+		restartAudio(&currentCaptureID, &pid)
+	}
+
+	outputSelect := widget.NewSelect(outputNames, nil)
+	if initialOutputIdx < len(outputNames) {
+		outputSelect.SetSelected(outputNames[initialOutputIdx])
+	}
+	outputSelect.OnChanged = func(selected string) {
+		idx := parseDeviceIdx(selected)
+		if idx < 0 || idx >= len(outputs) {
+			return
+		}
+		currentPlaybackID = outputs[idx].ID
+		cid := currentCaptureID
+		restartAudio(&cid, &currentPlaybackID)
+	}
+
+	deviceRow := container.NewHBox(
+		widget.NewLabel("Input:"),
+		inputSelect,
+		widget.NewLabel("  Output:"),
+		outputSelect,
+	)
+
+	// Layout
+	w.SetContent(container.NewVBox(
+		info,
+		deviceRow,
+		dspRow,
+		algoLabel,
+		algoSelect,
+		paramBox,
+		formantCheck,
+		detectRow,
+		widget.NewLabelWithData(pitchText),
+		pitchSlider,
+		widget.NewLabelWithData(formantText),
+		formantSlider,
+		widget.NewLabelWithData(volText),
+		volSlider,
+		widget.NewLabelWithData(mixText),
+		mixSlider,
+		widget.NewLabelWithData(stretchText),
+		stretchSlider,
+		tuneRow,
+		widget.NewLabelWithData(retuneText),
+		retuneSlider,
+		widget.NewLabelWithData(humanizeText),
+		humanizeSlider,
+		harmonyRow,
+		widget.NewLabelWithData(dryText),
+		drySlider,
+	))
+
+	return w
+}
@@ -0,0 +1,153 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/intermernet/pitcher/algos"
+)
+
+// Harmonizer interval limits.
+const (
+	maxVoiceSemitones = 24
+	maxVoiceDegrees   = 14
+)
+
+// voiceList collects repeated --voice flags.
+type voiceList []string
+
+func (v *voiceList) String() string {
+	return strings.Join(*v, " ")
+}
+
+func (v *voiceList) Set(s string) error {
+	*v = append(*v, s)
+	return nil
+}
+
+// harmonyFlags holds the harmonizer flags shared by live and offline modes.
+type harmonyFlags struct {
+	specs *voiceList
+	dry   *float64
+}
+
+// addHarmonyFlags registers the harmonizer flags on fs.
+func addHarmonyFlags(fs *flag.FlagSet) harmonyFlags {
+	f := harmonyFlags{specs: new(voiceList)}
+	fs.Var(f.specs, "voice", "Add a harmonizer voice as interval[:gain[:pan[:algo]]], e.g. +4:0.7:-0.5:stn. The interval is in semitones, or in degrees of --key and --scale with a d suffix (+2d = a diatonic third). Repeat for more voices")
+	f.dry = fs.Float64("dry", 1, "Harmonizer dry signal gain")
+	return f
+}
+
+// voices validates the flags and returns the harmonizer voices. Voices that
+// do not name an algorithm run the one selected with --algo.
+func (f harmonyFlags) voices() ([]algos.Voice, error) {
+	if *f.dry < 0 {
+		return nil, errors.New("\"dry\" must not be negative")
+	}
+	vs := make([]algos.Voice, 0, len(*f.specs))
+	for _, spec := range *f.specs {
+		v, err := parseVoice(spec)
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+// parseVoice parses a --voice value. Without an algorithm field the voice's
+// Algo is left zero, for the selected algorithm.
+func parseVoice(spec string) (algos.Voice, error) {
+	fields := strings.Split(spec, ":")
+	if len(fields) > 4 {
+		return algos.Voice{}, fmt.Errorf("voice %q: want interval[:gain[:pan[:algo]]]", spec)
+	}
+	v := algos.Voice{Gain: 1}
+
+	interval := fields[0]
+	if v.Diatonic = strings.HasSuffix(interval, "d"); v.Diatonic {
+		interval = strings.TrimSuffix(interval, "d")
+	}
+	var err error
+	if v.Interval, err = strconv.ParseFloat(interval, 64); err != nil {
+		return algos.Voice{}, fmt.Errorf("voice %q: bad interval %q", spec, fields[0])
+	}
+	switch {
+	case v.Diatonic && (v.Interval != math.Trunc(v.Interval) || math.Abs(v.Interval) > maxVoiceDegrees):
+		return algos.Voice{}, fmt.Errorf("voice %q: diatonic interval must be a whole number of degrees between -%d and %d", spec, maxVoiceDegrees, maxVoiceDegrees)
+	case !v.Diatonic && math.Abs(v.Interval) > maxVoiceSemitones:
+		return algos.Voice{}, fmt.Errorf("voice %q: interval must be between -%d and %d semitones", spec, maxVoiceSemitones, maxVoiceSemitones)
+	}
+
+	if len(fields) > 1 && fields[1] != "" {
+		if v.Gain, err = strconv.ParseFloat(fields[1], 64); err != nil || v.Gain < 0 {
+			return algos.Voice{}, fmt.Errorf("voice %q: gain must be a non-negative number", spec)
+		}
+	}
+	if len(fields) > 2 && fields[2] != "" {
+		if v.Pan, err = strconv.ParseFloat(fields[2], 64); err != nil || v.Pan < -1 || v.Pan > 1 {
+			return algos.Voice{}, fmt.Errorf("voice %q: pan must be between -1 and 1", spec)
+		}
+	}
+	if len(fields) > 3 && fields[3] != "" {
+		a, ok := algos.Find(fields[3])
+		if !ok {
+			return algos.Voice{}, fmt.Errorf("voice %q: unknown algorithm %q — valid options: %v", spec, fields[3], algos.Names())
+		}
+		v.Algo = a
+	}
+	return v, nil
+}
+
+// formatVoice returns the --voice value describing v.
+func formatVoice(v algos.Voice) string {
+	interval := fmt.Sprintf("%+g", v.Interval)
+	if v.Diatonic {
+		interval += "d"
+	}
+	spec := fmt.Sprintf("%s:%g:%g", interval, v.Gain, v.Pan)
+	if v.Algo.ShortName != "" {
+		spec += ":" + v.Algo.ShortName
+	}
+	return spec
+}
+
+// sameVoices reports whether a and b describe the same voices, so that an
+// unchanged list need not rebuild the harmonizer.
+func sameVoices(a, b []algos.Voice) bool {
+	return slices.EqualFunc(a, b, func(x, y algos.Voice) bool {
+		return x.Interval == y.Interval && x.Diatonic == y.Diatonic && x.Gain == y.Gain &&
+			x.Pan == y.Pan && x.Algo.ShortName == y.Algo.ShortName
+	})
+}
+
+// describeVoices formats harmonizer voices for the running-parameters
+// summary, e.g. "+4 st (gain 0.7, pan -0.5, stn), +2 deg (…)". Voices
+// running the selected algorithm do not name it.
+func describeVoices(vs []algos.Voice) string {
+	parts := make([]string, len(vs))
+	for i, v := range vs {
+		unit := "st"
+		if v.Diatonic {
+			unit = "deg"
+		}
+		parts[i] = fmt.Sprintf("%+g %s (gain %g, pan %+g", v.Interval, unit, v.Gain, v.Pan)
+		if v.Algo.ShortName != "" {
+			parts[i] += ", " + v.Algo.ShortName
+		}
+		parts[i] += ")"
+	}
+	return strings.Join(parts, ", ")
+}
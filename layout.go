@@ -0,0 +1,143 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/intermernet/pitcher/algos"
+	"github.com/intermernet/pitcher/wavio"
+)
+
+// maxChannels is the largest channel count accepted by --channels.
+const maxChannels = 8
+
+// channelLayout describes how many channels are captured, processed by the
+// algorithm and played back. When the counts differ the shifter routes
+// samples between them around the algorithm call.
+type channelLayout struct {
+	capture  int
+	process  int
+	playback int
+}
+
+// parseLayout builds the layout for a --route name and --channels count.
+//
+//   - direct:      capture, process and play back the same channels.
+//   - mono2stereo: one capture channel is processed once and sent to both
+//     channels of a stereo output.
+//   - stereo2mono: two capture channels are mixed down to mono and
+//     processed once, with a mono output.
+func parseLayout(route string, channels int) (channelLayout, error) {
+	switch route {
+	case "direct":
+		if channels < 1 || channels > maxChannels {
+			return channelLayout{}, fmt.Errorf("\"channels\" must be between 1 and %d", maxChannels)
+		}
+		return directLayout(channels), nil
+	case "mono2stereo":
+		return channelLayout{capture: 1, process: 1, playback: 2}, nil
+	case "stereo2mono":
+		return channelLayout{capture: 2, process: 1, playback: 1}, nil
+	}
+	return channelLayout{}, fmt.Errorf("unknown route %q — valid options: direct, mono2stereo, stereo2mono", route)
+}
+
+// directLayout returns a layout that processes every channel as captured.
+func directLayout(channels int) channelLayout {
+	return channelLayout{capture: channels, process: channels, playback: channels}
+}
+
+// String describes the layout for display, e.g. "stereo" or "stereo → mono".
+func (l channelLayout) String() string {
+	if l.capture == l.process && l.process == l.playback {
+		return channelName(l.process)
+	}
+	return channelName(l.capture) + " → " + channelName(l.playback)
+}
+
+// channelName names common channel counts.
+func channelName(n int) string {
+	switch n {
+	case 1:
+		return "mono"
+	case 2:
+		return "stereo"
+	}
+	return fmt.Sprintf("%d ch", n)
+}
+
+// router converts interleaved buffers between the capture, processing and
+// playback channel counts of a layout. Its scratch buffers are allocated up
+// front for blocks of up to algos.MaxBlock frames, so callbacks do not
+// allocate.
+type router struct {
+	channelLayout
+	format  wavio.SampleFormat
+	procIn  []byte
+	procOut []byte
+}
+
+func newRouter(l channelLayout, format wavio.SampleFormat) *router {
+	return &router{
+		channelLayout: l,
+		format:        format,
+		procIn:        make([]byte, algos.MaxBlock*l.process*format.Size()),
+		procOut:       make([]byte, algos.MaxBlock*l.process*format.Size()),
+	}
+}
+
+// run runs process on procIn, already routed into the processing layout
+// by in, and routes the result into output.
+func (r *router) run(process func(output, input []byte), output, procIn []byte) {
+	if r.process == r.playback {
+		process(output, procIn)
+		return
+	}
+	procOut := r.procOut[:len(procIn)]
+	process(procOut, procIn)
+	r.out(output, procOut)
+}
+
+// in routes input into the processing layout, averaging down to the
+// processing channels, and returns the routed buffer.
+func (r *router) in(input []byte) []byte {
+	if r.capture == r.process {
+		return input
+	}
+	size := r.format.Size()
+	frames := len(input) / (size * r.capture)
+	procIn := r.procIn[:frames*size*r.process]
+	gain := float64(r.process) / float64(r.capture)
+	for i := 0; i < frames; i++ {
+		for p := 0; p < r.process; p++ {
+			sum := 0.0
+			for c := p; c < r.capture; c += r.process {
+				sum += r.format.Decode(input[(i*r.capture+c)*size:])
+			}
+			r.format.Encode(procIn[(i*r.process+p)*size:], sum*gain)
+		}
+	}
+	return procIn
+}
+
+// out fans each processed channel of procOut out across the playback
+// channels of output.
+func (r *router) out(output, procOut []byte) {
+	if r.process == r.playback {
+		copy(output, procOut)
+		return
+	}
+	size := r.format.Size()
+	frames := len(procOut) / (size * r.process)
+	for i := 0; i < frames; i++ {
+		for c := 0; c < r.playback; c++ {
+			src := procOut[(i*r.process+c%r.process)*size:]
+			copy(output[(i*r.playback+c)*size:], src[:size])
+		}
+	}
+}
@@ -1,275 +1,449 @@
-/****************************************************************************
-*
-* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
-*
-****************************************************************************/
-
-package main
-
-import (
-	"flag"
-	"fmt"
-	"log"
-	"math"
-	"os"
-	"os/signal"
-
-	"github.com/intermernet/gominiaudio"
-	"github.com/intermernet/pitcher/algos"
-)
-
-var shift *int
-
-func main() {
-
-	guiOn := flag.Bool("gui", false, "Display GUI")
-	shift = flag.Int("shift", 0, "Semitones to pitch-shift. Must be between -12 and +12")
-	algoFlag := flag.String("algo", algos.Default().ShortName, "Pitch-shifting algorithm. Options: "+algos.NamesString())
-	frameSize := flag.Int("framesize", 0, "FFT framesize. Must be a power of 2 (0 = use algorithm default)")
-	overSampling := flag.Int("oversampling", 0, "Pitch shift oversampling. Must be a power of 2 (0 = use algorithm default)")
-	sampleRate := flag.Int("samplerate", 48000, "Audio Sample Rate")
-	periods := flag.Int("periods", 2, "Audio buffer periods (2 = double-buffered)")
-	bufferSize := flag.Int("buffersize", 256, "Audio period size in frames (lower = less latency, may cause glitches)")
-	exclusive := flag.Bool("exclusive", false, "Use WASAPI exclusive mode (locks audio device, lower latency)")
-	list := flag.Bool("list", false, "List available input/output audio devices and exit")
-	inputDevice := flag.Int("input", -1, "Input (capture) device number from --list (default: system default)")
-	outputDevice := flag.Int("output", -1, "Output (playback) device number from --list (default: system default)")
-	flag.Parse()
-
-	// Resolve algorithm
-	algo, ok := algos.Find(*algoFlag)
-	if !ok {
-		log.Fatalf("unknown algorithm %q — valid options: %v", *algoFlag, algos.Names())
-	}
-
-	// Apply algorithm defaults when flags were not explicitly set
-	if *frameSize == 0 {
-		*frameSize = algo.Defaults.FrameSize
-	}
-	if *overSampling == 0 {
-		*overSampling = algo.Defaults.Oversampling
-	}
-
-	// Setup audio context first — needed for device enumeration and --list.
-	ctx, err := gominiaudio.InitContext(nil, nil)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-	defer func() {
-		_ = ctx.Uninit()
-	}()
-
-	captureDevices, err := ctx.Devices(gominiaudio.DeviceTypeCapture)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-	playbackDevices, err := ctx.Devices(gominiaudio.DeviceTypePlayback)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-
-	if *list {
-		printDeviceList(captureDevices, playbackDevices)
-		os.Exit(0)
-	}
-
-	// Flag sanity checks
-	if *shift < -12 || *shift > 12 {
-		log.Fatal("\"shift\" flag must be between -12 and 12 inclusive")
-	}
-	if *frameSize == 0 || math.Ceil(math.Log2(float64(*frameSize))) != math.Floor(math.Log2(float64(*frameSize))) {
-		log.Fatal("\"framesize\" must be a power of 2")
-	}
-	if *overSampling == 0 || math.Ceil(math.Log2(float64(*overSampling))) != math.Floor(math.Log2(float64(*overSampling))) {
-		log.Fatal("\"oversampling\" must be a power of 2")
-	}
-	if *sampleRate <= 0 {
-		log.Fatal("\"samplerate\" must be a positive integer")
-	}
-	if *periods <= 0 {
-		log.Fatal("\"periods\" must be a positive integer")
-	}
-	if *bufferSize < 0 {
-		log.Fatal("\"buffersize\" must be non-negative")
-	}
-
-	channels := 2
-	format := gominiaudio.FormatF32
-	bitDepth := uint16(format.SizeInBytes() * 8)
-	deviceConfig := gominiaudio.DeviceConfigInit(gominiaudio.DeviceTypeDuplex)
-	deviceConfig.PerformanceProfile = gominiaudio.PerformanceProfileLowLatency
-	deviceConfig.Capture.Format = format
-	deviceConfig.Capture.Channels = uint32(channels)
-	deviceConfig.Playback.Format = format
-	deviceConfig.Playback.Channels = uint32(channels)
-	deviceConfig.SampleRate = uint32(*sampleRate)
-
-	if *exclusive {
-		deviceConfig.Capture.ShareMode = gominiaudio.ShareModeExclusive
-		deviceConfig.Playback.ShareMode = gominiaudio.ShareModeExclusive
-	}
-
-	if *bufferSize > 0 {
-		deviceConfig.PeriodSizeInFrames = uint32(*bufferSize)
-	}
-	deviceConfig.Periods = uint32(*periods)
-
-	// Allow variable-sized callbacks — avoids miniaudio's internal ring buffer
-	// that adds an extra period of latency.
-	deviceConfig.NoFixedSizedCallback = true
-
-	// Platform-specific tuning
-	deviceConfig.NoClip = true
-	deviceConfig.NoPreSilencedOutputBuffer = false
-	deviceConfig.WASAPI.NoAutoConvertSRC = true
-	deviceConfig.WASAPI.NoAutoStreamRouting = false
-	deviceConfig.WASAPI.NoDefaultQualitySRC = true
-	deviceConfig.WASAPI.NoHardwareOffloading = false
-
-	// Set device IDs from --input / --output (nil = system default).
-	var captureDeviceID, playbackDeviceID *gominiaudio.DeviceID
-	if *inputDevice >= 0 {
-		if *inputDevice >= len(captureDevices) {
-			log.Fatalf("input device %d not found (use --list to see available devices)", *inputDevice)
-		}
-		id := captureDevices[*inputDevice].ID
-		captureDeviceID = &id
-	}
-	if *outputDevice >= 0 {
-		if *outputDevice >= len(playbackDevices) {
-			log.Fatalf("output device %d not found (use --list to see available devices)", *outputDevice)
-		}
-		id := playbackDevices[*outputDevice].ID
-		playbackDeviceID = &id
-	}
-	deviceConfig.Capture.DeviceID = captureDeviceID
-	deviceConfig.Playback.DeviceID = playbackDeviceID
-
-	// Initial device indices for GUI dropdowns.
-	initialInputIdx := findDefaultDeviceIdx(captureDevices)
-	if *inputDevice >= 0 {
-		initialInputIdx = *inputDevice
-	}
-	initialOutputIdx := findDefaultDeviceIdx(playbackDevices)
-	if *outputDevice >= 0 {
-		initialOutputIdx = *outputDevice
-	}
-
-	s := newShifter(*frameSize, *overSampling, float64(*sampleRate), bitDepth, channels, *periods, *bufferSize, *exclusive, algo)
-
-	defer s.Destroy()
-
-	// Pitch shift callback
-	deviceCallbacks := gominiaudio.DeviceCallbacks{
-		Data: func(_ *gominiaudio.Device, output, input []byte, frames uint32) {
-			s.process(output, input, frames)
-		},
-	}
-
-	// Init audio
-	var device *gominiaudio.Device
-	device, err = gominiaudio.InitDevice(ctx, deviceConfig, deviceCallbacks)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-	defer func() { device.Uninit() }()
-
-	// Start audio processing
-	go func() {
-		if startErr := device.Start(); startErr != nil {
-			os.Exit(1)
-		}
-	}()
-
-	// restartAudio stops the current device and opens a new one with the
-	// provided capture/playback device IDs (nil = system default).
-	restartAudio := func(captureID, playbackID *gominiaudio.DeviceID) {
-		device.Uninit()
-		if captureID != nil {
-			c := *captureID
-			deviceConfig.Capture.DeviceID = &c
-		} else {
-			deviceConfig.Capture.DeviceID = nil
-		}
-		if playbackID != nil {
-			p := *playbackID
-			deviceConfig.Playback.DeviceID = &p
-		} else {
-			deviceConfig.Playback.DeviceID = nil
-		}
-		d, initErr := gominiaudio.InitDevice(ctx, deviceConfig, deviceCallbacks)
-		if initErr != nil {
-			fmt.Println("device switch failed:", initErr)
-			return
-		}
-		device = d
-		if startErr := d.Start(); startErr != nil {
-			fmt.Println("device start failed:", startErr)
-		}
-	}
-
-	// Init GUI
-	if *guiOn {
-		window = gui(s, captureDevices, playbackDevices, initialInputIdx, initialOutputIdx, restartAudio)
-	}
-
-	// Start GUI or wait for interrupt
-	switch *guiOn {
-	case true:
-		window.ShowAndRun()
-	default:
-		exclStr := "No"
-		if *exclusive {
-			exclStr = "Yes"
-		}
-		latencyMs := float64(s.Latency) / s.SampleRate * 1000.0
-		fmt.Printf("\nPitcher — running parameters:\n")
-		fmt.Printf("  Algorithm:    %s (%s)\n", algo.FullName, algo.ShortName)
-		fmt.Printf("  Shift:        %+d semitones\n", *shift)
-		fmt.Printf("  Frame size:   %d\n", *frameSize)
-		fmt.Printf("  Oversampling: %d\n", *overSampling)
-		fmt.Printf("  Sample rate:  %d Hz\n", *sampleRate)
-		fmt.Printf("  Periods:      %d\n", *periods)
-		fmt.Printf("  Buffer size:  %d frames\n", *bufferSize)
-		fmt.Printf("  Exclusive:    %s\n", exclStr)
-		fmt.Printf("  Latency:      %.1f ms\n\n", latencyMs)
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
-		fmt.Println("Press Ctrl-C / Cmd-. to exit")
-		<-c
-		fmt.Println("Exiting...")
-		os.Exit(0)
-	}
-}
-
-func printDeviceList(inputs, outputs []gominiaudio.DeviceInfo) {
-	fmt.Println("Input devices (use with --input N):")
-	for i, d := range inputs {
-		def := ""
-		if d.IsDefault {
-			def = " [default]"
-		}
-		fmt.Printf("  %d: %s%s\n", i, d.Name, def)
-	}
-	fmt.Println("\nOutput devices (use with --output N):")
-	for i, d := range outputs {
-		def := ""
-		if d.IsDefault {
-			def = " [default]"
-		}
-		fmt.Printf("  %d: %s%s\n", i, d.Name, def)
-	}
-}
-
-func findDefaultDeviceIdx(devices []gominiaudio.DeviceInfo) int {
-	for i, d := range devices {
-		if d.IsDefault {
-			return i
-		}
-	}
-	return 0
-}
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/intermernet/gominiaudio"
+	"github.com/intermernet/pitcher/algos"
+	"github.com/intermernet/pitcher/pitchdetect"
+	"github.com/intermernet/pitcher/wavio"
+)
+
+var shift *int
+
+// deviceFormats maps the sample formats accepted by --format to their
+// miniaudio device equivalents.
+var deviceFormats = map[wavio.SampleFormat]gominiaudio.Format{
+	wavio.PCM16:   gominiaudio.FormatS16,
+	wavio.PCM24:   gominiaudio.FormatS24,
+	wavio.PCM32:   gominiaudio.FormatS32,
+	wavio.Float32: gominiaudio.FormatF32,
+}
+
+func main() {
+
+	// Offline file-to-file rendering has its own flag set.
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := runRender(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	guiOn := flag.Bool("gui", false, "Display GUI")
+	shift = flag.Int("shift", 0, "Semitones to pitch-shift. Must be between -12 and +12")
+	algoFlag := flag.String("algo", algos.Default().ShortName, "Pitch-shifting algorithm. Options: "+algos.NamesString())
+	frameSize := flag.Int("framesize", 0, "Analysis frame size in samples. Any length that --oversampling divides, e.g. 480 for 10 ms at 48 kHz (0 = use algorithm default)")
+	zeroPad := flag.Int("zeropad", 1, "Zero-padding factor of the STFT algorithms (1-8): a longer FFT for finer frequency resolution at the same frame size and latency")
+	overSampling := flag.Int("oversampling", 0, "Pitch shift oversampling. Must be a power of 2 (0 = use algorithm default)")
+	sampleRate := flag.Int("samplerate", 48000, "Audio Sample Rate")
+	periods := flag.Int("periods", 2, "Audio buffer periods (2 = double-buffered)")
+	bufferSize := flag.Int("buffersize", 256, "Audio period size in frames (lower = less latency, may cause glitches)")
+	exclusive := flag.Bool("exclusive", false, "Use WASAPI exclusive mode (locks audio device, lower latency)")
+	formatFlag := flag.String("format", "f32", "Device sample format: s16, s24, s32 or f32 (exclusive mode often requires an integer format)")
+	dither := flag.Bool("dither", false, "Apply TPDF dither when writing integer sample formats")
+	formants := flag.Bool("formants", false, "Preserve formants while shifting (phasvoc, plvoc, llstft, stn, sss)")
+	showPitch := flag.Bool("showpitch", false, "Detect the pitch of the first channel and print it while running")
+	tune := addTuneFlags(flag.CommandLine)
+	harmony := addHarmonyFlags(flag.CommandLine)
+	algoParams := addParamFlag(flag.CommandLine)
+	windowFlag := flag.String("window", algos.DefaultWindow.String(), "Analysis window: "+strings.Join(algos.WindowNames, ", ")+". kaiser takes a beta and asym a peak position, e.g. kaiser:8 or asym:0.8")
+	rampTime := flag.Float64("ramp", algos.DefaultRamp, "Time constant in ms with which pitch and volume changes are smoothed (0 = immediate)")
+	mix := flag.Float64("mix", 1, "Wet/dry balance from 0 (dry input only) to 1 (shifted signal only); the dry path is delayed to stay in phase")
+	stretch := flag.Float64("stretch", 1, "Time-stretch ratio from 0.25 to 4 (2 = half tempo) without changing pitch. Live, the input is buffered for at most --stretchbuffer and skips or repeats when the buffer runs out or over")
+	stretchBuffer := flag.Float64("stretchbuffer", 1, "Most input buffered in seconds while time stretching live")
+	formantShift := flag.Int("formantshift", 0, "Semitones to shift formants independently of pitch. Must be between -12 and +12 (phasvoc, plvoc, llstft, stn, sss)")
+	channelsFlag := flag.Int("channels", 2, "Number of channels to capture, process and play back (1-8) with --route direct")
+	routeFlag := flag.String("route", "direct", "Channel routing: direct, mono2stereo (process one input once, play on both outputs) or stereo2mono (mix two inputs down to mono)")
+	list := flag.Bool("list", false, "List available input/output audio devices and exit")
+	inputDevice := flag.Int("input", -1, "Input (capture) device number from --list (default: system default)")
+	outputDevice := flag.Int("output", -1, "Output (playback) device number from --list (default: system default)")
+	flag.Parse()
+
+	// Resolve algorithm
+	algo, ok := algos.Find(*algoFlag)
+	if !ok {
+		log.Fatalf("unknown algorithm %q — valid options: %v", *algoFlag, algos.Names())
+	}
+
+	// Apply algorithm defaults when flags were not explicitly set
+	if *frameSize == 0 {
+		*frameSize = algo.Defaults.FrameSize
+	}
+	if *overSampling == 0 {
+		*overSampling = algo.Defaults.Oversampling
+	}
+
+	// Setup audio context first — needed for device enumeration and --list.
+	ctx, err := gominiaudio.InitContext(nil, nil)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer func() {
+		_ = ctx.Uninit()
+	}()
+
+	captureDevices, err := ctx.Devices(gominiaudio.DeviceTypeCapture)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	playbackDevices, err := ctx.Devices(gominiaudio.DeviceTypePlayback)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *list {
+		printDeviceList(captureDevices, playbackDevices)
+		os.Exit(0)
+	}
+
+	// Flag sanity checks
+	if err := checkDSPFlags(*shift, *formantShift, *frameSize, *overSampling); err != nil {
+		log.Fatal(err)
+	}
+	tuneSettings, err := tune.settings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	voices, err := harmony.voices()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := checkStretch(*stretch); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkMix(*mix); err != nil {
+		log.Fatal(err)
+	}
+	windowShape, err := algos.ParseWindow(*windowFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *rampTime < 0 {
+		log.Fatal("\"ramp\" must not be negative")
+	}
+	if *stretch != 1 && len(voices) > 0 {
+		log.Fatal("\"stretch\" cannot be combined with \"voice\"")
+	}
+	if *stretchBuffer <= 0 {
+		log.Fatal("\"stretchbuffer\" must be positive")
+	}
+	if *sampleRate <= 0 {
+		log.Fatal("\"samplerate\" must be a positive integer")
+	}
+	if *periods <= 0 {
+		log.Fatal("\"periods\" must be a positive integer")
+	}
+	if *bufferSize < 0 || *bufferSize > algos.MaxBlock {
+		log.Fatalf("\"buffersize\" must be between 0 and %d", algos.MaxBlock)
+	}
+	sampleFormat, _ := wavio.ParseSampleFormat(*formatFlag)
+	format, ok := deviceFormats[sampleFormat]
+	if !ok {
+		log.Fatal("\"format\" must be one of s16, s24, s32 or f32")
+	}
+
+	layout, err := parseLayout(*routeFlag, *channelsFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	deviceConfig := gominiaudio.DeviceConfigInit(gominiaudio.DeviceTypeDuplex)
+	deviceConfig.PerformanceProfile = gominiaudio.PerformanceProfileLowLatency
+	deviceConfig.Capture.Format = format
+	deviceConfig.Capture.Channels = uint32(layout.capture)
+	deviceConfig.Playback.Format = format
+	deviceConfig.Playback.Channels = uint32(layout.playback)
+	deviceConfig.SampleRate = uint32(*sampleRate)
+
+	if *exclusive {
+		deviceConfig.Capture.ShareMode = gominiaudio.ShareModeExclusive
+		deviceConfig.Playback.ShareMode = gominiaudio.ShareModeExclusive
+	}
+
+	if *bufferSize > 0 {
+		deviceConfig.PeriodSizeInFrames = uint32(*bufferSize)
+	}
+	deviceConfig.Periods = uint32(*periods)
+
+	// Allow variable-sized callbacks — avoids miniaudio's internal ring buffer
+	// that adds an extra period of latency.
+	deviceConfig.NoFixedSizedCallback = true
+
+	// Platform-specific tuning
+	deviceConfig.NoClip = true
+	deviceConfig.NoPreSilencedOutputBuffer = false
+	deviceConfig.WASAPI.NoAutoConvertSRC = true
+	deviceConfig.WASAPI.NoAutoStreamRouting = false
+	deviceConfig.WASAPI.NoDefaultQualitySRC = true
+	deviceConfig.WASAPI.NoHardwareOffloading = false
+
+	// Set device IDs from --input / --output (nil = system default).
+	var captureDeviceID, playbackDeviceID *gominiaudio.DeviceID
+	if *inputDevice >= 0 {
+		if *inputDevice >= len(captureDevices) {
+			log.Fatalf("input device %d not found (use --list to see available devices)", *inputDevice)
+		}
+		id := captureDevices[*inputDevice].ID
+		captureDeviceID = &id
+	}
+	if *outputDevice >= 0 {
+		if *outputDevice >= len(playbackDevices) {
+			log.Fatalf("output device %d not found (use --list to see available devices)", *outputDevice)
+		}
+		id := playbackDevices[*outputDevice].ID
+		playbackDeviceID = &id
+	}
+	deviceConfig.Capture.DeviceID = captureDeviceID
+	deviceConfig.Playback.DeviceID = playbackDeviceID
+
+	// Initial device indices for GUI dropdowns.
+	initialInputIdx := findDefaultDeviceIdx(captureDevices)
+	if *inputDevice >= 0 {
+		initialInputIdx = *inputDevice
+	}
+	initialOutputIdx := findDefaultDeviceIdx(playbackDevices)
+	if *outputDevice >= 0 {
+		initialOutputIdx = *outputDevice
+	}
+
+	s := newShifter(*frameSize, *overSampling, float64(*sampleRate), sampleFormat, layout, *periods, *bufferSize, *exclusive, algo)
+	s.SetDither(*dither)
+	s.SetFormantPreserve(*formants)
+	s.SetFormantShift(float64(*formantShift))
+	s.SetMix(*mix)
+	s.SetRamp(*rampTime)
+	s.SetPitchTracking(*showPitch)
+	s.SetTune(*tune.enable, tuneSettings)
+	s.SetHarmony(voices)
+	s.SetHarmonyDry(*harmony.dry)
+	s.SetStretchBuffer(int(*stretchBuffer * float64(*sampleRate)))
+	s.SetStretch(*stretch)
+	if err := s.SetWindow(windowShape); err != nil {
+		log.Fatal(err)
+	}
+	if err := s.SetZeroPad(*zeroPad); err != nil {
+		log.Fatal(err)
+	}
+	if err := s.SetParams(algoParams); err != nil {
+		log.Fatal(err)
+	}
+
+	defer s.Destroy()
+
+	// Pitch shift callback
+	deviceCallbacks := gominiaudio.DeviceCallbacks{
+		Data: func(_ *gominiaudio.Device, output, input []byte, frames uint32) {
+			s.process(output, input, frames)
+		},
+	}
+
+	// Init audio
+	var device *gominiaudio.Device
+	device, err = gominiaudio.InitDevice(ctx, deviceConfig, deviceCallbacks)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer func() { device.Uninit() }()
+
+	// Start audio processing
+	go func() {
+		if startErr := device.Start(); startErr != nil {
+			os.Exit(1)
+		}
+	}()
+
+	// restartAudio stops the current device and opens a new one with the
+	// provided capture/playback device IDs (nil = system default).
+	restartAudio := func(captureID, playbackID *gominiaudio.DeviceID) {
+		device.Uninit()
+		if captureID != nil {
+			c := *captureID
+			deviceConfig.Capture.DeviceID = &c
+		} else {
+			deviceConfig.Capture.DeviceID = nil
+		}
+		if playbackID != nil {
+			p := *playbackID
+			deviceConfig.Playback.DeviceID = &p
+		} else {
+			deviceConfig.Playback.DeviceID = nil
+		}
+		d, initErr := gominiaudio.InitDevice(ctx, deviceConfig, deviceCallbacks)
+		if initErr != nil {
+			fmt.Println("device switch failed:", initErr)
+			return
+		}
+		device = d
+		if startErr := d.Start(); startErr != nil {
+			fmt.Println("device start failed:", startErr)
+		}
+	}
+
+	// Init GUI
+	if *guiOn {
+		window = gui(s, captureDevices, playbackDevices, initialInputIdx, initialOutputIdx, restartAudio)
+	}
+
+	// Start GUI or wait for interrupt
+	switch *guiOn {
+	case true:
+		window.ShowAndRun()
+	default:
+		exclStr := "No"
+		if *exclusive {
+			exclStr = "Yes"
+		}
+		ditherStr := "No"
+		if *dither && !sampleFormat.IsFloat() {
+			ditherStr = "TPDF"
+		}
+		formantStr := "No"
+		switch {
+		case *formantShift != 0:
+			formantStr = fmt.Sprintf("%+d semitones", *formantShift)
+		case *formants:
+			formantStr = "Preserved"
+		}
+		latencyMs := float64(s.latency()) / s.sampleRate * 1000.0
+		fmt.Printf("\nPitcher — running parameters:\n")
+		fmt.Printf("  Algorithm:    %s (%s)\n", algo.FullName, algo.ShortName)
+		fmt.Printf("  Params:       %s\n", describeParams(s.context()))
+		fmt.Printf("  Shift:        %+d semitones\n", *shift)
+		fmt.Printf("  Formants:     %s\n", formantStr)
+		fmt.Printf("  Mix:          %.0f%% wet\n", *mix*100)
+		fmt.Printf("  Auto-tune:    %s\n", describeTune(*tune.enable, tuneSettings))
+		if len(voices) > 0 {
+			fmt.Printf("  Harmonizer:   %s, dry %g\n", describeVoices(voices), *harmony.dry)
+		}
+		if *stretch != 1 {
+			fmt.Printf("  Stretch:      %gx, %g s buffer\n", *stretch, *stretchBuffer)
+		}
+		fmt.Printf("  Frame size:   %d\n", *frameSize)
+		fmt.Printf("  Oversampling: %d\n", *overSampling)
+		fmt.Printf("  Zero padding: %dx\n", *zeroPad)
+		fmt.Printf("  Window:       %s\n", windowShape)
+		fmt.Printf("  Sample rate:  %d Hz\n", *sampleRate)
+		fmt.Printf("  Channels:     %s (%d processed)\n", layout, layout.process)
+		fmt.Printf("  Format:       %v\n", sampleFormat)
+		fmt.Printf("  Dither:       %s\n", ditherStr)
+		fmt.Printf("  Periods:      %d\n", *periods)
+		fmt.Printf("  Buffer size:  %d frames\n", *bufferSize)
+		fmt.Printf("  Exclusive:    %s\n", exclStr)
+		fmt.Printf("  Latency:      %.1f ms\n\n", latencyMs)
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		fmt.Println("Press Ctrl-C / Cmd-. to exit")
+		if *showPitch {
+			go func() {
+				for range time.Tick(250 * time.Millisecond) {
+					fmt.Printf("\r  Pitch:        %-40s", formatPitch(s.DetectedPitch()))
+				}
+			}()
+		}
+		<-c
+		fmt.Println("Exiting...")
+		os.Exit(0)
+	}
+}
+
+// Frame size limits accepted by the CLI.
+const (
+	minFrameSize = 16
+	maxFrameSize = 65536
+)
+
+// checkDSPFlags validates the DSP flags shared by live and offline modes.
+func checkDSPFlags(shift, formantShift, frameSize, overSampling int) error {
+	if shift < -12 || shift > 12 {
+		return errors.New("\"shift\" flag must be between -12 and 12 inclusive")
+	}
+	if formantShift < -12 || formantShift > 12 {
+		return errors.New("\"formantshift\" flag must be between -12 and 12 inclusive")
+	}
+	if frameSize < minFrameSize || frameSize > maxFrameSize {
+		return fmt.Errorf("\"framesize\" must be between %d and %d", minFrameSize, maxFrameSize)
+	}
+	if overSampling <= 0 || math.Ceil(math.Log2(float64(overSampling))) != math.Floor(math.Log2(float64(overSampling))) {
+		return errors.New("\"oversampling\" must be a power of 2")
+	}
+	if frameSize%overSampling != 0 {
+		return errors.New("\"framesize\" must be a multiple of \"oversampling\"")
+	}
+	return nil
+}
+
+// checkStretch validates a --stretch ratio.
+func checkStretch(stretch float64) error {
+	if stretch < algos.MinTimeStretch || stretch > algos.MaxTimeStretch {
+		return fmt.Errorf("\"stretch\" must be between %g and %g", algos.MinTimeStretch, algos.MaxTimeStretch)
+	}
+	return nil
+}
+
+// checkMix validates a --mix value.
+func checkMix(mix float64) error {
+	if mix < 0 || mix > 1 {
+		return errors.New("\"mix\" must be between 0 and 1")
+	}
+	return nil
+}
+
+// voicedConfidence is the detector confidence below which a frame is shown
+// as unvoiced.
+const voicedConfidence = 0.5
+
+// formatPitch describes a pitch estimate for display, e.g.
+// "220.0 Hz  A3 +2c  (93%)".
+func formatPitch(e pitchdetect.Estimate) string {
+	if !e.Voiced(voicedConfidence) {
+		return "—"
+	}
+	return fmt.Sprintf("%.1f Hz  %s  (%.0f%%)", e.F0, pitchdetect.NoteName(e.F0), e.Confidence*100)
+}
+
+func printDeviceList(inputs, outputs []gominiaudio.DeviceInfo) {
+	fmt.Println("Input devices (use with --input N):")
+	for i, d := range inputs {
+		def := ""
+		if d.IsDefault {
+			def = " [default]"
+		}
+		fmt.Printf("  %d: %s%s\n", i, d.Name, def)
+	}
+	fmt.Println("\nOutput devices (use with --output N):")
+	for i, d := range outputs {
+		def := ""
+		if d.IsDefault {
+			def = " [default]"
+		}
+		fmt.Printf("  %d: %s%s\n", i, d.Name, def)
+	}
+}
+
+func findDefaultDeviceIdx(devices []gominiaudio.DeviceInfo) int {
+	for i, d := range devices {
+		if d.IsDefault {
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package main
+
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/intermernet/pitcher/algos"
+)
+
+// params is the block of control values shared between control goroutines
+// (GUI, CLI) and the audio callback. Setters store each value atomically and
+// the callback loads a snapshot at the start of every block, so neither side
+// ever waits for the other.
+type params struct {
+	pitchShift   atomicFloat64
+	formantShift atomicFloat64
+	volume       atomicFloat64
+	mix          atomicFloat64
+	ramp         atomicFloat64
+	stretch      atomicFloat64
+	harmonyDry   atomicFloat64
+	formants     atomic.Bool
+	tracking     atomic.Bool
+	dither       atomic.Bool
+	tune         atomic.Pointer[tuneParams]
+}
+
+// tuneParams is the auto-tune state, replaced as a whole so the callback
+// never sees the switch and the settings out of step.
+type tuneParams struct {
+	on       bool
+	settings algos.TuneSettings
+}
+
+// newParams returns a parameter block with the Context defaults.
+func newParams(pitchShift float64) *params {
+	p := new(params)
+	p.pitchShift.Store(pitchShift)
+	p.volume.Store(1)
+	p.mix.Store(1)
+	p.ramp.Store(algos.DefaultRamp)
+	p.stretch.Store(1)
+	p.harmonyDry.Store(1)
+	p.tune.Store(&tuneParams{})
+	return p
+}
+
+// atomicFloat64 is a float64 with atomic loads and stores.
+type atomicFloat64 struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat64) Load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+func (f *atomicFloat64) Store(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
@@ -0,0 +1,38 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package pitchdetect
+
+import (
+	"fmt"
+	"math"
+)
+
+// noteNames are the pitch classes starting at C.
+var noteNames = [12]string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// MIDINote converts a frequency in Hz to a fractional MIDI note number
+// (A4 = 440 Hz = 69).
+func MIDINote(freq float64) float64 {
+	return 69 + 12*math.Log2(freq/440)
+}
+
+// Frequency converts a fractional MIDI note number to Hz.
+func Frequency(note float64) float64 {
+	return 440 * math.Exp2((note-69)/12)
+}
+
+// NoteName formats a frequency as the nearest note and its deviation in
+// cents, e.g. "A4 +3c".
+func NoteName(freq float64) string {
+	if freq <= 0 {
+		return "—"
+	}
+	m := MIDINote(freq)
+	n := int(math.Round(m))
+	cents := int(math.Round((m - float64(n)) * 100))
+	return fmt.Sprintf("%s%d %+dc", noteNames[(n%12+12)%12], n/12-1, cents)
+}
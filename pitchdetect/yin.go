@@ -0,0 +1,191 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+*****************************************************************************
+*
+* YIN fundamental frequency estimator.
+*
+*   A. de Cheveigné & H. Kawahara, "YIN, a fundamental frequency estimator
+*   for speech and music", J. Acoust. Soc. Am. 111(4), 2002.
+*
+* The difference function d(τ) is computed in O(N log N) from the
+* autocorrelation (via FFT) and running energy sums:
+*
+*   d(τ) = Σ x[j]² + Σ x[j+τ]² − 2·Σ x[j]·x[j+τ],   j = 0 … W−1
+*
+* followed by the cumulative mean normalised difference d'(τ), an absolute
+* threshold search for the first dip, and parabolic interpolation of the
+* chosen lag.
+*
+*****************************************************************************/
+
+// Package pitchdetect estimates the fundamental frequency (F0) of short audio
+// frames. It is independent of the audio pipeline so the algorithms, the GUI
+// and the CLI can all use it on the frames they already have.
+package pitchdetect
+
+import (
+	"math"
+
+	"github.com/intermernet/gofftw/fft"
+)
+
+// Defaults used by NewDetector.
+const (
+	DefaultMinFreq   = 60.0   // Hz
+	DefaultMaxFreq   = 1500.0 // Hz
+	DefaultThreshold = 0.15   // YIN absolute threshold on d'(τ)
+)
+
+// silence is the mean-square level below which a frame is treated as silent.
+const silence = 1e-10
+
+// Estimate is the result of analysing one frame.
+type Estimate struct {
+	// F0 is the fundamental frequency in Hz, or 0 for a silent frame.
+	F0 float64
+	// Confidence is 1 − d'(τ) at the chosen lag, in [0, 1]. Clean periodic
+	// signals score close to 1, noise close to 0.
+	Confidence float64
+}
+
+// Voiced reports whether e is periodic with at least minConfidence.
+func (e Estimate) Voiced(minConfidence float64) bool {
+	return e.F0 > 0 && e.Confidence >= minConfidence
+}
+
+// Detector is a YIN pitch detector for frames of a fixed size. It owns its
+// scratch buffers, so Detect does not allocate, and it is not safe for
+// concurrent use.
+type Detector struct {
+	SampleRate float64
+	// MinFreq and MaxFreq bound the search range in Hz. The lowest
+	// detectable F0 is also limited to 2·SampleRate/frameSize.
+	MinFreq, MaxFreq float64
+	// Threshold is the YIN absolute threshold: the first lag whose d'(τ)
+	// falls below it is taken as the period.
+	Threshold float64
+
+	size     int
+	fwd, inv *fft.Plan
+	a, b     []complex128
+	energy   []float64 // prefix sums of x²
+	cmnd     []float64 // d'(τ)
+}
+
+// NewDetector returns a Detector for frames of frameSize samples at
+// sampleRate, using the default search range and threshold.
+func NewDetector(sampleRate float64, frameSize int) *Detector {
+	return &Detector{
+		SampleRate: sampleRate,
+		MinFreq:    DefaultMinFreq,
+		MaxFreq:    DefaultMaxFreq,
+		Threshold:  DefaultThreshold,
+		size:       frameSize,
+		fwd:        fft.NewPlan(frameSize, fft.Forward),
+		inv:        fft.NewPlan(frameSize, fft.Backward),
+		a:          make([]complex128, frameSize),
+		b:          make([]complex128, frameSize),
+		energy:     make([]float64, frameSize+1),
+		cmnd:       make([]float64, frameSize/2+1),
+	}
+}
+
+// FrameSize returns the number of samples Detect analyses.
+func (d *Detector) FrameSize() int {
+	return d.size
+}
+
+// Detect estimates the F0 of the first FrameSize samples of frame.
+func (d *Detector) Detect(frame []float64) Estimate {
+	N := d.size
+	W := N / 2
+	x := frame[:N]
+
+	for i, v := range x {
+		d.energy[i+1] = d.energy[i] + v*v
+	}
+	e0 := d.energy[W]
+	if e0 < silence*float64(W) {
+		return Estimate{}
+	}
+
+	minTau := max(2, int(d.SampleRate/d.MaxFreq))
+	maxTau := min(W, int(math.Ceil(d.SampleRate/d.MinFreq)))
+	if minTau >= maxTau-1 {
+		return Estimate{}
+	}
+
+	// Autocorrelation r(τ) = Σ x[j]·x[j+τ] over the first W samples. With
+	// the first operand zero-padded to N there is no circular wrap for
+	// τ ≤ N − W.
+	for j := 0; j < N; j++ {
+		if j < W {
+			d.a[j] = complex(x[j], 0)
+		} else {
+			d.a[j] = 0
+		}
+		d.b[j] = complex(x[j], 0)
+	}
+	d.fwd.Execute(d.a, d.a)
+	d.fwd.Execute(d.b, d.b)
+	for k := range d.a {
+		a := d.a[k]
+		d.a[k] = complex(real(a), -imag(a)) * d.b[k]
+	}
+	d.inv.Execute(d.a, d.a)
+	scale := 1 / float64(N)
+
+	// Cumulative mean normalised difference.
+	d.cmnd[0] = 1
+	running := 0.0
+	for tau := 1; tau <= maxTau; tau++ {
+		r := real(d.a[tau]) * scale
+		diff := e0 + d.energy[tau+W] - d.energy[tau] - 2*r
+		if diff < 0 {
+			diff = 0
+		}
+		running += diff
+		if running > 0 {
+			d.cmnd[tau] = diff * float64(tau) / running
+		} else {
+			d.cmnd[tau] = 1
+		}
+	}
+
+	// First dip below the threshold, followed down to its local minimum;
+	// otherwise the global minimum.
+	best := -1
+	for tau := minTau; tau < maxTau; tau++ {
+		if d.cmnd[tau] < d.Threshold {
+			for tau+1 < maxTau && d.cmnd[tau+1] < d.cmnd[tau] {
+				tau++
+			}
+			best = tau
+			break
+		}
+	}
+	if best < 0 {
+		best = minTau
+		for tau := minTau + 1; tau < maxTau; tau++ {
+			if d.cmnd[tau] < d.cmnd[best] {
+				best = tau
+			}
+		}
+	}
+
+	// Parabolic interpolation around the chosen lag.
+	period := float64(best)
+	if best > 1 && best < maxTau {
+		y0, y1, y2 := d.cmnd[best-1], d.cmnd[best], d.cmnd[best+1]
+		if den := y0 - 2*y1 + y2; den > 0 {
+			period += 0.5 * (y0 - y2) / den
+		}
+	}
+
+	return Estimate{
+		F0:         d.SampleRate / period,
+		Confidence: math.Max(0, math.Min(1, 1-d.cmnd[best])),
+	}
+}
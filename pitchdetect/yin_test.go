@@ -0,0 +1,91 @@
+package pitchdetect
+
+import (
+	"math"
+	"testing"
+)
+
+const (
+	testSampleRate = 48000.0
+	testFrameSize  = 2048
+)
+
+// harmonicFrame returns a frame of a harmonic tone at f0 with the given
+// partial amplitudes (amps[0] is the fundamental).
+func harmonicFrame(f0 float64, amps ...float64) []float64 {
+	frame := make([]float64, testFrameSize)
+	for h, a := range amps {
+		for i := range frame {
+			frame[i] += a * math.Sin(2*math.Pi*f0*float64(h+1)*float64(i)/testSampleRate+0.3*float64(h))
+		}
+	}
+	return frame
+}
+
+func TestDetectSine(t *testing.T) {
+	d := NewDetector(testSampleRate, testFrameSize)
+	for _, f0 := range []float64{82.4, 110, 220, 440, 880, 1200} {
+		e := d.Detect(harmonicFrame(f0, 0.5))
+		if math.Abs(e.F0-f0)/f0 > 0.005 {
+			t.Errorf("%.1f Hz sine: detected %.2f Hz", f0, e.F0)
+		}
+		if e.Confidence < 0.9 {
+			t.Errorf("%.1f Hz sine: confidence %.3f, want >= 0.9", f0, e.Confidence)
+		}
+	}
+}
+
+func TestDetectHarmonic(t *testing.T) {
+	d := NewDetector(testSampleRate, testFrameSize)
+	for _, f0 := range []float64{98, 150, 261.6, 523.3} {
+		// Sawtooth-like spectrum, and one with a weak fundamental that a
+		// naive peak picker would place an octave up.
+		for _, amps := range [][]float64{
+			{0.5, 0.25, 0.167, 0.125, 0.1, 0.083},
+			{0.05, 0.4, 0.3, 0.2},
+		} {
+			e := d.Detect(harmonicFrame(f0, amps...))
+			if math.Abs(e.F0-f0)/f0 > 0.005 {
+				t.Errorf("%.1f Hz harmonic %v: detected %.2f Hz", f0, amps, e.F0)
+			}
+			if !e.Voiced(0.8) {
+				t.Errorf("%.1f Hz harmonic %v: confidence %.3f", f0, amps, e.Confidence)
+			}
+		}
+	}
+}
+
+func TestDetectSilenceAndNoise(t *testing.T) {
+	d := NewDetector(testSampleRate, testFrameSize)
+	if e := d.Detect(make([]float64, testFrameSize)); e != (Estimate{}) {
+		t.Errorf("silence: got %+v, want zero Estimate", e)
+	}
+
+	noise := make([]float64, testFrameSize)
+	x := uint64(0x9E3779B97F4A7C15)
+	for i := range noise {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+		noise[i] = float64(x>>11)/(1<<53) - 0.5
+	}
+	if e := d.Detect(noise); e.Voiced(0.5) {
+		t.Errorf("white noise detected as voiced: %+v", e)
+	}
+}
+
+func TestNoteName(t *testing.T) {
+	for _, tc := range []struct {
+		freq float64
+		want string
+	}{
+		{440, "A4 +0c"},
+		{261.63, "C4 +0c"},
+		{445, "A4 +20c"},
+		{0, "—"},
+	} {
+		if got := NoteName(tc.freq); got != tc.want {
+			t.Errorf("NoteName(%v) = %q, want %q", tc.freq, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/intermernet/pitcher/algos"
+	"github.com/intermernet/pitcher/wavio"
+)
+
+// runRender implements "pitcher render": it pitch-shifts a WAV file offline,
+// feeding the active algorithm fixed-size blocks exactly as the duplex audio
+// callback would, and writes the result to a new WAV file. LIST/INFO and
+// other metadata chunks are carried over from the input.
+func runRender(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: pitcher render [flags] <input.wav> <output.wav>")
+		fs.PrintDefaults()
+	}
+	shiftFlag := fs.Int("shift", 0, "Semitones to pitch-shift. Must be between -12 and +12")
+	algoFlag := fs.String("algo", algos.Default().ShortName, "Pitch-shifting algorithm. Options: "+algos.NamesString())
+	frameSize := fs.Int("framesize", 0, "Analysis frame size in samples. Any length that --oversampling divides, e.g. 480 for 10 ms at 48 kHz (0 = use algorithm default)")
+	zeroPad := fs.Int("zeropad", 1, "Zero-padding factor of the STFT algorithms (1-8): a longer FFT for finer frequency resolution at the same frame size and latency")
+	overSampling := fs.Int("oversampling", 0, "Pitch shift oversampling. Must be a power of 2 (0 = use algorithm default)")
+	bufferSize := fs.Int("buffersize", 256, "Block size in frames passed to the algorithm per call (emulates the audio period size)")
+	compensate := fs.Bool("compensate", true, "Trim the algorithm latency so the output lines up with the input")
+	formants := fs.Bool("formants", false, "Preserve formants while shifting (phasvoc, plvoc, llstft, stn, sss)")
+	tune := addTuneFlags(fs)
+	harmony := addHarmonyFlags(fs)
+	algoParams := addParamFlag(fs)
+	windowFlag := fs.String("window", algos.DefaultWindow.String(), "Analysis window: "+strings.Join(algos.WindowNames, ", ")+". kaiser takes a beta and asym a peak position, e.g. kaiser:8 or asym:0.8")
+	formantShift := fs.Int("formantshift", 0, "Semitones to shift formants independently of pitch. Must be between -12 and +12 (phasvoc, plvoc, llstft, stn, sss)")
+	mix := fs.Float64("mix", 1, "Wet/dry balance from 0 (dry input only) to 1 (shifted signal only)")
+	stretch := fs.Float64("stretch", 1, "Time-stretch ratio: output duration divided by input duration, from 0.25 to 4, without changing pitch")
+	formatFlag := fs.String("format", "", "Output sample format: s16, s24, s32, f32 or f64 (default: same as input)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("render needs an input and an output file")
+	}
+	inPath, outPath := fs.Arg(0), fs.Arg(1)
+
+	algo, ok := algos.Find(*algoFlag)
+	if !ok {
+		return fmt.Errorf("unknown algorithm %q — valid options: %v", *algoFlag, algos.Names())
+	}
+	if *frameSize == 0 {
+		*frameSize = algo.Defaults.FrameSize
+	}
+	if *overSampling == 0 {
+		*overSampling = algo.Defaults.Oversampling
+	}
+	if err := checkDSPFlags(*shiftFlag, *formantShift, *frameSize, *overSampling); err != nil {
+		return err
+	}
+	tuneSettings, err := tune.settings()
+	if err != nil {
+		return err
+	}
+	voices, err := harmony.voices()
+	if err != nil {
+		return err
+	}
+	if *bufferSize <= 0 || *bufferSize > algos.MaxBlock {
+		return fmt.Errorf("\"buffersize\" must be between 1 and %d", algos.MaxBlock)
+	}
+	if err := checkStretch(*stretch); err != nil {
+		return err
+	}
+	if err := checkMix(*mix); err != nil {
+		return err
+	}
+	window, err := algos.ParseWindow(*windowFlag)
+	if err != nil {
+		return err
+	}
+	if *stretch != 1 && len(voices) > 0 {
+		return errors.New("\"stretch\" cannot be combined with \"voice\"")
+	}
+
+	inFile, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+	r, err := wavio.NewReader(inFile)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", inPath, err)
+	}
+	outFormat := r.Format
+	if *formatFlag != "" {
+		if outFormat.SampleFormat, err = wavio.ParseSampleFormat(*formatFlag); err != nil {
+			return err
+		}
+	}
+
+	// The algorithms consume interleaved float32, the same sample layout
+	// the live device delivers.
+	format := wavio.Float32
+	ctx := algos.NewContext(float64(*shiftFlag), *frameSize, *overSampling, float64(r.SampleRate), format, r.Channels, algo)
+	ctx.FormantPreserve = *formants
+	ctx.FormantShift = float64(*formantShift)
+	ctx.Mix = *mix
+	ctx.AutoTune = *tune.enable
+	ctx.Tune = tuneSettings
+	if err := ctx.SetWindow(window); err != nil {
+		return err
+	}
+	if err := ctx.SetZeroPad(*zeroPad); err != nil {
+		return err
+	}
+	if err := ctx.SetParams(algoParams); err != nil {
+		return err
+	}
+	process := ctx.Process
+	delay := ctx.Delay()
+	if len(voices) > 0 {
+		h := algos.NewHarmonizer(voices, ctx, r.Channels)
+		h.Dry = *harmony.dry
+		h.Transpose = ctx.PitchShift
+		h.FormantShift = ctx.FormantShift
+		h.Mix = ctx.Mix
+		h.FormantPreserve = ctx.FormantPreserve
+		h.AutoTune, h.Tune = ctx.AutoTune, ctx.Tune
+		process, delay = h.Process, h.Latency()
+	}
+
+	outFile, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+	w, err := wavio.NewWriter(outFile, outFormat, r.Chunks...)
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", outPath, err)
+	}
+
+	planes := make([][]float64, r.Channels)
+	stretched := make([][]float64, r.Channels)
+	view := make([][]float64, r.Channels)
+	for c := range planes {
+		planes[c] = make([]float64, *bufferSize)
+		stretched[c] = make([]float64, *bufferSize)
+	}
+	// Time stretching resamples the input ahead of the algorithm, which
+	// then restores the pitch.
+	var vs *algos.Varispeed
+	if *stretch != 1 {
+		vs = algos.NewVarispeed(format, r.Channels, *bufferSize, float64(r.SampleRate))
+		vs.Speed = 1 / *stretch
+		ctx.TimeStretch = *stretch
+	}
+	bytesPerFrame := format.Size() * r.Channels
+	in := make([]byte, *bufferSize*bytesPerFrame)
+	out := make([]byte, len(in))
+
+	// skip counts output frames still to be discarded for latency
+	// compensation; tail counts the silent frames needed to flush the
+	// algorithm once the input is exhausted.
+	skip, tail := 0, 0
+	if *compensate {
+		skip, tail = delay, delay
+	}
+	var inFrames, outFrames int
+
+	// emit runs the first n frames of src through the algorithm and writes
+	// the result, less any frames still to be skipped.
+	emit := func(src [][]float64, n int) error {
+		wavio.Interleave(in, src, format, n)
+		process(out[:n*bytesPerFrame], in[:n*bytesPerFrame])
+		drop := min(skip, n)
+		skip -= drop
+		if drop == n {
+			return nil
+		}
+		kept := wavio.Deinterleave(src, out[drop*bytesPerFrame:n*bytesPerFrame], format)
+		for c := range src {
+			view[c] = src[c][:kept]
+		}
+		if err := w.WriteFloat(view); err != nil {
+			return fmt.Errorf("writing %s: %w", outPath, err)
+		}
+		outFrames += n - drop
+		return nil
+	}
+	// drain emits everything the varispeed can produce from its input.
+	drain := func() error {
+		for n := vs.Read(stretched); n > 0; n = vs.Read(stretched) {
+			if err := emit(stretched, n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for {
+		n, readErr := r.ReadFloat(planes)
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return fmt.Errorf("reading %s: %w", inPath, readErr)
+		}
+		inFrames += n
+		if vs == nil {
+			err = emit(planes, n)
+		} else {
+			vs.Write(planes, n)
+			err = drain()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if vs != nil {
+		vs.Flush()
+		if err := drain(); err != nil {
+			return err
+		}
+	}
+	for tail > 0 {
+		n := min(tail, *bufferSize)
+		for c := range planes {
+			clear(planes[c][:n])
+		}
+		if err := emit(planes, n); err != nil {
+			return err
+		}
+		tail -= n
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", outPath, err)
+	}
+	if err := outFile.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Rendered %s → %s\n", inPath, outPath)
+	fmt.Printf("  Algorithm:    %s (%s)\n", algo.FullName, algo.ShortName)
+	if len(algoParams) > 0 {
+		fmt.Printf("  Params:       %s\n", describeParams(ctx))
+	}
+	fmt.Printf("  Shift:        %+d semitones\n", *shiftFlag)
+	switch {
+	case ctx.FormantShift != 0:
+		fmt.Printf("  Formants:     %+d semitones\n", *formantShift)
+	case ctx.FormantPreserve:
+		fmt.Printf("  Formants:     Preserved\n")
+	}
+	if ctx.AutoTune {
+		fmt.Printf("  Auto-tune:    %s\n", describeTune(ctx.AutoTune, ctx.Tune))
+	}
+	if len(voices) > 0 {
+		fmt.Printf("  Harmonizer:   %s, dry %g\n", describeVoices(voices), *harmony.dry)
+	}
+	if ctx.Mix != 1 {
+		fmt.Printf("  Mix:          %.0f%% wet\n", ctx.Mix*100)
+	}
+	if vs != nil {
+		fmt.Printf("  Stretch:      %gx\n", *stretch)
+	}
+	fmt.Printf("  Frame size:   %d\n", *frameSize)
+	fmt.Printf("  Oversampling: %d\n", *overSampling)
+	if ctx.ZeroPad != 1 {
+		fmt.Printf("  Zero padding: %dx\n", ctx.ZeroPad)
+	}
+	if window != algos.DefaultWindow {
+		fmt.Printf("  Window:       %s\n", window)
+	}
+	fmt.Printf("  Sample rate:  %d Hz\n", r.SampleRate)
+	fmt.Printf("  Channels:     %d\n", r.Channels)
+	fmt.Printf("  Format:       %v → %v\n", r.SampleFormat, outFormat.SampleFormat)
+	fmt.Printf("  Frames:       %d in, %d out\n", inFrames, outFrames)
+	return nil
+}
@@ -0,0 +1,966 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/intermernet/pitcher/algos"
+	"github.com/intermernet/pitcher/pitchdetect"
+	"github.com/intermernet/pitcher/wavio"
+)
+
+// writeTestWAV writes a 1-second stereo float32 WAV of a 440 Hz sine.
+func writeTestWAV(t *testing.T, path string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := wavio.NewWriter(f, wavio.Format{SampleFormat: wavio.Float32, Channels: testChannels, SampleRate: testSampleRate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, _ := generateSineFrame(440, testSampleRate, testSampleRate, 0)
+	if _, err := w.Write(frames); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestWAV decodes a WAV file into per-channel samples.
+func readTestWAV(t *testing.T, path string) [][]float64 {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := wavio.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planes, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return planes
+}
+
+// halfGain is a minimal out-of-tree style Processor: it halves the input
+// with no latency, using only the exported Context helpers.
+type halfGain struct {
+	ctx *algos.Context
+}
+
+func (h halfGain) Process(output, input []byte) {
+	for c := 0; c < int(h.ctx.Channels); c++ {
+		n := h.ctx.ReadChannel(input, c)
+		for i := range h.ctx.F64Buf[:n] {
+			h.ctx.F64Buf[i] *= 0.5
+		}
+		h.ctx.WriteChannel(output, c, n)
+	}
+}
+
+func (halfGain) Reset()                {}
+func (halfGain) Latency() int          { return 0 }
+func (halfGain) Params() []algos.Param { return nil }
+
+func TestRegisterAlgorithm(t *testing.T) {
+	registered := len(algos.Algorithms)
+	t.Cleanup(func() { algos.Algorithms = algos.Algorithms[:registered] })
+	algos.Register(algos.Algorithm{
+		FullName:  "Half Gain",
+		ShortName: "halfgain",
+		Defaults:  algos.Defaults{FrameSize: 512, Oversampling: 4},
+		New:       func(ctx *algos.Context) algos.Processor { return halfGain{ctx} },
+	})
+	if !slices.Contains(algos.Names(), "halfgain") || !slices.Contains(algos.FullNames(), "Half Gain") {
+		t.Fatalf("registered algorithm missing from Names %v or FullNames %v", algos.Names(), algos.FullNames())
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("registering a duplicate short name did not panic")
+			}
+		}()
+		algos.Register(algos.Algorithm{ShortName: "halfgain", New: func(ctx *algos.Context) algos.Processor { return halfGain{ctx} }})
+	}()
+	// The GUI selects by full name, which falls back to the short name.
+	for _, a := range []algos.Algorithm{
+		{FullName: "Half Gain", ShortName: "halfgain2"},
+		{ShortName: "Half Gain"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %q (%s) with a duplicate full name did not panic", a.FullName, a.ShortName)
+				}
+			}()
+			a.New = func(ctx *algos.Context) algos.Processor { return halfGain{ctx} }
+			algos.Register(a)
+		}()
+	}
+
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.wav")
+	out := filepath.Join(dir, "out.wav")
+	writeTestWAV(t, in)
+	if err := runRender([]string{"--algo", "halfgain", in, out}); err != nil {
+		t.Fatal(err)
+	}
+	src, got := readTestWAV(t, in), readTestWAV(t, out)
+	for c := range src {
+		for i := range src[c] {
+			if math.Abs(got[c][i]-0.5*src[c][i]) > 1e-6 {
+				t.Fatalf("ch%d sample %d = %g, want %g", c, i, got[c][i], 0.5*src[c][i])
+			}
+		}
+	}
+}
+
+func TestRenderAllAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.wav")
+	writeTestWAV(t, in)
+	src := readTestWAV(t, in)
+
+	for _, a := range algos.Algorithms {
+		t.Run(a.ShortName, func(t *testing.T) {
+			out := filepath.Join(dir, a.ShortName+".wav")
+			if err := runRender([]string{"--algo", a.ShortName, in, out}); err != nil {
+				t.Fatal(err)
+			}
+			got := readTestWAV(t, out)
+			if len(got) != testChannels || len(got[0]) != len(src[0]) {
+				t.Fatalf("output shape %dx%d, want %dx%d", len(got), len(got[0]), testChannels, len(src[0]))
+			}
+
+			// With latency compensation, a 0-semitone render should line up
+			// with the input.
+			var dot, eIn, eOut float64
+			for i := 4096; i < len(src[0])-4096; i++ {
+				dot += src[0][i] * got[0][i]
+				eIn += src[0][i] * src[0][i]
+				eOut += got[0][i] * got[0][i]
+			}
+			corr := dot / math.Sqrt(eIn*eOut)
+			t.Logf("correlation with input: %.4f", corr)
+			if corr < 0.9 {
+				t.Errorf("output not aligned with input (correlation %.4f)", corr)
+			}
+		})
+	}
+}
+
+func TestRenderDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.wav")
+	writeTestWAV(t, in)
+
+	var outputs [2][]byte
+	for i := range outputs {
+		out := filepath.Join(dir, "out.wav")
+		if err := runRender([]string{"--algo", "stn", "--shift", "5", in, out}); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs[i] = data
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Error("two renders of the same input differ")
+	}
+}
+
+// writePlanesWAV writes per-channel samples to a float32 WAV file.
+func writePlanesWAV(t *testing.T, path string, planes [][]float64) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := wavio.NewWriter(f, wavio.Format{SampleFormat: wavio.Float32, Channels: len(planes), SampleRate: testSampleRate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteFloat(planes); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// harmonicTone returns one second of a harmonic tone at f0 whose partials
+// fall off as 1/h.
+func harmonicTone(f0 float64) []float64 {
+	x := make([]float64, testSampleRate)
+	for h := 1; h <= 8; h++ {
+		for i := range x {
+			x[i] += 0.1 / float64(h) * math.Sin(2*math.Pi*f0*float64(h)*float64(i)/testSampleRate)
+		}
+	}
+	return x
+}
+
+// sineTone returns one second of a full-scale sine at freq.
+func sineTone(freq float64) []float64 {
+	x := make([]float64, testSampleRate)
+	for i := range x {
+		x[i] = math.Sin(2 * math.Pi * freq * float64(i) / testSampleRate)
+	}
+	return x
+}
+
+// toneWAV writes tone to a mono WAV file in a new temporary directory and
+// returns its path and one for the rendered output.
+func toneWAV(t *testing.T, tone []float64) (in, out string) {
+	t.Helper()
+	dir := t.TempDir()
+	in, out = filepath.Join(dir, "in.wav"), filepath.Join(dir, "out.wav")
+	writePlanesWAV(t, in, [][]float64{tone})
+	return in, out
+}
+
+// renderTone renders tone with the render arguments args and returns the
+// first output channel.
+func renderTone(t *testing.T, tone []float64, args ...string) []float64 {
+	t.Helper()
+	in, out := toneWAV(t, tone)
+	if err := runRender(append(slices.Clip(args), in, out)); err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return readTestWAV(t, out)[0]
+}
+
+// spectralCentroid returns the power-weighted mean frequency of x below
+// 4 kHz, measured with a Hann-windowed DFT at 25 Hz spacing.
+func spectralCentroid(x []float64, sampleRate float64) float64 {
+	var num, den float64
+	for f := 25.0; f < 4000; f += 25 {
+		var re, im float64
+		for n, v := range x {
+			w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(n)/float64(len(x)))
+			ph := 2 * math.Pi * f * float64(n) / sampleRate
+			re += w * v * math.Cos(ph)
+			im -= w * v * math.Sin(ph)
+		}
+		p := re*re + im*im
+		num += f * p
+		den += p
+	}
+	return num / den
+}
+
+func TestRenderFormants(t *testing.T) {
+	// A 150 Hz harmonic series shaped by a single resonance at 1 kHz.
+	const f0, formant = 150.0, 1000.0
+	src := make([]float64, testSampleRate)
+	for h := 1; float64(h)*f0 < testSampleRate/2; h++ {
+		f := float64(h) * f0
+		a := math.Exp(-(f - formant) * (f - formant) / (2 * 250 * 250))
+		for i := range src {
+			src[i] += 0.05 * a * math.Sin(2*math.Pi*f*float64(i)/testSampleRate)
+		}
+	}
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.wav")
+	writePlanesWAV(t, in, [][]float64{src})
+
+	mid := len(src)/2 - 4096
+	want := spectralCentroid(src[mid:mid+8192], testSampleRate)
+	for _, algo := range []string{"phasvoc", "llstft", "stn", "sss", "plvoc"} {
+		t.Run(algo, func(t *testing.T) {
+			centroid := func(extra ...string) float64 {
+				out := filepath.Join(dir, algo+".wav")
+				args := append([]string{"--algo", algo, "--framesize", "2048", "--shift", "7"}, extra...)
+				if err := runRender(append(args, in, out)); err != nil {
+					t.Fatal(err)
+				}
+				return spectralCentroid(readTestWAV(t, out)[0][mid:mid+8192], testSampleRate)
+			}
+			plain, kept := centroid(), centroid("--formants")
+			t.Logf("centroid: input %.0f Hz, shifted %.0f Hz, with --formants %.0f Hz", want, plain, kept)
+			if math.Abs(kept-want) >= math.Abs(plain-want) {
+				t.Errorf("--formants left the centroid at %.0f Hz; input %.0f Hz, without %.0f Hz", kept, want, plain)
+			}
+		})
+	}
+
+	// At each algorithm's default frame size, whose bins may not resolve
+	// the harmonics, preserving the formants must keep about the level of
+	// the plain shift rather than amplify what the envelope misses.
+	tone := harmonicTone(150)
+	for _, algo := range []string{"phasvoc", "llstft", "stn", "sss", "plvoc"} {
+		level := func(extra ...string) float64 {
+			return rms(renderTone(t, tone, append([]string{"--algo", algo, "--shift", "12"}, extra...)...)[8192:])
+		}
+		plain, kept := level(), level("--formants")
+		if kept > 2*plain || kept < plain/2 {
+			t.Errorf("%s: --formants output RMS %.3f, plain shift %.3f", algo, kept, plain)
+		}
+	}
+
+	// Formants alone: +5 semitones at unchanged pitch moves the resonance
+	// up by a factor of about 1.33.
+	for _, algo := range []string{"phasvoc", "llstft", "stn", "sss", "plvoc"} {
+		t.Run(algo+"/formantshift", func(t *testing.T) {
+			out := filepath.Join(dir, algo+"_fs.wav")
+			if err := runRender([]string{"--algo", algo, "--framesize", "2048", "--formantshift", "5", in, out}); err != nil {
+				t.Fatal(err)
+			}
+			shifted := readTestWAV(t, out)[0]
+			got := spectralCentroid(shifted[mid:mid+8192], testSampleRate)
+			t.Logf("centroid: input %.0f Hz, formant-shifted %.0f Hz", want, got)
+			if got < want*1.15 {
+				t.Errorf("--formantshift 5 moved the centroid to %.0f Hz, want above %.0f Hz", got, want*1.15)
+			}
+			if r, in := rms(shifted[8192:]), rms(src[8192:]); r > 2*in || r < in/4 {
+				t.Errorf("--formantshift 5: output RMS %.3f, input %.3f", r, in)
+			}
+		})
+	}
+
+	// Warping the envelope an octave must not boost the bands the formants
+	// move over by the depth of the valleys between them.
+	two := make([]float64, testSampleRate)
+	for i := range two {
+		two[i] = 0.2*math.Sin(2*math.Pi*220*float64(i)/testSampleRate) + 0.2*math.Sin(2*math.Pi*660*float64(i)/testSampleRate)
+	}
+	for _, algo := range []string{"phasvoc", "llstft", "stn", "sss", "plvoc"} {
+		for _, tc := range []struct {
+			tone []float64
+			args []string
+		}{
+			{tone, []string{"--shift", "12", "--formantshift", "-12", "--framesize", "512"}},
+			{tone, []string{"--formantshift", "12"}},
+			{two, []string{"--formantshift", "-12"}},
+		} {
+			got := renderTone(t, tc.tone, append([]string{"--algo", algo}, tc.args...)...)
+			if r, in := rms(got[8192:]), rms(tc.tone[8192:]); r > 2*in {
+				t.Errorf("%s %v: output RMS %.3f, input %.3f", algo, tc.args, r, in)
+			}
+		}
+	}
+}
+
+func TestRenderTDPSOLA(t *testing.T) {
+	const f0 = 160.0
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.wav")
+	writePlanesWAV(t, in, [][]float64{harmonicTone(f0)})
+
+	d := pitchdetect.NewDetector(testSampleRate, 2048)
+	for _, shift := range []int{-5, 0, 3, 7} {
+		out := filepath.Join(dir, "out.wav")
+		if err := runRender([]string{"--algo", "tdpsola", "--shift", strconv.Itoa(shift), in, out}); err != nil {
+			t.Fatal(err)
+		}
+		got := readTestWAV(t, out)[0]
+		want := f0 * math.Exp2(float64(shift)/12)
+		for _, at := range []int{12000, 24000, 36000} {
+			e := d.Detect(got[at:])
+			if math.Abs(e.F0-want)/want > 0.01 || !e.Voiced(0.8) {
+				t.Errorf("shift %+d at sample %d: detected %.1f Hz (confidence %.2f), want %.1f Hz", shift, at, e.F0, e.Confidence, want)
+			}
+		}
+	}
+}
+
+func TestRenderAutoTune(t *testing.T) {
+	// 227 Hz sits just above the midpoint between A3 (220 Hz) and A#3
+	// (233.1 Hz).
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.wav")
+	writePlanesWAV(t, in, [][]float64{harmonicTone(227)})
+
+	d := pitchdetect.NewDetector(testSampleRate, 2048)
+	for _, algo := range []string{"phasvoc", "stn", "tdpsola"} {
+		for _, tc := range []struct {
+			scale string
+			want  float64
+		}{
+			{"chromatic", 233.08},
+			{"major", 220},    // C major has no A#
+			{"C,E,G", 261.63}, // C4 is nearer than G3
+			{"minor", 233.08}, // with --key F, A# is in the scale
+		} {
+			out := filepath.Join(dir, "out.wav")
+			key := "C"
+			if tc.scale == "minor" {
+				key = "F"
+			}
+			args := []string{"--algo", algo, "--framesize", "2048", "--autotune", "--retune", "0", "--key", key, "--scale", tc.scale, in, out}
+			if err := runRender(args); err != nil {
+				t.Fatal(err)
+			}
+			got := readTestWAV(t, out)[0]
+			for _, at := range []int{18000, 30000} {
+				if e := d.Detect(got[at:]); math.Abs(e.F0-tc.want)/tc.want > 0.01 {
+					t.Errorf("%s, %s %s: detected %.1f Hz at sample %d, want %.1f Hz", algo, key, tc.scale, e.F0, at, tc.want)
+				}
+			}
+		}
+	}
+}
+
+func TestRenderHarmonizer(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.wav")
+	out := filepath.Join(dir, "out.wav")
+	d := pitchdetect.NewDetector(testSampleRate, 2048)
+	detect := func(x []float64, want float64, what string) {
+		t.Helper()
+		for _, at := range []int{18000, 30000} {
+			if e := d.Detect(x[at:]); math.Abs(e.F0-want)/want > 0.01 {
+				t.Errorf("%s: detected %.1f Hz at sample %d, want %.1f Hz", what, e.F0, at, want)
+			}
+		}
+	}
+
+	// A diatonic third above C4 is E4 in C major, and above D4 is F4.
+	for _, tc := range []struct{ from, want float64 }{{261.63, 329.63}, {293.66, 349.23}} {
+		writePlanesWAV(t, in, [][]float64{harmonicTone(tc.from)})
+		if err := runRender([]string{"--voice", "+2d:1:0:stn", "--dry", "0", "--key", "C", "--scale", "major", in, out}); err != nil {
+			t.Fatal(err)
+		}
+		detect(readTestWAV(t, out)[0], tc.want, "+2d from "+strconv.FormatFloat(tc.from, 'f', 1, 64)+" Hz")
+	}
+
+	// Fixed intervals panned hard left and right, each with its own
+	// algorithm.
+	tone := harmonicTone(220)
+	writePlanesWAV(t, in, [][]float64{tone, tone})
+	if err := runRender([]string{"--voice", "+7:1:-1:phasvoc", "--voice", "+12:1:1:stn", "--dry", "0", in, out}); err != nil {
+		t.Fatal(err)
+	}
+	got := readTestWAV(t, out)
+	detect(got[0], 220*math.Exp2(7.0/12), "+7 panned left")
+	detect(got[1], 440, "+12 panned right")
+
+	// A silent voice of a slower algorithm leaves the dry signal delayed
+	// by exactly the harmonizer latency, which render compensates.
+	if err := runRender([]string{"--voice", "0:0:0:sss", in, out}); err != nil {
+		t.Fatal(err)
+	}
+	got = readTestWAV(t, out)
+	for i := range tone {
+		if math.Abs(got[0][i]-tone[i]) > 1e-6 {
+			t.Fatalf("dry signal misaligned: sample %d is %v, want %v", i, got[0][i], tone[i])
+		}
+	}
+
+	// A voice without an algorithm runs the selected one with the
+	// window, zero padding and parameters given on the command line.
+	render := func(args ...string) []float64 {
+		t.Helper()
+		return renderTone(t, tone, append([]string{"--voice", "+12:1:0", "--dry", "0"}, args...)...)
+	}
+	base := render("--algo", "stn")
+	detect(base, 440, "+12 with --algo stn")
+	for _, args := range [][]string{
+		{"--algo", "phasvoc"},
+		{"--algo", "stn", "--window", "hamming"},
+		{"--algo", "stn", "--zeropad", "2"},
+		{"--algo", "stn", "--param", "lh=3"},
+		{"--algo", "stn", "--formantshift", "4"},
+	} {
+		if got := render(args...); slices.Equal(got, base) {
+			t.Errorf("%v: voice output unchanged", args)
+		}
+	}
+
+	// --mix 0 leaves only the dry signal, even with --dry 0.
+	dry := render("--algo", "stn", "--mix", "0")
+	for i := range tone {
+		if math.Abs(dry[i]-tone[i]) > 1e-6 {
+			t.Fatalf("--mix 0: sample %d is %v, want %v", i, dry[i], tone[i])
+		}
+	}
+
+	// A frame longer than the largest block still fits the voices'
+	// buffers, and blocks beyond it are refused rather than overrun them.
+	detect(render("--framesize", "16384", "--buffersize", strconv.Itoa(algos.MaxBlock)), 440, "+12 with a 16384 frame")
+	in, out = toneWAV(t, tone)
+	if err := runRender([]string{"--voice", "+4", "--framesize", "16384", "--buffersize", "16384", in, out}); err == nil {
+		t.Error("--buffersize 16384: want an error")
+	}
+}
+
+func TestSameVoices(t *testing.T) {
+	parse := func(specs ...string) []algos.Voice {
+		var vs []algos.Voice
+		for _, spec := range specs {
+			v, err := parseVoice(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vs = append(vs, v)
+		}
+		return vs
+	}
+	base := parse("+4:0.7:-0.5", "+2d:0.7:0.5:stn")
+	if !sameVoices(base, parse("+4:0.70:-0.5", "+2d:0.7:0.5:stn")) {
+		t.Error("equal voices reported as changed")
+	}
+	for _, specs := range [][]string{
+		{"+4:0.7:-0.5"},
+		{"+4:0.7:-0.5", "+2:0.7:0.5:stn"},
+		{"+4:0.7:-0.5", "+2d:0.7:0.5"},
+		{"+4:0.7:-0.5", "+2d:0.7:0.5:phasvoc"},
+		{"+4:0.7:-0.4", "+2d:0.7:0.5:stn"},
+	} {
+		if sameVoices(base, parse(specs...)) {
+			t.Errorf("%v reported unchanged", specs)
+		}
+	}
+}
+
+func TestRenderStretch(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.wav")
+	out := filepath.Join(dir, "out.wav")
+	tone := harmonicTone(220)
+	writePlanesWAV(t, in, [][]float64{tone})
+
+	d := pitchdetect.NewDetector(testSampleRate, 2048)
+	for _, algo := range []string{"phasvoc", "stn", "wsola"} {
+		for _, stretch := range []float64{0.5, 1.5, 2} {
+			args := []string{"--algo", algo, "--framesize", "2048", "--stretch", strconv.FormatFloat(stretch, 'f', -1, 64), in, out}
+			if err := runRender(args); err != nil {
+				t.Fatal(err)
+			}
+			got := readTestWAV(t, out)[0]
+			if want := stretch * float64(len(tone)); math.Abs(float64(len(got))-want) > 4 {
+				t.Errorf("%s, stretch %g: %d frames, want %.0f", algo, stretch, len(got), want)
+			}
+			for _, at := range []float64{0.3, 0.6} {
+				if e := d.Detect(got[int(at*float64(len(got))):]); math.Abs(e.F0-220)/220 > 0.01 {
+					t.Errorf("%s, stretch %g: detected %.1f Hz at %.0f%%, want 220 Hz", algo, stretch, e.F0, at*100)
+				}
+			}
+		}
+	}
+}
+
+func TestRenderMix(t *testing.T) {
+	tone := harmonicTone(220)
+	for _, a := range algos.Algorithms {
+		wet := renderTone(t, tone, "--algo", a.ShortName, "--shift", "5")
+		// The dry path is delayed by exactly the algorithm delay, which
+		// render compensates, so the blend is sample-aligned.
+		for _, mix := range []float64{0, 0.3} {
+			got := renderTone(t, tone, "--algo", a.ShortName, "--shift", "5", "--mix", strconv.FormatFloat(mix, 'f', -1, 64))
+			for i := range tone {
+				if want := mix*wet[i] + (1-mix)*tone[i]; math.Abs(got[i]-want) > 1e-5 {
+					t.Errorf("%s, mix %g: sample %d is %v, want %v", a.ShortName, mix, i, got[i], want)
+					break
+				}
+			}
+		}
+
+		// Unshifted, the wet signal must line up with the dry one: a
+		// delay mismatch comb-filters the blend, which loses correlation
+		// with the input. The frequency shifter has no pitch shift to
+		// leave out, and its allpass filters disperse the phase even at
+		// 0 Hz.
+		if a.ShortName == "freqshift" {
+			continue
+		}
+		got := renderTone(t, tone, "--algo", a.ShortName, "--mix", "0.5")
+		var dot, eIn, eOut float64
+		for i := 4096; i < len(tone)-4096; i++ {
+			dot += tone[i] * got[i]
+			eIn += tone[i] * tone[i]
+			eOut += got[i] * got[i]
+		}
+		if corr := dot / math.Sqrt(eIn*eOut); corr < 0.9 {
+			t.Errorf("%s, unshifted mix 0.5: correlation with input %.4f, want at least 0.9", a.ShortName, corr)
+		}
+	}
+}
+
+func TestRenderParams(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.wav")
+	writeTestWAV(t, in)
+
+	// Each parameter must reach the algorithm: its output changes.
+	for _, tc := range []struct{ algo, param string }{
+		{"stn", "lh=3"},
+		{"stn", "betaL=0.2"},
+		{"wsola", "delta=0"},
+		{"sss", "longStep=1"},
+	} {
+		def, set := filepath.Join(dir, tc.algo+".wav"), filepath.Join(dir, tc.algo+"-"+tc.param+".wav")
+		if err := runRender([]string{"--algo", tc.algo, "--shift", "3", in, def}); err != nil {
+			t.Fatal(err)
+		}
+		if err := runRender([]string{"--algo", tc.algo, "--shift", "3", "--param", tc.param, in, set}); err != nil {
+			t.Fatalf("%s --param %s: %v", tc.algo, tc.param, err)
+		}
+		a, b := readTestWAV(t, def), readTestWAV(t, set)
+		if slices.Equal(a[0], b[0]) {
+			t.Errorf("%s --param %s: output unchanged", tc.algo, tc.param)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--algo", "stn", "--param", "delta=4"},
+		{"--algo", "stn", "--param", "lh=2.5"},
+		{"--algo", "stn", "--param", "betaU=2"},
+		{"--algo", "phasvoc", "--param", "lh=9"},
+	} {
+		if err := runRender(append(args, in, filepath.Join(dir, "bad.wav"))); err == nil {
+			t.Errorf("%v: want an error", args)
+		}
+	}
+	for _, v := range []string{"lh", "=3", "lh=x"} {
+		if err := (paramList{}).Set(v); err == nil {
+			t.Errorf("--param %s: want an error", v)
+		}
+	}
+}
+
+// rms returns the root mean square of x.
+func rms(x []float64) float64 {
+	s := 0.0
+	for _, v := range x {
+		s += v * v
+	}
+	return math.Sqrt(s / float64(len(x)))
+}
+
+func TestRenderWindows(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.wav")
+	out := filepath.Join(dir, "out.wav")
+	writeTestWAV(t, in)
+	src := readTestWAV(t, in)[0]
+
+	// With no shift, llstft and sss pass the spectrum through unmodified, so
+	// the derived synthesis window must reconstruct the input, at the fixed
+	// STFT gain of 0.75, for every window and overlapping oversampling.
+	for _, algo := range []string{"llstft", "sss"} {
+		for _, os := range []string{"2", "4", "8"} {
+			for _, w := range algos.WindowNames {
+				if err := runRender([]string{"--algo", algo, "--oversampling", os, "--window", w, in, out}); err != nil {
+					t.Fatal(err)
+				}
+				got := readTestWAV(t, out)[0]
+				// Skip the fade-in of the first frame.
+				for i := 4096; i < len(got); i++ {
+					if want := 0.75 * src[i]; math.Abs(got[i]-want) > 1e-5 {
+						t.Errorf("%s, oversampling %s, %s window: sample %d is %v, want %v", algo, os, w, i, got[i], want)
+						break
+					}
+				}
+			}
+		}
+	}
+
+	// The grain algorithms are scaled by GrainGain instead.
+	for _, w := range algos.WindowNames {
+		if err := runRender([]string{"--algo", "psola", "--oversampling", "8", "--window", w, in, out}); err != nil {
+			t.Fatal(err)
+		}
+		got := readTestWAV(t, out)[0]
+		if r := rms(got[4096:]) / rms(src[4096:]); math.Abs(r-1) > 0.01 {
+			t.Errorf("psola, %s window: gain %.4f, want 1", w, r)
+		}
+	}
+
+	for _, spec := range []string{"kaiser:6", "asym:0.8", "hamming"} {
+		if w, err := algos.ParseWindow(spec); err != nil || w.String() != spec {
+			t.Errorf("ParseWindow(%q) = %v, %v", spec, w, err)
+		}
+	}
+	for _, spec := range []string{"triangle", "kaiser:x", "kaiser:-1", "asym:0.2"} {
+		if _, err := algos.ParseWindow(spec); err == nil {
+			t.Errorf("ParseWindow(%q): want an error", spec)
+		}
+	}
+}
+
+func TestRenderFrameSizes(t *testing.T) {
+	src := sineTone(440)
+
+	configs := [][]string{
+		{"--framesize", "480"},
+		{"--framesize", "960", "--zeropad", "2"},
+		{"--framesize", "1000", "--oversampling", "8", "--zeropad", "3"},
+	}
+
+	// The phase-vocoder frequency estimate must follow the bin spacing of
+	// the padded transform: a 440 Hz sine shifted up a fifth.
+	d := pitchdetect.NewDetector(testSampleRate, 2048)
+	want := 440 * math.Exp2(7.0/12)
+	for _, algo := range []string{"phasvoc", "stn"} {
+		for _, args := range configs {
+			got := renderTone(t, src, append([]string{"--algo", algo, "--shift", "7"}, args...)...)
+			for _, at := range []int{12000, 24000, 36000} {
+				if e := d.Detect(got[at:]); math.Abs(e.F0-want)/want > 0.01 {
+					t.Errorf("%s %v at sample %d: detected %.1f Hz, want %.1f Hz", algo, args, at, e.F0, want)
+				}
+			}
+		}
+	}
+
+	// Unshifted, llstft and sss mirror the padded spectrum and must still
+	// reconstruct the input at the STFT gain.
+	for _, algo := range []string{"llstft", "sss"} {
+		for _, args := range configs {
+			got := renderTone(t, src, append([]string{"--algo", algo}, args...)...)
+			for i := 4096; i < len(got); i++ {
+				if want := 0.75 * src[i]; math.Abs(got[i]-want) > 1e-5 {
+					t.Errorf("%s %v: sample %d is %v, want %v", algo, args, i, got[i], want)
+					break
+				}
+			}
+		}
+	}
+
+	in, out := toneWAV(t, src)
+	for _, args := range [][]string{
+		{"--framesize", "8"},
+		{"--framesize", "500", "--oversampling", "8"},
+		{"--zeropad", "0"},
+		{"--zeropad", "9"},
+	} {
+		if err := runRender(append(args, in, out)); err == nil {
+			t.Errorf("%v: want an error", args)
+		}
+	}
+}
+
+// tonePower returns the power of x at freq Hz, by the Goertzel algorithm.
+func tonePower(x []float64, freq, sampleRate float64) float64 {
+	k := 2 * math.Cos(2*math.Pi*freq/sampleRate)
+	var s1, s2 float64
+	for _, v := range x {
+		s1, s2 = v+k*s1-s2, s1
+	}
+	return (s1*s1 + s2*s2 - k*s1*s2) / float64(len(x)*len(x))
+}
+
+func TestRenderFrequencyShift(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.wav")
+	out := filepath.Join(dir, "out.wav")
+	writeTestWAV(t, in)
+
+	// A 440 Hz sine moves by exactly the offset, with the mirror sideband
+	// and the original suppressed.
+	for _, hz := range []float64{150, -300, 2000} {
+		if err := runRender([]string{"--algo", "freqshift", "--param", "hz=" + strconv.FormatFloat(hz, 'f', -1, 64), in, out}); err != nil {
+			t.Fatal(err)
+		}
+		got := readTestWAV(t, out)[0][4096:]
+		want := tonePower(got, 440+hz, testSampleRate)
+		for _, f := range []float64{440 - hz, 440} {
+			if p := tonePower(got, math.Abs(f), testSampleRate); p > want*1e-3 {
+				t.Errorf("shift %g Hz: %g Hz is %.1f dB below %g Hz, want at least 30 dB", hz, f, 10*math.Log10(want/p), 440+hz)
+			}
+		}
+		if r := rms(got); math.Abs(r-math.Sqrt(0.5)) > 0.01 {
+			t.Errorf("shift %g Hz: output RMS %.4f, want the input level", hz, r)
+		}
+	}
+
+	if err := runRender([]string{"--algo", "freqshift", "--param", "hz=6000", in, out}); err == nil {
+		t.Error("hz=6000: want an error")
+	}
+}
+
+func TestRenderDoppler(t *testing.T) {
+	doppler, _ := algos.Find("doppler")
+	s := newTestShifter(0)
+	s.SetAlgorithm(doppler)
+	if ms := float64(s.latency()) / testSampleRate * 1000; ms > 5 {
+		t.Errorf("latency %.1f ms, want at most 5 ms", ms)
+	}
+
+	// flatness is the ratio of the quietest to the loudest 10 ms block: a
+	// shifted sine keeps its level when the heads splice in phase.
+	flatness := func(x []float64) float64 {
+		lo, hi := math.Inf(1), 0.0
+		for i := 0; i+480 <= len(x); i += 480 {
+			r := rms(x[i : i+480])
+			lo, hi = min(lo, r), max(hi, r)
+		}
+		return lo / hi
+	}
+	render := func(shift float64, search string) []float64 {
+		t.Helper()
+		got := renderTone(t, sineTone(440), "--algo", "doppler", "--shift", strconv.FormatFloat(shift, 'f', -1, 64), "--param", "search="+search)
+		return got[4800 : len(got)-4800]
+	}
+
+	d := pitchdetect.NewDetector(testSampleRate, 2048)
+	for _, shift := range []float64{7, -5} {
+		want := 440 * math.Exp2(shift/12)
+		blind := flatness(render(shift, "0"))
+		for _, search := range []string{"2", "10"} {
+			got := render(shift, search)
+			for _, at := range []int{6000, 18000, 30000} {
+				if e := d.Detect(got[at:]); math.Abs(e.F0-want)/want > 0.01 {
+					t.Errorf("shift %g, search %s ms at sample %d: detected %.1f Hz, want %.1f Hz", shift, search, at, e.F0, want)
+				}
+			}
+			if f := flatness(got); f <= blind {
+				t.Errorf("shift %g, search %s ms: flatness %.3f, want above %.3f without the search", shift, search, f, blind)
+			}
+		}
+	}
+}
+
+func TestRenderGranular(t *testing.T) {
+	src := sineTone(440)
+
+	// Unjittered grains at a whole number of overlaps reconstruct the input
+	// with every envelope.
+	for _, env := range []string{"0", "1", "2"} {
+		got := renderTone(t, src, "--algo", "granular", "--param", "envelope="+env)
+		for i := 4096; i < len(got); i++ {
+			if math.Abs(got[i]-src[i]) > 1e-5 {
+				t.Errorf("envelope %s: sample %d is %v, want %v", env, i, got[i], src[i])
+				break
+			}
+		}
+	}
+
+	d := pitchdetect.NewDetector(testSampleRate, 2048)
+	want := 440 * math.Exp2(7.0/12)
+	got := renderTone(t, src, "--algo", "granular", "--shift", "7")
+	for _, at := range []int{12000, 24000, 36000} {
+		if e := d.Detect(got[at:]); math.Abs(e.F0-want)/want > 0.01 {
+			t.Errorf("shift 7 at sample %d: detected %.1f Hz, want %.1f Hz", at, e.F0, want)
+		}
+	}
+
+	// Jitter is reproducible for a seed and changes with it.
+	jitter := []string{"--algo", "granular", "--shift", "3", "--param", "posJitter=0.5", "--param", "pitchJitter=1"}
+	a := renderTone(t, src, append(jitter, "--param", "seed=1")...)
+	b := renderTone(t, src, append(jitter, "--param", "seed=1")...)
+	c := renderTone(t, src, append(jitter, "--param", "seed=2")...)
+	if !slices.Equal(a, b) {
+		t.Error("the same seed gave different output")
+	}
+	if slices.Equal(a, c) {
+		t.Error("different seeds gave the same output")
+	}
+	if r := rms(a[4096:]); r < 0.25 {
+		t.Errorf("jittered output RMS %.3f, want a non-silent signal", r)
+	}
+
+	in, out := toneWAV(t, src)
+	for _, p := range []string{"envelope=3", "density=0.5", "size=4096", "seed=1.5"} {
+		if err := runRender([]string{"--algo", "granular", "--param", p, in, out}); err == nil {
+			t.Errorf("--param %s: want an error", p)
+		}
+	}
+}
+
+func TestRenderPhaseLocked(t *testing.T) {
+	tone := harmonicTone(220)
+	d := pitchdetect.NewDetector(testSampleRate, 2048)
+	for _, shift := range []float64{5, -7} {
+		want := 220 * math.Exp2(shift/12)
+		got := renderTone(t, tone, "--algo", "plvoc", "--shift", strconv.FormatFloat(shift, 'f', -1, 64))
+		for _, at := range []int{12000, 24000, 36000} {
+			if e := d.Detect(got[at:]); math.Abs(e.F0-want)/want > 0.01 {
+				t.Errorf("shift %g at sample %d: detected %.1f Hz, want %.1f Hz", shift, at, e.F0, want)
+			}
+		}
+	}
+
+	// Clicks every 100 ms over a tone: the crest factor measures how
+	// sharply each attack survives the shift, which the phase reset at
+	// onsets must improve.
+	clicks := harmonicTone(110)
+	for i := 4800; i < len(clicks); i += 4800 {
+		clicks[i] += 0.9
+	}
+	crest := func(x []float64) float64 {
+		peak := 0.0
+		for _, v := range x {
+			peak = max(peak, math.Abs(v))
+		}
+		return peak / rms(x)
+	}
+	pv := crest(renderTone(t, clicks, "--algo", "phasvoc", "--framesize", "2048", "--shift", "3"))
+	locked := crest(renderTone(t, clicks, "--algo", "plvoc", "--shift", "3", "--param", "reset=0"))
+	reset := crest(renderTone(t, clicks, "--algo", "plvoc", "--shift", "3"))
+	t.Logf("crest factor: phasvoc %.2f, plvoc %.2f, plvoc with reset %.2f", pv, locked, reset)
+	if reset <= locked || reset <= pv {
+		t.Errorf("crest factor with onset reset %.2f, want above %.2f without and %.2f for phasvoc", reset, locked, pv)
+	}
+}
+
+func TestRenderSSS(t *testing.T) {
+	tone := harmonicTone(220)
+	render := func(args ...string) []float64 {
+		t.Helper()
+		return renderTone(t, tone, append([]string{"--algo", "sss"}, args...)...)
+	}
+
+	// Splitting the computation over the hop only delays it, which the
+	// renderer compensates.
+	for _, extra := range [][]string{nil, {"--param", "tonality=1000", "--param", "blend=1"}, {"--formants"}} {
+		args := append([]string{"--shift", "3"}, extra...)
+		want := render(args...)
+		got := render(append(args, "--param", "split=1")...)
+		if len(got) != len(want) {
+			t.Fatalf("%v: split gave %d samples, want %d", extra, len(got), len(want))
+		}
+		for i := range got {
+			if math.Abs(got[i]-want[i]) > 1e-9 {
+				t.Errorf("%v: split sample %d is %v, want %v", extra, i, got[i], want[i])
+				break
+			}
+		}
+	}
+
+	// Above the tonality limit partials move by a fixed offset. An octave
+	// up with a 1 kHz limit, F = 1000/sqrt(2) Hz: 880 Hz goes to 880 + F
+	// rather than 1760 Hz, and 1760 Hz to 1760 + F rather than 3520 Hz.
+	got := render("--shift", "12", "--param", "tonality=1000", "--param", "blend=1")[4096:]
+	for _, f := range []float64{880, 1760} {
+		shifted, scaled := tonePower(got, f+1000/math.Sqrt2, testSampleRate), tonePower(got, 2*f, testSampleRate)
+		if shifted < 4*scaled {
+			t.Errorf("%g Hz at %.1f dB over %g Hz, want at least 6 dB", f+1000/math.Sqrt2, 10*math.Log10(shifted/scaled), 2*f)
+		}
+	}
+
+	// The energy-weighted horizontal prediction keeps a harmonic tone's
+	// partials locked to their pitch.
+	d := pitchdetect.NewDetector(testSampleRate, 2048)
+	for _, shift := range []float64{5, -7} {
+		want := 220 * math.Exp2(shift/12)
+		got := render("--shift", strconv.FormatFloat(shift, 'f', -1, 64), "--param", "blend=1")
+		for _, at := range []int{12000, 24000, 36000} {
+			if e := d.Detect(got[at:]); math.Abs(e.F0-want)/want > 0.01 {
+				t.Errorf("blend 1, shift %g at sample %d: detected %.1f Hz, want %.1f Hz", shift, at, e.F0, want)
+			}
+		}
+	}
+
+	in, out := toneWAV(t, tone)
+	for _, p := range []string{"tonality=-1", "blend=3", "split=2"} {
+		if err := runRender([]string{"--algo", "sss", "--param", p, in, out}); err == nil {
+			t.Errorf("--param %s: want an error", p)
+		}
+	}
+}
@@ -1,71 +1,430 @@
-/****************************************************************************
-*
-* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
-*
-****************************************************************************/
-
-package main
-
-import (
-	"sync"
-
-	"github.com/intermernet/pitcher/algos"
-)
-
-// shifter wraps the DSP Context with audio device configuration.
-type shifter struct {
-	mu sync.RWMutex
-	*algos.Context
-	currentAlgo algos.Algorithm
-	periods     int
-	bufferSize  int
-	exclusive   bool
-}
-
-func newShifter(fftFrameSize, oversampling int, sampleRate float64, bitDepth uint16, channels, periods, bufferSize int, exclusive bool, algo algos.Algorithm) *shifter {
-	return &shifter{
-		Context:     algos.NewContext(float64(*shift), fftFrameSize, oversampling, sampleRate, bitDepth, channels, algo),
-		currentAlgo: algo,
-		periods:     periods,
-		bufferSize:  bufferSize,
-		exclusive:   exclusive,
-	}
-}
-
-// SetAlgorithm shadows the embedded method to keep currentAlgo in sync.
-// The write lock is held for the duration so that the audio callback cannot
-// observe a mismatched AlgoProcess/AlgoState pair mid-swap.
-func (s *shifter) SetAlgorithm(a algos.Algorithm) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.currentAlgo = a
-	s.Context.SetAlgorithm(a)
-}
-
-// ReinitContext builds a fresh DSP context with the given frame size and
-// oversampling factor. Ongoing audio callbacks are blocked for the duration
-// of the swap via the write lock.
-func (s *shifter) ReinitContext(fftFrameSize, oversampling int) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	pitchShift := s.PitchShift
-	volume := s.Volume
-	s.Context = algos.NewContext(pitchShift, fftFrameSize, oversampling, s.SampleRate, s.BitDepth, int(s.Channels), s.currentAlgo)
-	s.Volume = volume
-}
-
-// Destroy is a no-op retained for API compatibility; gofftw plans are
-// managed by the Go garbage collector and require no manual cleanup.
-func (s *shifter) Destroy() {}
-
-// process is the audio callback. It delegates to the active algorithm.
-func (s *shifter) process(pOutputSample, pInputSamples []byte, framecount uint32) {
-	s.mu.RLock()
-	s.AlgoProcess(s.Context, pOutputSample, pInputSamples)
-	s.mu.RUnlock()
-}
-
-// processAudio is the testable entry point for the active algorithm.
-func (s *shifter) processAudio(output, input []byte) {
-	s.AlgoProcess(s.Context, output, input)
-}
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package main
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/intermernet/pitcher/algos"
+	"github.com/intermernet/pitcher/pitchdetect"
+	"github.com/intermernet/pitcher/wavio"
+)
+
+// shifter wraps the DSP Context with audio device configuration. Control
+// values live in params and are copied into the Context by the audio
+// callback. Contexts, the harmonizer and the varispeed are built on control
+// goroutines and published atomically, and every buffer the callback uses is
+// allocated for blocks of up to algos.MaxBlock frames beforehand, so the
+// callback never waits for an allocation.
+type shifter struct {
+	mu          sync.Mutex // serialises swaps; never taken by the callback
+	currentAlgo algos.Algorithm
+	algoParams  map[string]map[string]float64 // SetParams values by algorithm short name
+	window      algos.WindowShape
+	zeroPad     int
+	voices      []algos.Voice // harmonizer voices; none outside harmony mode
+	ctx         atomic.Pointer[algos.Context]
+	harmony     atomic.Pointer[algos.Harmonizer] // replaces the single Context when set, built from it
+	varispeed   atomic.Pointer[algos.Varispeed]  // runs ahead of the algorithm while time stretching
+	params      *params
+	sampleRate  float64
+	format      wavio.SampleFormat
+	layout      channelLayout
+	router      *router
+	periods     int
+	bufferSize  int
+	exclusive   bool
+
+	// Owned by the audio callback.
+	cur, prev  engine // running engine and the one fading out, if any
+	warmLeft   int    // samples until cur has settled
+	fade       int    // crossfade length in samples
+	fadeLeft   int    // samples of crossfade remaining
+	faded      []byte // output of prev, algos.MaxBlock frames
+	stretching bool   // whether the varispeed ran in the previous block
+	stretched  []byte // varispeed output, algos.MaxBlock frames
+}
+
+// engine is what the audio callback runs: the single Context or, in harmony
+// mode, the Harmonizer.
+type engine struct {
+	ctx     *algos.Context
+	harmony *algos.Harmonizer
+}
+
+// warmup returns how long a swap to e waits for it to fill its delay and
+// run a further frame, and how long it then crossfades.
+func (e engine) warmup() (warm, fade int) {
+	if e.harmony != nil {
+		return e.harmony.Latency() + e.harmony.FrameSize(), e.harmony.FrameSize()
+	}
+	return e.ctx.Delay() + e.ctx.FFTFrameSize, e.ctx.FFTFrameSize
+}
+
+func newShifter(fftFrameSize, oversampling int, sampleRate float64, format wavio.SampleFormat, layout channelLayout, periods, bufferSize int, exclusive bool, algo algos.Algorithm) *shifter {
+	s := &shifter{
+		currentAlgo: algo,
+		algoParams:  make(map[string]map[string]float64),
+		window:      algos.DefaultWindow,
+		zeroPad:     1,
+		params:      newParams(float64(*shift)),
+		sampleRate:  sampleRate,
+		format:      format,
+		layout:      layout,
+		router:      newRouter(layout, format),
+		periods:     periods,
+		bufferSize:  bufferSize,
+		exclusive:   exclusive,
+		faded:       make([]byte, algos.MaxBlock*layout.playback*format.Size()),
+		stretched:   make([]byte, algos.MaxBlock*layout.process*format.Size()),
+	}
+	c := algos.NewContext(float64(*shift), fftFrameSize, oversampling, sampleRate, format, layout.process, algo)
+	s.cur = engine{ctx: c}
+	s.ctx.Store(c)
+	s.varispeed.Store(algos.NewVarispeed(format, layout.process, int(sampleRate), sampleRate)) // one-second buffer
+	return s
+}
+
+// context returns the most recently published Context.
+func (s *shifter) context() *algos.Context {
+	return s.ctx.Load()
+}
+
+// algorithm returns the algorithm of the most recently published Context.
+func (s *shifter) algorithm() algos.Algorithm {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.currentAlgo
+}
+
+// SetAlgorithm builds a Context running a on the calling goroutine and
+// publishes it; the callback warms it up and crossfades to it.
+func (s *shifter) SetAlgorithm(a algos.Algorithm) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.context()
+	s.currentAlgo = a
+	s.publish(s.newContext(c.FFTFrameSize, c.Oversampling, a))
+}
+
+// ReinitContext builds a Context with the given frame size and oversampling
+// factor on the calling goroutine and publishes it; the callback warms it up
+// and crossfades to it.
+func (s *shifter) ReinitContext(fftFrameSize, oversampling int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publish(s.newContext(fftFrameSize, oversampling, s.currentAlgo))
+}
+
+// SetParams sets parameters of the current algorithm by name, builds a
+// Context with them on the calling goroutine and publishes it. The values
+// are kept for when the algorithm is selected again.
+func (s *shifter) SetParams(values map[string]float64) error {
+	if len(values) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.context()
+	next := s.newContext(c.FFTFrameSize, c.Oversampling, s.currentAlgo)
+	if err := next.SetParams(values); err != nil {
+		return err
+	}
+	stored := s.algoParams[s.currentAlgo.ShortName]
+	if stored == nil {
+		stored = make(map[string]float64, len(values))
+		s.algoParams[s.currentAlgo.ShortName] = stored
+	}
+	maps.Copy(stored, values)
+	s.publish(next)
+	return nil
+}
+
+// SetWindow switches the analysis window of every algorithm, builds a
+// Context with it on the calling goroutine and publishes it.
+func (s *shifter) SetWindow(w algos.WindowShape) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.context()
+	next := s.newContext(c.FFTFrameSize, c.Oversampling, s.currentAlgo)
+	if err := next.SetWindow(w); err != nil {
+		return err
+	}
+	s.window = w
+	s.publish(next)
+	return nil
+}
+
+// SetZeroPad sets the zero-padding factor of every algorithm, builds a
+// Context with it on the calling goroutine and publishes it.
+func (s *shifter) SetZeroPad(pad int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.context()
+	next := s.newContext(c.FFTFrameSize, c.Oversampling, s.currentAlgo)
+	if err := next.SetZeroPad(pad); err != nil {
+		return err
+	}
+	s.zeroPad = pad
+	s.publish(next)
+	return nil
+}
+
+// publish publishes c and, in harmony mode, a Harmonizer built from it, so
+// the voices follow the algorithm, frame size, window, zero padding and
+// parameters. s.mu must be held.
+func (s *shifter) publish(c *algos.Context) {
+	s.ctx.Store(c)
+	if len(s.voices) > 0 {
+		s.harmony.Store(algos.NewHarmonizer(s.voices, c, s.layout.playback))
+	}
+}
+
+// newContext builds a Context running algo with the current window and zero
+// padding and its stored parameters. Values that no longer fit the frame size or
+// oversampling, such as a search radius longer than the hop, are forgotten.
+func (s *shifter) newContext(fftFrameSize, oversampling int, algo algos.Algorithm) *algos.Context {
+	c := algos.NewContext(s.params.pitchShift.Load(), fftFrameSize, oversampling, s.sampleRate, s.format, s.layout.process, algo)
+	if s.window != algos.DefaultWindow {
+		// Already validated by SetWindow.
+		_ = c.SetWindow(s.window)
+	}
+	if s.zeroPad != 1 {
+		_ = c.SetZeroPad(s.zeroPad)
+	}
+	if values := s.algoParams[algo.ShortName]; len(values) > 0 && c.SetParams(values) != nil {
+		delete(s.algoParams, algo.ShortName)
+	}
+	return c
+}
+
+// SetPitchShift sets the pitch shift in semitones.
+func (s *shifter) SetPitchShift(semitones float64) { s.params.pitchShift.Store(semitones) }
+
+// SetFormantShift sets the formant shift in semitones.
+func (s *shifter) SetFormantShift(semitones float64) { s.params.formantShift.Store(semitones) }
+
+// SetVolume sets the output volume.
+func (s *shifter) SetVolume(v float64) { s.params.volume.Store(v) }
+
+// SetMix sets the wet fraction of the output.
+func (s *shifter) SetMix(mix float64) { s.params.mix.Store(mix) }
+
+// SetRamp sets the smoothing time constant of pitch and volume changes in
+// milliseconds.
+func (s *shifter) SetRamp(ms float64) { s.params.ramp.Store(ms) }
+
+// SetFormantPreserve enables or disables formant preservation.
+func (s *shifter) SetFormantPreserve(on bool) { s.params.formants.Store(on) }
+
+// SetPitchTracking enables or disables pitch detection for DetectedPitch.
+func (s *shifter) SetPitchTracking(on bool) { s.params.tracking.Store(on) }
+
+// SetDither enables or disables TPDF dither.
+func (s *shifter) SetDither(on bool) { s.params.dither.Store(on) }
+
+// SetHarmonyDry sets the harmonizer dry signal gain.
+func (s *shifter) SetHarmonyDry(gain float64) { s.params.harmonyDry.Store(gain) }
+
+// SetTune enables or disables auto-tune and replaces its settings.
+func (s *shifter) SetTune(on bool, t algos.TuneSettings) {
+	s.params.tune.Store(&tuneParams{on: on, settings: t})
+}
+
+// tune returns the auto-tune switch and settings.
+func (s *shifter) tune() (bool, algos.TuneSettings) {
+	t := s.params.tune.Load()
+	return t.on, t.settings
+}
+
+// SetStretch sets the live time-stretch ratio. Away from 1 the varispeed
+// plays the input back at 1/stretch speed, jumping back or ahead when its
+// buffer runs out, and the algorithm restores the pitch. Harmony mode does
+// not stretch.
+func (s *shifter) SetStretch(stretch float64) { s.params.stretch.Store(stretch) }
+
+// SetStretchBuffer replaces the varispeed with one buffering at most span
+// samples of input.
+func (s *shifter) SetStretchBuffer(span int) {
+	s.varispeed.Store(algos.NewVarispeed(s.format, s.layout.process, span, s.sampleRate))
+}
+
+// SetHarmony switches to harmonizer mode with the given voices, or back to
+// the single shifted signal when voices is empty. The voices take the
+// settings of the current Context, and the callback crossfades to the new
+// mode as it does between Contexts. Diatonic voices use the current
+// auto-tune scale.
+func (s *shifter) SetHarmony(voices []algos.Voice) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.voices = slices.Clone(voices)
+	if len(voices) == 0 {
+		s.harmony.Store(nil)
+		return
+	}
+	s.publish(s.context())
+}
+
+// harmonyVoices returns the voices of harmony mode, or none.
+func (s *shifter) harmonyVoices() []algos.Voice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.voices)
+}
+
+// latency returns the processing latency in samples of the active mode.
+func (s *shifter) latency() int {
+	if h := s.harmony.Load(); h != nil {
+		return h.Latency()
+	}
+	return s.context().Delay()
+}
+
+// DetectedPitch returns the latest F0 estimate of the first processed
+// channel. PitchTracking must be enabled for it to update.
+func (s *shifter) DetectedPitch() pitchdetect.Estimate {
+	return s.context().Pitch(0)
+}
+
+// Destroy is a no-op retained for API compatibility; gofftw plans are
+// managed by the Go garbage collector and require no manual cleanup.
+func (s *shifter) Destroy() {}
+
+// process is the audio callback. It delegates to the active algorithm.
+func (s *shifter) process(pOutputSample, pInputSamples []byte, framecount uint32) {
+	s.processAudio(pOutputSample, pInputSamples)
+}
+
+// processAudio is the testable entry point for the active algorithm. Input
+// is routed from the capture to the processing channel count of the layout,
+// and the engines write output in the playback channel count. While a newly
+// published engine fades in, the previous one keeps running on the same
+// input.
+func (s *shifter) processAudio(output, input []byte) {
+	if next := s.published(); next != s.cur && (s.prev == (engine{}) || s.warmLeft > 0) {
+		// Keep the running engine audible until the new one has filled
+		// its delay and run a further frame on the same input, so its
+		// output no longer holds the cold start, then crossfade over one
+		// frame. An engine published while another is still warming up
+		// replaces it unheard, with prev still playing; one published
+		// during the crossfade waits for it to finish. Publishing the
+		// engine still being heard, as turning harmony off straight after
+		// turning it on does, cancels the swap.
+		if next == s.prev {
+			s.cur, s.prev = next, engine{}
+			s.warmLeft, s.fadeLeft = 0, 0
+		} else {
+			if s.prev == (engine{}) {
+				s.prev = s.cur
+			}
+			s.cur = next
+			s.warmLeft, s.fade = next.warmup()
+			s.fadeLeft = s.fade
+		}
+	}
+
+	input = s.router.in(input)
+	stretch := s.params.stretch.Load()
+	stretching := stretch != 1 && s.cur.harmony == nil
+	if stretching {
+		v := s.varispeed.Load()
+		if !s.stretching {
+			v.Reset()
+		}
+		v.Speed = 1 / stretch
+		v.Process(s.stretched[:len(input)], input)
+		input = s.stretched[:len(input)]
+	}
+	s.stretching = stretching
+
+	s.run(s.cur, output, input)
+	if s.prev != (engine{}) {
+		faded := s.faded[:len(output)]
+		s.run(s.prev, faded, input)
+		s.crossfade(output, faded)
+		if s.fadeLeft == 0 {
+			s.prev = engine{}
+		}
+	}
+}
+
+// published returns the engine most recently published by the control
+// goroutines.
+func (s *shifter) published() engine {
+	if h := s.harmony.Load(); h != nil {
+		return engine{harmony: h}
+	}
+	return engine{ctx: s.context()}
+}
+
+// run runs e on a block of input in the processing layout and writes output
+// in the playback layout.
+func (s *shifter) run(e engine, output, input []byte) {
+	if e.harmony != nil {
+		s.applyHarmony(e.harmony)
+		e.harmony.Process(output, input)
+		return
+	}
+	s.apply(e.ctx)
+	s.router.run(e.ctx.Process, output, input)
+}
+
+// apply loads a snapshot of the parameter block into c.
+func (s *shifter) apply(c *algos.Context) {
+	p := s.params
+	tune := p.tune.Load()
+	c.PitchShift = p.pitchShift.Load()
+	c.FormantShift = p.formantShift.Load()
+	c.Volume = p.volume.Load()
+	c.Mix = p.mix.Load()
+	c.Ramp = p.ramp.Load()
+	c.TimeStretch = p.stretch.Load()
+	c.FormantPreserve = p.formants.Load()
+	c.PitchTracking = p.tracking.Load()
+	c.Dither = p.dither.Load()
+	c.AutoTune, c.Tune = tune.on, tune.settings
+}
+
+// applyHarmony loads a snapshot of the parameter block into h, whose voices
+// share the controls.
+func (s *shifter) applyHarmony(h *algos.Harmonizer) {
+	p := s.params
+	tune := p.tune.Load()
+	h.Dry = p.harmonyDry.Load()
+	h.Transpose = p.pitchShift.Load()
+	h.FormantShift = p.formantShift.Load()
+	h.Mix = p.mix.Load()
+	h.Volume = p.volume.Load()
+	h.Ramp = p.ramp.Load()
+	h.Dither = p.dither.Load()
+	h.FormantPreserve = p.formants.Load()
+	h.AutoTune, h.Tune = tune.on, tune.settings
+}
+
+// crossfade replaces output with faded while the new engine warms up and
+// then mixes the two with an equal-power fade over the rest of the swap.
+func (s *shifter) crossfade(output, faded []byte) {
+	size := s.format.Size()
+	frame := size * s.layout.playback
+	for off := 0; off+frame <= len(output) && s.fadeLeft > 0; off += frame {
+		gOld, gNew := 1.0, 0.0
+		if s.warmLeft > 0 {
+			s.warmLeft--
+		} else {
+			theta := 0.5 * math.Pi * float64(s.fade-s.fadeLeft+1) / float64(s.fade+1)
+			gOld, gNew = math.Cos(theta), math.Sin(theta)
+			s.fadeLeft--
+		}
+		for o := off; o < off+frame; o += size {
+			s.format.Encode(output[o:], gNew*s.format.Decode(output[o:])+gOld*s.format.Decode(faded[o:]))
+		}
+	}
+}
@@ -1,299 +1,791 @@
-package main
-
-import (
-	"encoding/binary"
-	"fmt"
-	"math"
-	"testing"
-	"time"
-
-	"github.com/intermernet/pitcher/algos"
-)
-
-const (
-	testFFTFrameSize = 512
-	testOversampling = 32
-	testSampleRate   = 48000.0
-	testBitDepth     = 32
-	testChannels     = 2
-)
-
-// initShift ensures the global shift pointer is set for newShifter.
-func initShift(semitones int) {
-	shift = &semitones
-}
-
-// generateSineFrame generates a stereo float32 PCM frame containing a sine wave.
-func generateSineFrame(freq float64, numSamples int, sampleRate float64, phase float64) ([]byte, float64) {
-	bytesPerSample := 4 // float32
-	buf := make([]byte, numSamples*testChannels*bytesPerSample)
-	p := phase
-	step := 2 * math.Pi * freq / sampleRate
-	for i := 0; i < numSamples; i++ {
-		sample := float32(math.Sin(p))
-		bits := math.Float32bits(sample)
-		offset := i * testChannels * bytesPerSample
-		// Write same sample to both channels
-		binary.LittleEndian.PutUint32(buf[offset:], bits)
-		binary.LittleEndian.PutUint32(buf[offset+bytesPerSample:], bits)
-		p += step
-	}
-	return buf, p
-}
-
-// newTestShifter creates a shifter wired for testing (no audio hardware).
-func newTestShifter(semitones int) *shifter {
-	initShift(semitones)
-	return newShifter(testFFTFrameSize, testOversampling, testSampleRate, testBitDepth, testChannels, 2, testFFTFrameSize/4, false, algos.Default())
-}
-
-func TestShiftPassthrough(t *testing.T) {
-	s := newTestShifter(0) // 0 semitones = passthrough
-
-	samplesPerFrame := testFFTFrameSize
-
-	// Feed several frames of 440 Hz sine to fill the pipeline
-	numFrames := 10
-	phase := 0.0
-	var allOutput []byte
-	for i := 0; i < numFrames; i++ {
-		input, p := generateSineFrame(440, samplesPerFrame, testSampleRate, phase)
-		phase = p
-		output := make([]byte, len(input))
-		s.processAudio(output, input)
-		allOutput = append(allOutput, output...)
-	}
-
-	if len(allOutput) == 0 {
-		t.Fatal("expected output data, got none")
-	}
-
-	// Verify output is non-silent: at least some samples should be non-zero
-	bytesPerSample := int(s.BitDepth / 8)
-	nonZero := 0
-	totalSamples := len(allOutput) / bytesPerSample
-	for i := 0; i+bytesPerSample <= len(allOutput); i += bytesPerSample {
-		v := math.Float32frombits(binary.LittleEndian.Uint32(allOutput[i : i+bytesPerSample]))
-		if v != 0 {
-			nonZero++
-		}
-	}
-
-	if nonZero == 0 {
-		t.Fatal("output is all zeros — processing did not produce audio")
-	}
-	t.Logf("output: %d/%d samples non-zero (%.1f%%)", nonZero, totalSamples, 100*float64(nonZero)/float64(totalSamples))
-
-	// Verify output values are within a sane range (no NaN, no huge values)
-	for i := 0; i+bytesPerSample <= len(allOutput); i += bytesPerSample {
-		v := math.Float32frombits(binary.LittleEndian.Uint32(allOutput[i : i+bytesPerSample]))
-		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
-			t.Fatalf("output contains NaN or Inf at byte offset %d", i)
-		}
-		if math.Abs(float64(v)) > 10.0 {
-			t.Fatalf("output sample %.6f at byte offset %d exceeds sane range", v, i)
-		}
-	}
-}
-
-func TestShiftPitchUp(t *testing.T) {
-	s := newTestShifter(12) // +12 semitones = one octave up
-
-	samplesPerFrame := testFFTFrameSize
-
-	phase := 0.0
-	var allOutput []byte
-	for i := 0; i < 10; i++ {
-		input, p := generateSineFrame(440, samplesPerFrame, testSampleRate, phase)
-		phase = p
-		output := make([]byte, len(input))
-		s.processAudio(output, input)
-		allOutput = append(allOutput, output...)
-	}
-
-	if len(allOutput) == 0 {
-		t.Fatal("expected output data, got none")
-	}
-
-	// Basic sanity: output should exist and contain valid floats
-	bytesPerSample := int(s.BitDepth / 8)
-	for i := 0; i+bytesPerSample <= len(allOutput); i += bytesPerSample {
-		v := math.Float32frombits(binary.LittleEndian.Uint32(allOutput[i : i+bytesPerSample]))
-		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
-			t.Fatalf("output contains NaN or Inf at byte offset %d", i)
-		}
-	}
-	t.Logf("pitch-up produced %d bytes of output", len(allOutput))
-}
-
-// generateSineSweep generates a 2-second stereo sine sweep from startFreq to endFreq
-// in float32 PCM format, split into frames of bytesPerFrame bytes each.
-func generateSineSweep(startFreq, endFreq, sampleRate float64, durationSec float64, channels int) []byte {
-	totalSamples := int(sampleRate * durationSec)
-	bytesPerSample := 4 // float32
-	buf := make([]byte, totalSamples*channels*bytesPerSample)
-	phase := 0.0
-	for i := 0; i < totalSamples; i++ {
-		t := float64(i) / float64(totalSamples)
-		freq := startFreq * math.Pow(endFreq/startFreq, t) // exponential sweep
-		sample := float32(0.8 * math.Sin(phase))
-		phase += 2 * math.Pi * freq / sampleRate
-		bits := math.Float32bits(sample)
-		offset := i * channels * bytesPerSample
-		for ch := 0; ch < channels; ch++ {
-			binary.LittleEndian.PutUint32(buf[offset+ch*bytesPerSample:], bits)
-		}
-	}
-	return buf
-}
-
-// readSamplesF32 decodes interleaved float32 PCM bytes into per-channel sample slices.
-func readSamplesF32(data []byte, channels int) [][]float32 {
-	bytesPerSample := 4
-	totalSamples := len(data) / (bytesPerSample * channels)
-	out := make([][]float32, channels)
-	for ch := range out {
-		out[ch] = make([]float32, totalSamples)
-	}
-	for i := 0; i < totalSamples; i++ {
-		for ch := 0; ch < channels; ch++ {
-			off := (i*channels + ch) * bytesPerSample
-			out[ch][i] = math.Float32frombits(binary.LittleEndian.Uint32(data[off : off+bytesPerSample]))
-		}
-	}
-	return out
-}
-
-func TestSineSweepGlitchDetection(t *testing.T) {
-	for _, semitones := range []int{0, 3, 7, 12, -12} {
-		t.Run(fmt.Sprintf("shift_%+d", semitones), func(t *testing.T) {
-			s := newTestShifter(semitones)
-
-			// Generate 2-second sine sweep 100 Hz → 8000 Hz
-			sweep := generateSineSweep(100, 8000, testSampleRate, 2.0, testChannels)
-
-			// Process in fftFrameSize-sized chunks
-			chunkSize := testFFTFrameSize * testChannels * (testBitDepth / 8)
-			output := make([]byte, len(sweep))
-			for off := 0; off+chunkSize <= len(sweep); off += chunkSize {
-				s.processAudio(output[off:off+chunkSize], sweep[off:off+chunkSize])
-			}
-
-			channels := readSamplesF32(output, testChannels)
-
-			for ch := 0; ch < testChannels; ch++ {
-				samples := channels[ch]
-				if len(samples) < 2 {
-					t.Fatalf("channel %d: too few output samples (%d)", ch, len(samples))
-				}
-
-				// Skip the initial latency region (first fftFrameSize samples may be zero/ramping)
-				skip := testFFTFrameSize
-				if skip >= len(samples) {
-					skip = 0
-				}
-
-				// 1. Check for NaN / Inf
-				for i, v := range samples {
-					if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
-						t.Fatalf("ch%d sample %d: NaN or Inf", ch, i)
-					}
-				}
-
-				// 2. Detect discontinuities: large sample-to-sample jumps
-				// With a smoothly changing signal, adjacent samples shouldn't jump
-				// by more than a threshold. Higher pitch ratios produce steeper
-				// waveforms, so scale the threshold accordingly.
-				maxJump := float64(0)
-				jumpCount := 0
-				ratio := math.Exp2(math.Abs(float64(semitones)) / 12.0)
-				threshold := 0.5 * ratio // scale for faster oscillation at higher pitch
-				for i := skip + 1; i < len(samples); i++ {
-					jump := math.Abs(float64(samples[i] - samples[i-1]))
-					if jump > maxJump {
-						maxJump = jump
-					}
-					if jump > threshold {
-						jumpCount++
-					}
-				}
-
-				analyzedSamples := len(samples) - skip - 1
-				jumpRate := float64(jumpCount) / float64(analyzedSamples)
-
-				t.Logf("ch%d: %d samples, maxJump=%.4f, jumps>%.1f: %d (%.2f%%)",
-					ch, len(samples), maxJump, threshold, jumpCount, jumpRate*100)
-
-				// More than 1% of samples with large jumps indicates glitching
-				if jumpRate > 0.01 {
-					t.Errorf("ch%d: excessive discontinuities: %d/%d (%.2f%%) samples exceed threshold %.1f",
-						ch, jumpCount, analyzedSamples, jumpRate*100, threshold)
-				}
-
-				// 3. Check output isn't silent (after warmup)
-				rms := float64(0)
-				for i := skip; i < len(samples); i++ {
-					rms += float64(samples[i]) * float64(samples[i])
-				}
-				rms = math.Sqrt(rms / float64(len(samples)-skip))
-				t.Logf("ch%d: RMS=%.6f", ch, rms)
-
-				if rms < 0.001 {
-					t.Errorf("ch%d: output appears silent (RMS=%.6f)", ch, rms)
-				}
-
-				// 4. Check amplitude is reasonable (not blown up)
-				maxAmp := float64(0)
-				for i := skip; i < len(samples); i++ {
-					a := math.Abs(float64(samples[i]))
-					if a > maxAmp {
-						maxAmp = a
-					}
-				}
-				t.Logf("ch%d: peak=%.4f", ch, maxAmp)
-				if maxAmp > 5.0 {
-					t.Errorf("ch%d: output amplitude too high (peak=%.4f)", ch, maxAmp)
-				}
-			}
-		})
-	}
-}
-
-// BenchmarkShift measures throughput and latency of the processAudio loop.
-func BenchmarkShift(b *testing.B) {
-	for _, frameSize := range []int{256, 512, 1024} {
-		for _, oversampling := range []int{4, 16, 32} {
-			name := fmt.Sprintf("fft%d_os%d", frameSize, oversampling)
-			b.Run(name, func(b *testing.B) {
-				initShift(0)
-				s := newShifter(frameSize, oversampling, testSampleRate, testBitDepth, testChannels, 2, frameSize/4, false, algos.Default())
-
-				samplesPerFrame := frameSize
-				bytesPerFrame := samplesPerFrame * testChannels * (testBitDepth / 8)
-				frame, _ := generateSineFrame(440, samplesPerFrame, testSampleRate, 0)
-				outBuf := make([]byte, bytesPerFrame)
-
-				// Warm up the pipeline
-				for i := 0; i < 3; i++ {
-					s.processAudio(outBuf, frame)
-				}
-
-				b.ResetTimer()
-				b.SetBytes(int64(bytesPerFrame))
-
-				totalSamples := 0
-				for i := 0; i < b.N; i++ {
-					s.processAudio(outBuf, frame)
-					totalSamples += samplesPerFrame
-				}
-
-				b.StopTimer()
-				elapsed := b.Elapsed()
-				samplesPerSec := float64(totalSamples) / elapsed.Seconds()
-				latencyPerFrame := elapsed / time.Duration(b.N)
-				b.ReportMetric(samplesPerSec, "samples/s")
-				b.ReportMetric(float64(latencyPerFrame.Microseconds()), "µs/frame")
-			})
-		}
-	}
-}
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/intermernet/pitcher/algos"
+	"github.com/intermernet/pitcher/pitchdetect"
+	"github.com/intermernet/pitcher/wavio"
+)
+
+const (
+	testFFTFrameSize = 512
+	testOversampling = 32
+	testSampleRate   = 48000.0
+	testBitDepth     = 32
+	testFormat       = wavio.Float32
+	testChannels     = 2
+)
+
+// initShift ensures the global shift pointer is set for newShifter.
+func initShift(semitones int) {
+	shift = &semitones
+}
+
+// generateSineFrame generates a stereo float32 PCM frame containing a sine wave.
+func generateSineFrame(freq float64, numSamples int, sampleRate float64, phase float64) ([]byte, float64) {
+	bytesPerSample := 4 // float32
+	buf := make([]byte, numSamples*testChannels*bytesPerSample)
+	p := phase
+	step := 2 * math.Pi * freq / sampleRate
+	for i := 0; i < numSamples; i++ {
+		sample := float32(math.Sin(p))
+		bits := math.Float32bits(sample)
+		offset := i * testChannels * bytesPerSample
+		// Write same sample to both channels
+		binary.LittleEndian.PutUint32(buf[offset:], bits)
+		binary.LittleEndian.PutUint32(buf[offset+bytesPerSample:], bits)
+		p += step
+	}
+	return buf, p
+}
+
+// newTestShifter creates a shifter wired for testing (no audio hardware).
+func newTestShifter(semitones int) *shifter {
+	initShift(semitones)
+	return newShifter(testFFTFrameSize, testOversampling, testSampleRate, testFormat, directLayout(testChannels), 2, testFFTFrameSize/4, false, algos.Default())
+}
+
+func TestShiftPassthrough(t *testing.T) {
+	s := newTestShifter(0) // 0 semitones = passthrough
+
+	samplesPerFrame := testFFTFrameSize
+
+	// Feed several frames of 440 Hz sine to fill the pipeline
+	numFrames := 10
+	phase := 0.0
+	var allOutput []byte
+	for i := 0; i < numFrames; i++ {
+		input, p := generateSineFrame(440, samplesPerFrame, testSampleRate, phase)
+		phase = p
+		output := make([]byte, len(input))
+		s.processAudio(output, input)
+		allOutput = append(allOutput, output...)
+	}
+
+	if len(allOutput) == 0 {
+		t.Fatal("expected output data, got none")
+	}
+
+	// Verify output is non-silent: at least some samples should be non-zero
+	bytesPerSample := int(s.context().BitDepth / 8)
+	nonZero := 0
+	totalSamples := len(allOutput) / bytesPerSample
+	for i := 0; i+bytesPerSample <= len(allOutput); i += bytesPerSample {
+		v := math.Float32frombits(binary.LittleEndian.Uint32(allOutput[i : i+bytesPerSample]))
+		if v != 0 {
+			nonZero++
+		}
+	}
+
+	if nonZero == 0 {
+		t.Fatal("output is all zeros — processing did not produce audio")
+	}
+	t.Logf("output: %d/%d samples non-zero (%.1f%%)", nonZero, totalSamples, 100*float64(nonZero)/float64(totalSamples))
+
+	// Verify output values are within a sane range (no NaN, no huge values)
+	for i := 0; i+bytesPerSample <= len(allOutput); i += bytesPerSample {
+		v := math.Float32frombits(binary.LittleEndian.Uint32(allOutput[i : i+bytesPerSample]))
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			t.Fatalf("output contains NaN or Inf at byte offset %d", i)
+		}
+		if math.Abs(float64(v)) > 10.0 {
+			t.Fatalf("output sample %.6f at byte offset %d exceeds sane range", v, i)
+		}
+	}
+}
+
+func TestShiftPitchUp(t *testing.T) {
+	s := newTestShifter(12) // +12 semitones = one octave up
+
+	samplesPerFrame := testFFTFrameSize
+
+	phase := 0.0
+	var allOutput []byte
+	for i := 0; i < 10; i++ {
+		input, p := generateSineFrame(440, samplesPerFrame, testSampleRate, phase)
+		phase = p
+		output := make([]byte, len(input))
+		s.processAudio(output, input)
+		allOutput = append(allOutput, output...)
+	}
+
+	if len(allOutput) == 0 {
+		t.Fatal("expected output data, got none")
+	}
+
+	// Basic sanity: output should exist and contain valid floats
+	bytesPerSample := int(s.context().BitDepth / 8)
+	for i := 0; i+bytesPerSample <= len(allOutput); i += bytesPerSample {
+		v := math.Float32frombits(binary.LittleEndian.Uint32(allOutput[i : i+bytesPerSample]))
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			t.Fatalf("output contains NaN or Inf at byte offset %d", i)
+		}
+	}
+	t.Logf("pitch-up produced %d bytes of output", len(allOutput))
+}
+
+// generateSineSweep generates a 2-second stereo sine sweep from startFreq to endFreq
+// in float32 PCM format, split into frames of bytesPerFrame bytes each.
+func generateSineSweep(startFreq, endFreq, sampleRate float64, durationSec float64, channels int) []byte {
+	totalSamples := int(sampleRate * durationSec)
+	bytesPerSample := 4 // float32
+	buf := make([]byte, totalSamples*channels*bytesPerSample)
+	phase := 0.0
+	for i := 0; i < totalSamples; i++ {
+		t := float64(i) / float64(totalSamples)
+		freq := startFreq * math.Pow(endFreq/startFreq, t) // exponential sweep
+		sample := float32(0.8 * math.Sin(phase))
+		phase += 2 * math.Pi * freq / sampleRate
+		bits := math.Float32bits(sample)
+		offset := i * channels * bytesPerSample
+		for ch := 0; ch < channels; ch++ {
+			binary.LittleEndian.PutUint32(buf[offset+ch*bytesPerSample:], bits)
+		}
+	}
+	return buf
+}
+
+// readSamplesF32 decodes interleaved float32 PCM bytes into per-channel sample slices.
+func readSamplesF32(data []byte, channels int) [][]float32 {
+	bytesPerSample := 4
+	totalSamples := len(data) / (bytesPerSample * channels)
+	out := make([][]float32, channels)
+	for ch := range out {
+		out[ch] = make([]float32, totalSamples)
+	}
+	for i := 0; i < totalSamples; i++ {
+		for ch := 0; ch < channels; ch++ {
+			off := (i*channels + ch) * bytesPerSample
+			out[ch][i] = math.Float32frombits(binary.LittleEndian.Uint32(data[off : off+bytesPerSample]))
+		}
+	}
+	return out
+}
+
+func TestSineSweepGlitchDetection(t *testing.T) {
+	for _, semitones := range []int{0, 3, 7, 12, -12} {
+		t.Run(fmt.Sprintf("shift_%+d", semitones), func(t *testing.T) {
+			s := newTestShifter(semitones)
+
+			// Generate 2-second sine sweep 100 Hz → 8000 Hz
+			sweep := generateSineSweep(100, 8000, testSampleRate, 2.0, testChannels)
+
+			// Process in fftFrameSize-sized chunks
+			chunkSize := testFFTFrameSize * testChannels * (testBitDepth / 8)
+			output := make([]byte, len(sweep))
+			for off := 0; off+chunkSize <= len(sweep); off += chunkSize {
+				s.processAudio(output[off:off+chunkSize], sweep[off:off+chunkSize])
+			}
+
+			channels := readSamplesF32(output, testChannels)
+
+			for ch := 0; ch < testChannels; ch++ {
+				samples := channels[ch]
+				if len(samples) < 2 {
+					t.Fatalf("channel %d: too few output samples (%d)", ch, len(samples))
+				}
+
+				// Skip the initial latency region (first fftFrameSize samples may be zero/ramping)
+				skip := testFFTFrameSize
+				if skip >= len(samples) {
+					skip = 0
+				}
+
+				// 1. Check for NaN / Inf
+				for i, v := range samples {
+					if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+						t.Fatalf("ch%d sample %d: NaN or Inf", ch, i)
+					}
+				}
+
+				// 2. Detect discontinuities: large sample-to-sample jumps
+				// With a smoothly changing signal, adjacent samples shouldn't jump
+				// by more than a threshold. Higher pitch ratios produce steeper
+				// waveforms, so scale the threshold accordingly.
+				maxJump := float64(0)
+				jumpCount := 0
+				ratio := math.Exp2(math.Abs(float64(semitones)) / 12.0)
+				threshold := 0.5 * ratio // scale for faster oscillation at higher pitch
+				for i := skip + 1; i < len(samples); i++ {
+					jump := math.Abs(float64(samples[i] - samples[i-1]))
+					if jump > maxJump {
+						maxJump = jump
+					}
+					if jump > threshold {
+						jumpCount++
+					}
+				}
+
+				analyzedSamples := len(samples) - skip - 1
+				jumpRate := float64(jumpCount) / float64(analyzedSamples)
+
+				t.Logf("ch%d: %d samples, maxJump=%.4f, jumps>%.1f: %d (%.2f%%)",
+					ch, len(samples), maxJump, threshold, jumpCount, jumpRate*100)
+
+				// More than 1% of samples with large jumps indicates glitching
+				if jumpRate > 0.01 {
+					t.Errorf("ch%d: excessive discontinuities: %d/%d (%.2f%%) samples exceed threshold %.1f",
+						ch, jumpCount, analyzedSamples, jumpRate*100, threshold)
+				}
+
+				// 3. Check output isn't silent (after warmup)
+				rms := float64(0)
+				for i := skip; i < len(samples); i++ {
+					rms += float64(samples[i]) * float64(samples[i])
+				}
+				rms = math.Sqrt(rms / float64(len(samples)-skip))
+				t.Logf("ch%d: RMS=%.6f", ch, rms)
+
+				if rms < 0.001 {
+					t.Errorf("ch%d: output appears silent (RMS=%.6f)", ch, rms)
+				}
+
+				// 4. Check amplitude is reasonable (not blown up)
+				maxAmp := float64(0)
+				for i := skip; i < len(samples); i++ {
+					a := math.Abs(float64(samples[i]))
+					if a > maxAmp {
+						maxAmp = a
+					}
+				}
+				t.Logf("ch%d: peak=%.4f", ch, maxAmp)
+				if maxAmp > 5.0 {
+					t.Errorf("ch%d: output amplitude too high (peak=%.4f)", ch, maxAmp)
+				}
+			}
+		})
+	}
+}
+
+func TestIntegerFormats(t *testing.T) {
+	// Render the same sine through F32 and each integer format; outputs
+	// should agree to within the integer format's quantisation noise.
+	const frames = 8 * testFFTFrameSize
+	planes := make([][]float64, testChannels)
+	for c := range planes {
+		planes[c] = make([]float64, frames)
+		for i := range planes[c] {
+			planes[c][i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/testSampleRate)
+		}
+	}
+	render := func(format wavio.SampleFormat, dither bool) [][]float64 {
+		initShift(3)
+		s := newShifter(testFFTFrameSize, 4, testSampleRate, format, directLayout(testChannels), 2, testFFTFrameSize, false, algos.Default())
+		s.SetDither(dither)
+		in := make([]byte, frames*testChannels*format.Size())
+		out := make([]byte, len(in))
+		wavio.Interleave(in, planes, format, frames)
+		s.processAudio(out, in)
+		got := make([][]float64, testChannels)
+		for c := range got {
+			got[c] = make([]float64, frames)
+		}
+		wavio.Deinterleave(got, out, format)
+		return got
+	}
+
+	ref := render(wavio.Float32, false)
+	for _, format := range []wavio.SampleFormat{wavio.PCM16, wavio.PCM24, wavio.PCM32} {
+		for _, dither := range []bool{false, true} {
+			got := render(format, dither)
+			maxErr := 0.0
+			for c := range got {
+				for i := range got[c] {
+					maxErr = math.Max(maxErr, math.Abs(got[c][i]-ref[c][i]))
+				}
+			}
+			// Input and output quantisation plus up to 1 LSB of dither,
+			// floored at the precision of the float32 reference.
+			limit := math.Max(4.0/float64(uint64(1)<<(format.Size()*8-1)), 1e-7)
+			t.Logf("%v dither=%v: max error %.3g (limit %.3g)", format, dither, maxErr, limit)
+			if maxErr > limit {
+				t.Errorf("%v dither=%v: max error %.3g vs float reference", format, dither, maxErr)
+			}
+		}
+	}
+}
+
+func TestRouting(t *testing.T) {
+	for _, route := range []string{"mono2stereo", "stereo2mono"} {
+		t.Run(route, func(t *testing.T) {
+			layout, err := parseLayout(route, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			initShift(0)
+			s := newShifter(testFFTFrameSize, testOversampling, testSampleRate, testFormat, layout, 2, testFFTFrameSize, false, algos.Default())
+			if c := s.context(); int(c.Channels) != layout.process {
+				t.Fatalf("context has %d channels, want %d", c.Channels, layout.process)
+			}
+
+			const frames = 8 * testFFTFrameSize
+			in := make([][]float64, layout.capture)
+			for c := range in {
+				in[c] = make([]float64, frames)
+				for i := range in[c] {
+					in[c][i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/testSampleRate)
+				}
+			}
+			inBytes := make([]byte, frames*layout.capture*testFormat.Size())
+			outBytes := make([]byte, frames*layout.playback*testFormat.Size())
+			wavio.Interleave(inBytes, in, testFormat, frames)
+			s.processAudio(outBytes, inBytes)
+
+			out := make([][]float64, layout.playback)
+			for c := range out {
+				out[c] = make([]float64, frames)
+			}
+			wavio.Deinterleave(out, outBytes, testFormat)
+			rms := 0.0
+			for i := frames / 2; i < frames; i++ {
+				rms += out[0][i] * out[0][i]
+				for c := 1; c < layout.playback; c++ {
+					if out[c][i] != out[0][i] {
+						t.Fatalf("playback channel %d differs from channel 0 at frame %d", c, i)
+					}
+				}
+			}
+			rms = math.Sqrt(rms / (frames / 2))
+			if rms < 0.1 {
+				t.Errorf("output RMS %.4f, want a non-silent signal", rms)
+			}
+		})
+	}
+}
+
+func TestPitchTracking(t *testing.T) {
+	for _, a := range algos.Algorithms {
+		s := newTestShifter(0)
+		s.SetAlgorithm(a)
+		s.SetPitchTracking(true)
+		phase := 0.0
+		for i := 0; i < 8; i++ {
+			input, p := generateSineFrame(330, testFFTFrameSize, testSampleRate, phase)
+			phase = p
+			s.processAudio(make([]byte, len(input)), input)
+		}
+		e := s.DetectedPitch()
+		if math.Abs(e.F0-330) > 2 || !e.Voiced(voicedConfidence) {
+			t.Errorf("%s: detected %s, want 330 Hz", a.ShortName, formatPitch(e))
+		}
+	}
+}
+
+func TestLiveStretch(t *testing.T) {
+	d := pitchdetect.NewDetector(testSampleRate, 2048)
+	for _, stretch := range []float64{0.5, 2} {
+		s := newTestShifter(0)
+		s.SetStretchBuffer(int(testSampleRate / 4))
+		s.SetStretch(stretch)
+		phase := 0.0
+		var out []float64
+		for i := 0; i < 400; i++ {
+			input, p := generateSineFrame(330, testFFTFrameSize/4, testSampleRate, phase)
+			phase = p
+			output := make([]byte, len(input))
+			s.processAudio(output, input)
+			for _, v := range readSamplesF32(output, testChannels)[0] {
+				out = append(out, float64(v))
+			}
+		}
+		// The varispeed changes the tempo; the algorithm restores the
+		// pitch. Most frames avoid a read-head jump.
+		good := 0
+		for at := 8192; at+2048 <= len(out); at += 2048 {
+			if e := d.Detect(out[at:]); math.Abs(e.F0-330) < 3 {
+				good++
+			}
+		}
+		if total := (len(out) - 8192) / 2048; good < total*3/4 {
+			t.Errorf("stretch %g: pitch 330 Hz in %d of %d frames", stretch, good, total)
+		}
+	}
+}
+
+func TestCallbackAllocs(t *testing.T) {
+	// The callback must not allocate, not even for the first block after a
+	// swap, while time stretching or when switching into harmony mode.
+	stn, _ := algos.Find("stn")
+	input, _ := generateSineFrame(220, testFFTFrameSize/4, testSampleRate, 0)
+	output := make([]byte, len(input))
+	for name, setup := range map[string]func(s *shifter){
+		"swap":    func(s *shifter) { s.SetAlgorithm(stn) },
+		"stretch": func(s *shifter) { s.SetStretch(1.5) },
+		"harmony": func(s *shifter) { s.SetHarmony([]algos.Voice{{Interval: 4, Gain: 1, Algo: stn}}) },
+	} {
+		s := newTestShifter(0)
+		s.processAudio(output, input)
+		setup(s)
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		s.processAudio(output, input)
+		runtime.ReadMemStats(&after)
+		if n := after.Mallocs - before.Mallocs; n != 0 {
+			t.Errorf("%s: %d allocations in the first block, want none", name, n)
+		}
+	}
+}
+
+func TestRamp(t *testing.T) {
+	// run feeds a 220 Hz sine, applies change after the pipeline has
+	// filled, and returns channel 0 of the output.
+	run := func(rampTime float64, change func(s *shifter)) []float64 {
+		s := newTestShifter(0)
+		s.SetRamp(rampTime)
+		phase := 0.0
+		var out []float64
+		for i := 0; i < 120; i++ {
+			if i == 40 {
+				change(s)
+			}
+			input, p := generateSineFrame(220, testFFTFrameSize/4, testSampleRate, phase)
+			phase = p
+			output := make([]byte, len(input))
+			s.processAudio(output, input)
+			for _, v := range readSamplesF32(output, testChannels)[0] {
+				out = append(out, float64(v))
+			}
+		}
+		return out
+	}
+	maxStep := func(x []float64) float64 {
+		m := 0.0
+		for i := 1; i < len(x); i++ {
+			m = math.Max(m, math.Abs(x[i]-x[i-1]))
+		}
+		return m
+	}
+
+	// A volume cut must not step the waveform.
+	mute := func(s *shifter) { s.SetVolume(0) }
+	settled := maxStep(run(algos.DefaultRamp, func(*shifter) {})[4096:])
+	if got := maxStep(run(algos.DefaultRamp, mute)[4096:]); got > settled*1.01 {
+		t.Errorf("ramped volume cut: max sample step %.4f, steady state %.4f", got, settled)
+	}
+	if got := maxStep(run(0, mute)[4096:]); got < settled*2 {
+		t.Errorf("unramped volume cut: max sample step %.4f, want a step", got)
+	}
+
+	// An octave jump glides: 30 ms after the change reaches the output,
+	// a 200 ms ramp has covered 1 − e^−0.15 of it.
+	octave := func(s *shifter) { s.SetPitchShift(12) }
+	d := pitchdetect.NewDetector(testSampleRate, 1024)
+	at := 40*testFFTFrameSize/4 + newTestShifter(0).context().Delay() + int(0.03*testSampleRate) - 512
+	want := 220 * math.Exp2(1-math.Exp(-0.15))
+	if e := d.Detect(run(200, octave)[at:]); math.Abs(e.F0-want) > 5 {
+		t.Errorf("ramped octave jump: %.1f Hz mid-ramp, want %.1f Hz", e.F0, want)
+	}
+	if e := d.Detect(run(0, octave)[at:]); math.Abs(e.F0-440) > 5 {
+		t.Errorf("unramped octave jump: %.1f Hz, want 440 Hz", e.F0)
+	}
+}
+
+// BenchmarkShift measures throughput and latency of the processAudio loop.
+func BenchmarkShift(b *testing.B) {
+	for _, frameSize := range []int{256, 512, 1024} {
+		for _, oversampling := range []int{4, 16, 32} {
+			name := fmt.Sprintf("fft%d_os%d", frameSize, oversampling)
+			b.Run(name, func(b *testing.B) {
+				initShift(0)
+				s := newShifter(frameSize, oversampling, testSampleRate, testFormat, directLayout(testChannels), 2, frameSize/4, false, algos.Default())
+
+				samplesPerFrame := frameSize
+				bytesPerFrame := samplesPerFrame * testChannels * (testBitDepth / 8)
+				frame, _ := generateSineFrame(440, samplesPerFrame, testSampleRate, 0)
+				outBuf := make([]byte, bytesPerFrame)
+
+				// Warm up the pipeline
+				for i := 0; i < 3; i++ {
+					s.processAudio(outBuf, frame)
+				}
+
+				b.ResetTimer()
+				b.SetBytes(int64(bytesPerFrame))
+
+				totalSamples := 0
+				for i := 0; i < b.N; i++ {
+					s.processAudio(outBuf, frame)
+					totalSamples += samplesPerFrame
+				}
+
+				b.StopTimer()
+				elapsed := b.Elapsed()
+				samplesPerSec := float64(totalSamples) / elapsed.Seconds()
+				latencyPerFrame := elapsed / time.Duration(b.N)
+				b.ReportMetric(samplesPerSec, "samples/s")
+				b.ReportMetric(float64(latencyPerFrame.Microseconds()), "µs/frame")
+			})
+		}
+	}
+}
+
+func TestContextSwap(t *testing.T) {
+	// run feeds a 220 Hz sine, applies changes in consecutive blocks after
+	// the pipeline has filled, and returns channel 0 of the output.
+	run := func(changes ...func(s *shifter)) []float64 {
+		s := newTestShifter(0)
+		phase := 0.0
+		var out []float64
+		for i := 0; i < 80; i++ {
+			if i >= 40 && i < 40+len(changes) {
+				changes[i-40](s)
+			}
+			input, p := generateSineFrame(220, testFFTFrameSize/4, testSampleRate, phase)
+			phase = p
+			output := make([]byte, len(input))
+			s.processAudio(output, input)
+			for _, v := range readSamplesF32(output, testChannels)[0] {
+				out = append(out, float64(v))
+			}
+		}
+		if s.prev != (engine{}) {
+			t.Error("swap still fading after 40 blocks")
+		}
+		if s.cur != s.published() {
+			t.Error("the last published engine is not running")
+		}
+		return out
+	}
+	maxStep := func(x []float64) float64 {
+		m := 0.0
+		for i := 1; i < len(x); i++ {
+			m = math.Max(m, math.Abs(x[i]-x[i-1]))
+		}
+		return m
+	}
+
+	// The old context plays until the new one has warmed up, so a swap
+	// must not step the waveform further than the √2 peak of the
+	// equal-power fade.
+	stn, _ := algos.Find("stn")
+	settled := maxStep(run(func(*shifter) {})[4096:])
+	frameSize := func(s *shifter) { s.ReinitContext(2*testFFTFrameSize, testOversampling) }
+	algorithm := func(s *shifter) { s.SetAlgorithm(stn) }
+	harmony := func(s *shifter) {
+		s.SetHarmonyDry(0)
+		s.SetHarmony([]algos.Voice{{Gain: 1}})
+	}
+	solo := func(s *shifter) { s.SetHarmony(nil) }
+	for name, changes := range map[string][]func(s *shifter){
+		"frame size": {frameSize},
+		"algorithm":  {algorithm},
+		// A swap published while the previous one warms up must not cut
+		// off the context still being heard.
+		"back-to-back": {algorithm, frameSize},
+		"repeated":     {algorithm, frameSize, algorithm, frameSize, algorithm},
+		// Harmony engines swap through the same crossfade, and their
+		// voices follow the running algorithm.
+		"harmony":           {harmony},
+		"harmony algorithm": {harmony, algorithm, frameSize},
+		"harmony off":       {harmony, solo},
+	} {
+		if got := maxStep(run(changes...)[4096:]); got > settled*2 {
+			t.Errorf("%s swap: max sample step %.4f, steady state %.4f", name, got, settled)
+		}
+	}
+}
+
+func TestSetAlgorithmClearsState(t *testing.T) {
+	// A switched Context must not play out the previous algorithm's
+	// overlap-add tail: its output stays silent until it has filled its
+	// delay.
+	wsola, _ := algos.Find("wsola")
+	ctx := algos.NewContext(0, testFFTFrameSize, 4, testSampleRate, testFormat, testChannels, algos.Default())
+	phase := 0.0
+	for i := 0; i < 8; i++ {
+		input, p := generateSineFrame(220, testFFTFrameSize, testSampleRate, phase)
+		phase = p
+		ctx.Process(make([]byte, len(input)), input)
+	}
+	ctx.SetAlgorithm(wsola)
+	input, _ := generateSineFrame(220, ctx.Delay()-1, testSampleRate, phase)
+	output := make([]byte, len(input))
+	ctx.Process(output, input)
+	for c, samples := range readSamplesF32(output, testChannels) {
+		for i, v := range samples {
+			if math.Abs(float64(v)) > 1e-6 {
+				t.Fatalf("ch%d sample %d = %g after the switch, want silence", c, i, v)
+			}
+		}
+	}
+}
+
+func TestContextReset(t *testing.T) {
+	// A reset Context must render exactly what a new one does, with no
+	// ramp, pitch track, auto-tune or algorithm state left over, and the
+	// reset itself must not allocate.
+	newCtx := func(a algos.Algorithm) *algos.Context {
+		ctx := algos.NewContext(0, testFFTFrameSize, 4, testSampleRate, testFormat, testChannels, a)
+		ctx.PitchTracking, ctx.AutoTune, ctx.Mix = true, true, 0.7
+		return ctx
+	}
+	render := func(ctx *algos.Context, freq float64) []byte {
+		var out []byte
+		phase := 0.0
+		for i := 0; i < 24; i++ {
+			input, p := generateSineFrame(freq, 100, testSampleRate, phase)
+			phase = p
+			output := make([]byte, len(input))
+			ctx.Process(output, input)
+			out = append(out, output...)
+		}
+		return out
+	}
+	for _, a := range algos.Algorithms {
+		used := newCtx(a)
+		used.PitchShift, used.Volume = -5, 0.2
+		render(used, 330)
+		used.PitchShift, used.Volume = 3, 0.5
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		used.Reset()
+		runtime.ReadMemStats(&after)
+		if n := after.Mallocs - before.Mallocs; n != 0 {
+			t.Errorf("%s: %d allocations in Reset, want none", a.ShortName, n)
+		}
+		if e := used.Pitch(0); e.F0 != 0 {
+			t.Errorf("%s: pitch %.1f Hz after Reset, want none", a.ShortName, e.F0)
+		}
+
+		fresh := newCtx(a)
+		fresh.PitchShift, fresh.Volume = 3, 0.5
+		if got, want := render(used, 220), render(fresh, 220); !bytes.Equal(got, want) {
+			t.Errorf("%s: reset Context renders differently from a new one", a.ShortName)
+		}
+	}
+}
+
+func TestShifterParams(t *testing.T) {
+	wsola, _ := algos.Find("wsola")
+	delta := func(s *shifter) float64 {
+		ctx := s.context()
+		return ctx.Param(ctx.Processor.Params()[0])
+	}
+	s := newTestShifter(0)
+	s.SetAlgorithm(wsola)
+	s.ReinitContext(testFFTFrameSize, 4)
+	if err := s.SetParams(map[string]float64{"delta": 64}); err != nil {
+		t.Fatal(err)
+	}
+	if got := delta(s); got != 64 {
+		t.Fatalf("delta = %g, want 64", got)
+	}
+	// The value is kept across algorithm switches…
+	s.SetAlgorithm(algos.Default())
+	s.SetAlgorithm(wsola)
+	if got := delta(s); got != 64 {
+		t.Errorf("delta after switching back = %g, want 64", got)
+	}
+	// …but not once the hop is shorter than the search radius.
+	s.ReinitContext(testFFTFrameSize, 16)
+	if got, want := delta(s), float64(testFFTFrameSize/16); got != want {
+		t.Errorf("delta after shortening the hop = %g, want the default %g", got, want)
+	}
+	if err := s.SetParams(map[string]float64{"lh": 9}); err == nil {
+		t.Error("setting an stn parameter on wsola: want an error")
+	}
+}
+
+func TestParamDefaults(t *testing.T) {
+	// Every default must lie in its own range, which the GUI sliders are
+	// built from, even when the geometry drives the default up.
+	for _, a := range algos.Algorithms {
+		ctx := algos.NewContext(0, testFFTFrameSize, testOversampling, testSampleRate, testFormat, testChannels, a)
+		if err := ctx.SetZeroPad(algos.MaxZeroPad); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range ctx.Processor.Params() {
+			if p.Default < p.Min || p.Default > p.Max {
+				t.Errorf("%s %s: default %g outside [%g, %g]", a.ShortName, p.Name, p.Default, p.Min, p.Max)
+			}
+		}
+	}
+}
+
+func TestShifterWindow(t *testing.T) {
+	stn, _ := algos.Find("stn")
+	kaiser, _ := algos.ParseWindow("kaiser:6")
+	s := newTestShifter(0)
+	if err := s.SetWindow(kaiser); err != nil {
+		t.Fatal(err)
+	}
+	// The window applies to every algorithm and frame size.
+	s.SetAlgorithm(stn)
+	s.ReinitContext(2*testFFTFrameSize, 4)
+	ctx := s.context()
+	if ctx.WindowShape != kaiser {
+		t.Errorf("window = %v, want %v", ctx.WindowShape, kaiser)
+	}
+	// A Kaiser window with beta 6 starts at 1/I0(6) ≈ 0.0149, Hann at 0.
+	if w := ctx.Window[0]; math.Abs(w-0.0149) > 1e-4 || len(ctx.Window) != 2*testFFTFrameSize {
+		t.Errorf("window of %d samples starts at %g, want %d and 0.0149", len(ctx.Window), w, 2*testFFTFrameSize)
+	}
+	if err := s.SetWindow(algos.WindowShape{Name: "triangle"}); err == nil {
+		t.Error("unknown window: want an error")
+	}
+	if s.context().WindowShape != kaiser {
+		t.Error("a rejected window replaced the current one")
+	}
+}
+
+func TestConcurrentParams(t *testing.T) {
+	// Run with -race: the controls are set from another goroutine while
+	// the callback runs, as the GUI does.
+	s := newTestShifter(0)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			v := float64(i%24 - 12)
+			s.SetPitchShift(v)
+			s.SetFormantShift(-v)
+			s.SetVolume(float64(i%10) / 10)
+			s.SetMix(float64(i%5) / 4)
+			s.SetRamp(float64(i % 50))
+			s.SetStretch(1 + float64(i%3)/2)
+			s.SetFormantPreserve(i%2 == 0)
+			s.SetPitchTracking(i%3 == 0)
+			s.SetDither(i%4 == 0)
+			s.SetHarmonyDry(float64(i%10) / 10)
+			s.SetTune(i%2 == 1, algos.TuneSettings{Retune: float64(i)})
+			if i%50 == 0 {
+				s.SetHarmony([]algos.Voice{{Interval: v, Gain: 1, Algo: algos.Default()}})
+			}
+			if i%50 == 25 {
+				s.SetHarmony(nil)
+			}
+			if i%50 == 10 {
+				s.ReinitContext(testFFTFrameSize*(1+i%2), testOversampling)
+			}
+			if i%50 == 40 {
+				s.SetAlgorithm(algos.Algorithms[i%len(algos.Algorithms)])
+			}
+			s.DetectedPitch()
+			s.latency()
+		}
+	}()
+	phase := 0.0
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		input, p := generateSineFrame(330, testFFTFrameSize/4, testSampleRate, phase)
+		phase = p
+		output := make([]byte, len(input))
+		s.process(output, input, uint32(testFFTFrameSize/4))
+		for _, v := range readSamplesF32(output, testChannels)[0] {
+			if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+				t.Fatalf("non-finite output sample %v", v)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/intermernet/pitcher/algos"
+)
+
+// tuneFlags holds the auto-tune flags shared by live and offline modes.
+type tuneFlags struct {
+	enable   *bool
+	key      *string
+	scale    *string
+	retune   *float64
+	humanize *float64
+}
+
+// addTuneFlags registers the auto-tune flags on fs.
+func addTuneFlags(fs *flag.FlagSet) tuneFlags {
+	return tuneFlags{
+		enable:   fs.Bool("autotune", false, "Correct the detected pitch to the nearest note of --key and --scale; --shift then transposes the result"),
+		key:      fs.String("key", "C", "Auto-tune key: C, C#, Db, D, … B"),
+		scale:    fs.String("scale", "chromatic", "Auto-tune scale: "+strings.Join(algos.ScaleNames, ", ")+", or a list of notes such as C,D,E,G,A"),
+		retune:   fs.Float64("retune", 50, "Auto-tune retune time in ms (0 = instant, hard correction)"),
+		humanize: fs.Float64("humanize", 0, "Auto-tune humanize amount from 0 to 1: how much natural pitch movement (vibrato) is kept"),
+	}
+}
+
+// settings validates the flags and returns the auto-tune settings.
+func (f tuneFlags) settings() (algos.TuneSettings, error) {
+	scale, err := algos.ParseScale(*f.key, *f.scale)
+	if err != nil {
+		return algos.TuneSettings{}, err
+	}
+	if *f.retune < 0 {
+		return algos.TuneSettings{}, errors.New("\"retune\" must not be negative")
+	}
+	if *f.humanize < 0 || *f.humanize > 1 {
+		return algos.TuneSettings{}, errors.New("\"humanize\" must be between 0 and 1")
+	}
+	return algos.TuneSettings{Scale: scale, Retune: *f.retune, Humanize: *f.humanize}, nil
+}
+
+// describeTune formats auto-tune settings for the running-parameters
+// summary.
+func describeTune(on bool, t algos.TuneSettings) string {
+	if !on {
+		return "No"
+	}
+	return fmt.Sprintf("%v, retune %g ms, humanize %g", t.Scale, t.Retune, t.Humanize)
+}
@@ -0,0 +1,201 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+// Package wavio reads and writes RIFF/WAVE files and converts their sample
+// data to and from the per-channel float64 planes used by the algorithms.
+//
+// Supported encodings are 16-, 24- and 32-bit integer PCM and 32- and 64-bit
+// IEEE float, in both the plain and WAVE_FORMAT_EXTENSIBLE fmt layouts.
+// Files whose data exceeds the 4 GB RIFF limit are written (and read) as
+// RF64 per EBU Tech 3306. Chunks other than fmt, data, fact and ds64 — LIST
+// and INFO metadata in particular — are preserved so they can be passed
+// through from a Reader to a Writer.
+package wavio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// SampleFormat is the on-disk encoding of a single sample.
+type SampleFormat int
+
+const (
+	PCM16 SampleFormat = iota + 1
+	PCM24
+	PCM32
+	Float32
+	Float64
+)
+
+// WAVE format tags.
+const (
+	formatTagPCM        = 0x0001
+	formatTagIEEEFloat  = 0x0003
+	formatTagExtensible = 0xFFFE
+)
+
+// ParseSampleFormat returns the SampleFormat for a short name such as "s16",
+// "s24", "s32", "f32" or "f64".
+func ParseSampleFormat(name string) (SampleFormat, error) {
+	for f := PCM16; f <= Float64; f++ {
+		if f.String() == name {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("wavio: unknown sample format %q", name)
+}
+
+// String returns the short name of the format ("s16", "s24", "s32", "f32", "f64").
+func (f SampleFormat) String() string {
+	switch f {
+	case PCM16:
+		return "s16"
+	case PCM24:
+		return "s24"
+	case PCM32:
+		return "s32"
+	case Float32:
+		return "f32"
+	case Float64:
+		return "f64"
+	}
+	return fmt.Sprintf("SampleFormat(%d)", int(f))
+}
+
+// Size returns the number of bytes per sample.
+func (f SampleFormat) Size() int {
+	switch f {
+	case PCM16:
+		return 2
+	case PCM24:
+		return 3
+	case PCM32, Float32:
+		return 4
+	case Float64:
+		return 8
+	}
+	return 0
+}
+
+// IsFloat reports whether f is an IEEE float encoding.
+func (f SampleFormat) IsFloat() bool {
+	return f == Float32 || f == Float64
+}
+
+// Decode reads one little-endian sample from b and returns it scaled to
+// [-1, 1).
+func (f SampleFormat) Decode(b []byte) float64 {
+	switch f {
+	case PCM16:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+	case PCM24:
+		v := int32(uint32(b[0])<<8 | uint32(b[1])<<16 | uint32(b[2])<<24)
+		return float64(v>>8) / (1 << 23)
+	case PCM32:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+	case Float32:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case Float64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+// Encode writes v as one little-endian sample into b. Integer encodings are
+// rounded and clipped to their full-scale range; float encodings are stored
+// unclipped.
+func (f SampleFormat) Encode(b []byte, v float64) {
+	switch f {
+	case PCM16:
+		binary.LittleEndian.PutUint16(b, uint16(int16(quantize(v, 1<<15))))
+	case PCM24:
+		q := int32(quantize(v, 1<<23))
+		b[0] = byte(q)
+		b[1] = byte(q >> 8)
+		b[2] = byte(q >> 16)
+	case PCM32:
+		binary.LittleEndian.PutUint32(b, uint32(int32(quantize(v, 1<<31))))
+	case Float32:
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+	case Float64:
+		binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+	}
+}
+
+// quantize scales v by fullScale, rounds to the nearest integer and clips to
+// [-fullScale, fullScale-1].
+func quantize(v, fullScale float64) int64 {
+	s := math.Round(v * fullScale)
+	switch {
+	case math.IsNaN(s):
+		return 0
+	case s >= fullScale:
+		return int64(fullScale) - 1
+	case s < -fullScale:
+		return -int64(fullScale)
+	}
+	return int64(s)
+}
+
+// Format describes the sample layout of a WAV file.
+type Format struct {
+	SampleFormat SampleFormat
+	Channels     int
+	SampleRate   int
+	// ChannelMask is the WAVE_FORMAT_EXTENSIBLE speaker mask. Zero means
+	// unspecified.
+	ChannelMask uint32
+}
+
+// FrameSize returns the number of bytes per interleaved frame.
+func (f Format) FrameSize() int {
+	return f.SampleFormat.Size() * f.Channels
+}
+
+func (f Format) validate() error {
+	if f.SampleFormat.Size() == 0 {
+		return fmt.Errorf("wavio: unsupported sample format %v", f.SampleFormat)
+	}
+	if f.Channels < 1 || f.Channels > math.MaxUint16 {
+		return fmt.Errorf("wavio: invalid channel count %d", f.Channels)
+	}
+	if f.SampleRate < 1 || f.SampleRate > math.MaxUint32 {
+		return fmt.Errorf("wavio: invalid sample rate %d", f.SampleRate)
+	}
+	return nil
+}
+
+// Deinterleave decodes the whole frames in src into planes, one slice per
+// channel, and returns the number of frames decoded. It decodes at most
+// len(planes[0]) frames.
+func Deinterleave(planes [][]float64, src []byte, sf SampleFormat) int {
+	channels := len(planes)
+	size := sf.Size()
+	n := min(len(src)/(size*channels), len(planes[0]))
+	off := 0
+	for i := 0; i < n; i++ {
+		for c := 0; c < channels; c++ {
+			planes[c][i] = sf.Decode(src[off:])
+			off += size
+		}
+	}
+	return n
+}
+
+// Interleave encodes the first n frames of planes into dst, which must hold
+// at least n*len(planes)*sf.Size() bytes.
+func Interleave(dst []byte, planes [][]float64, sf SampleFormat, n int) {
+	size := sf.Size()
+	off := 0
+	for i := 0; i < n; i++ {
+		for c := range planes {
+			sf.Encode(dst[off:], planes[c][i])
+			off += size
+		}
+	}
+}
@@ -0,0 +1,271 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package wavio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// KSDATAFORMAT_SUBTYPE_* GUIDs share this tail after the 2-byte format tag.
+var subFormatTail = [14]byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}
+
+// ErrFormat is returned for malformed or unsupported files.
+var ErrFormat = errors.New("wavio: not a supported WAV file")
+
+// maxChunkSize bounds the chunks NewReader reads into memory, so that a
+// corrupt size field cannot make it allocate gigabytes.
+const maxChunkSize = 16 << 20
+
+// Chunk is a RIFF chunk carried through unmodified, such as LIST/INFO
+// metadata.
+type Chunk struct {
+	ID   [4]byte
+	Data []byte
+}
+
+// Info parses a LIST chunk of type INFO into a map of four-character IDs to
+// text values. It returns nil for any other chunk.
+func (c Chunk) Info() map[string]string {
+	if string(c.ID[:]) != "LIST" || len(c.Data) < 4 || string(c.Data[:4]) != "INFO" {
+		return nil
+	}
+	info := make(map[string]string)
+	for b := c.Data[4:]; len(b) >= 8; {
+		id := string(b[:4])
+		size := int(binary.LittleEndian.Uint32(b[4:8]))
+		if 8+size > len(b) {
+			break
+		}
+		info[id] = strings.TrimRight(string(b[8:8+size]), "\x00")
+		b = b[8+size+size&1:]
+	}
+	return info
+}
+
+// Reader decodes sample data from a WAV or RF64 file.
+type Reader struct {
+	Format
+	// Chunks holds every chunk other than fmt, data, fact, ds64 and JUNK,
+	// in file order.
+	Chunks []Chunk
+
+	r         io.ReadSeeker
+	frames    int64
+	remaining int64
+	buf       []byte
+}
+
+// NewReader parses the RIFF/RF64 header and all chunks of r, leaving it
+// positioned at the start of the sample data.
+func NewReader(r io.ReadSeeker) (*Reader, error) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, ErrFormat
+	}
+	rf64 := string(hdr[:4]) == "RF64"
+	if (!rf64 && string(hdr[:4]) != "RIFF") || string(hdr[8:12]) != "WAVE" {
+		return nil, ErrFormat
+	}
+
+	wr := &Reader{r: r}
+	var (
+		haveFmt, haveData bool
+		dataOffset        int64
+		dataSize          int64
+		ds64DataSize      int64
+	)
+	for {
+		var ch [8]byte
+		if _, err := io.ReadFull(r, ch[:]); err != nil {
+			break
+		}
+		id := string(ch[:4])
+		size := int64(binary.LittleEndian.Uint32(ch[4:8]))
+		if id == "data" && rf64 && size == 0xFFFFFFFF {
+			size = ds64DataSize
+		}
+
+		if id == "data" {
+			off, err := r.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			dataOffset, dataSize, haveData = off, size, true
+			if _, err := r.Seek(size+size&1, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if id == "fact" || id == "JUNK" {
+			if _, err := r.Seek(size+size&1, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if size > maxChunkSize {
+			return nil, fmt.Errorf("%w: %q chunk of %d bytes", ErrFormat, id, size)
+		}
+
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			// A truncated trailing chunk is tolerated once the sample
+			// data has been located.
+			if haveFmt && haveData {
+				break
+			}
+			return nil, ErrFormat
+		}
+		if size&1 == 1 {
+			if _, err := r.Seek(1, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		}
+
+		switch id {
+		case "fmt ":
+			if err := wr.parseFmt(body); err != nil {
+				return nil, err
+			}
+			haveFmt = true
+		case "ds64":
+			if len(body) < 24 {
+				return nil, ErrFormat
+			}
+			ds64DataSize = int64(binary.LittleEndian.Uint64(body[8:16]))
+		default:
+			var c Chunk
+			copy(c.ID[:], id)
+			c.Data = body
+			wr.Chunks = append(wr.Chunks, c)
+		}
+	}
+	if !haveFmt || !haveData {
+		return nil, ErrFormat
+	}
+
+	// Clamp to the bytes actually present in case the header overstates
+	// a file that was truncated while recording.
+	if end, err := r.Seek(0, io.SeekEnd); err == nil && dataOffset+dataSize > end {
+		dataSize = end - dataOffset
+	}
+	if _, err := r.Seek(dataOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	wr.frames = dataSize / int64(wr.FrameSize())
+	wr.remaining = wr.frames
+	return wr, nil
+}
+
+// parseFmt decodes a plain or WAVE_FORMAT_EXTENSIBLE fmt chunk.
+func (wr *Reader) parseFmt(b []byte) error {
+	if len(b) < 16 {
+		return ErrFormat
+	}
+	tag := binary.LittleEndian.Uint16(b[0:2])
+	wr.Channels = int(binary.LittleEndian.Uint16(b[2:4]))
+	wr.SampleRate = int(binary.LittleEndian.Uint32(b[4:8]))
+	bits := binary.LittleEndian.Uint16(b[14:16])
+	if tag == formatTagExtensible {
+		if len(b) < 40 || !bytes.Equal(b[26:40], subFormatTail[:]) {
+			return ErrFormat
+		}
+		wr.ChannelMask = binary.LittleEndian.Uint32(b[20:24])
+		tag = binary.LittleEndian.Uint16(b[24:26])
+	}
+
+	switch {
+	case tag == formatTagPCM && bits == 16:
+		wr.SampleFormat = PCM16
+	case tag == formatTagPCM && bits == 24:
+		wr.SampleFormat = PCM24
+	case tag == formatTagPCM && bits == 32:
+		wr.SampleFormat = PCM32
+	case tag == formatTagIEEEFloat && bits == 32:
+		wr.SampleFormat = Float32
+	case tag == formatTagIEEEFloat && bits == 64:
+		wr.SampleFormat = Float64
+	default:
+		return fmt.Errorf("%w: format tag %#04x with %d bits per sample", ErrFormat, tag, bits)
+	}
+	if err := wr.validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	return nil
+}
+
+// Frames returns the total number of sample frames in the file.
+func (wr *Reader) Frames() int64 {
+	return wr.frames
+}
+
+// Read reads raw interleaved sample bytes into p, returning io.EOF once the
+// data chunk is exhausted. Only whole frames are returned.
+func (wr *Reader) Read(p []byte) (int, error) {
+	if wr.remaining == 0 {
+		return 0, io.EOF
+	}
+	fs := wr.FrameSize()
+	n := min(int64(len(p)/fs), wr.remaining)
+	read, err := io.ReadFull(wr.r, p[:n*int64(fs)])
+	read -= read % fs
+	wr.remaining -= int64(read / fs)
+	if err == io.ErrUnexpectedEOF {
+		wr.remaining = 0
+		err = nil
+	}
+	return read, err
+}
+
+// ReadFloat decodes up to len(planes[0]) frames into planes (one slice per
+// channel) and returns the number of frames read. It returns io.EOF when no
+// frames remain.
+func (wr *Reader) ReadFloat(planes [][]float64) (int, error) {
+	if len(planes) != wr.Channels {
+		return 0, fmt.Errorf("wavio: %d planes for %d channels", len(planes), wr.Channels)
+	}
+	want := len(planes[0]) * wr.FrameSize()
+	if cap(wr.buf) < want {
+		wr.buf = make([]byte, want)
+	}
+	n, err := wr.Read(wr.buf[:want])
+	if n == 0 {
+		return 0, err
+	}
+	return Deinterleave(planes, wr.buf[:n], wr.SampleFormat), nil
+}
+
+// ReadAll decodes every remaining frame into newly allocated planes.
+func (wr *Reader) ReadAll() ([][]float64, error) {
+	planes := make([][]float64, wr.Channels)
+	for c := range planes {
+		planes[c] = make([]float64, wr.remaining)
+	}
+	total := 0
+	for total < len(planes[0]) {
+		view := make([][]float64, wr.Channels)
+		for c := range view {
+			view[c] = planes[c][total:min(total+8192, len(planes[c]))]
+		}
+		n, err := wr.ReadFloat(view)
+		total += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for c := range planes {
+		planes[c] = planes[c][:total]
+	}
+	return planes, nil
+}
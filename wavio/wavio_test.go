@@ -0,0 +1,233 @@
+package wavio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testPlanes returns a short multichannel test signal spanning most of full
+// scale, with a different sine per channel.
+func testPlanes(channels, frames int) [][]float64 {
+	planes := make([][]float64, channels)
+	for c := range planes {
+		planes[c] = make([]float64, frames)
+		for i := range planes[c] {
+			planes[c][i] = 0.9 * math.Sin(2*math.Pi*float64((c+1)*i)/64)
+		}
+	}
+	return planes
+}
+
+// writeFile writes planes to a new file with the given format and chunks.
+func writeFile(t *testing.T, path string, f Format, planes [][]float64, chunks ...Chunk) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w, err := NewWriter(file, f, chunks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteFloat(planes); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFile opens path and decodes all of its samples.
+func readFile(t *testing.T, path string) (*Reader, [][]float64) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	r, err := NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planes, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, planes
+}
+
+func TestRoundTrip(t *testing.T) {
+	// Worst-case round-trip error per format: half an LSB for integers.
+	tolerance := map[SampleFormat]float64{
+		PCM16:   0.5 / (1 << 15),
+		PCM24:   0.5 / (1 << 23),
+		PCM32:   0.5 / (1 << 31),
+		Float32: 1e-7,
+		Float64: 0,
+	}
+	for _, channels := range []int{1, 2, 6} {
+		for sf, tol := range tolerance {
+			f := Format{SampleFormat: sf, Channels: channels, SampleRate: 44100}
+			path := filepath.Join(t.TempDir(), "rt.wav")
+			want := testPlanes(channels, 301) // odd length exercises pad bytes for PCM24 mono
+			writeFile(t, path, f, want)
+
+			r, got := readFile(t, path)
+			if r.Format != f {
+				t.Fatalf("%v/%dch: format %+v, want %+v", sf, channels, r.Format, f)
+			}
+			if len(got[0]) != len(want[0]) {
+				t.Fatalf("%v/%dch: read %d frames, want %d", sf, channels, len(got[0]), len(want[0]))
+			}
+			for c := range want {
+				for i := range want[c] {
+					if d := math.Abs(got[c][i] - want[c][i]); d > tol {
+						t.Fatalf("%v/%dch: ch%d sample %d off by %g", sf, channels, c, i, d)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestExtensibleHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ext.wav")
+	f := Format{SampleFormat: PCM24, Channels: 2, SampleRate: 48000, ChannelMask: 0x3}
+	writeFile(t, path, f, testPlanes(2, 16))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RIFF(12) + JUNK(8+28) puts the fmt body at offset 56.
+	if tag := binary.LittleEndian.Uint16(data[56:]); tag != formatTagExtensible {
+		t.Fatalf("format tag %#04x, want WAVE_FORMAT_EXTENSIBLE", tag)
+	}
+	r, _ := readFile(t, path)
+	if r.ChannelMask != 0x3 {
+		t.Errorf("channel mask %#x, want 0x3", r.ChannelMask)
+	}
+}
+
+func TestInfoPassthrough(t *testing.T) {
+	info := []byte("INFO")
+	for _, kv := range [][2]string{{"INAM", "Lead vocal"}, {"ISFT", "pitcher"}} {
+		info = append(info, kv[0]...)
+		info = binary.LittleEndian.AppendUint32(info, uint32(len(kv[1])+1))
+		info = append(info, kv[1]...)
+		info = append(info, 0)
+		if (len(kv[1])+1)&1 == 1 {
+			info = append(info, 0)
+		}
+	}
+	list := Chunk{ID: [4]byte{'L', 'I', 'S', 'T'}, Data: info}
+
+	dir := t.TempDir()
+	first := filepath.Join(dir, "a.wav")
+	writeFile(t, first, Format{SampleFormat: PCM16, Channels: 1, SampleRate: 8000}, testPlanes(1, 10), list)
+	r, planes := readFile(t, first)
+
+	// Pass the chunks of one file through to another.
+	second := filepath.Join(dir, "b.wav")
+	writeFile(t, second, r.Format, planes, r.Chunks...)
+	r2, _ := readFile(t, second)
+
+	if len(r2.Chunks) != 1 {
+		t.Fatalf("got %d chunks, want 1", len(r2.Chunks))
+	}
+	got := r2.Chunks[0].Info()
+	if got["INAM"] != "Lead vocal" || got["ISFT"] != "pitcher" {
+		t.Errorf("INFO = %v", got)
+	}
+}
+
+func TestRF64(t *testing.T) {
+	saved := maxRIFFSize
+	maxRIFFSize = 1000
+	defer func() { maxRIFFSize = saved }()
+
+	path := filepath.Join(t.TempDir(), "big.wav")
+	want := testPlanes(2, 1024)
+	writeFile(t, path, Format{SampleFormat: Float32, Channels: 2, SampleRate: 48000}, want)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var magic [4]byte
+	if _, err := io.ReadFull(file, magic[:]); err != nil {
+		t.Fatal(err)
+	}
+	if string(magic[:]) != "RF64" {
+		t.Fatalf("magic %q, want RF64", magic)
+	}
+
+	_, got := readFile(t, path)
+	if len(got[0]) != len(want[0]) {
+		t.Fatalf("read %d frames, want %d", len(got[0]), len(want[0]))
+	}
+	if got[1][100] != float64(float32(want[1][100])) {
+		t.Errorf("sample mismatch: %v vs %v", got[1][100], want[1][100])
+	}
+}
+
+func TestOversizedChunks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in.wav")
+	want := testPlanes(1, 256)
+	writeFile(t, path, Format{SampleFormat: PCM16, Channels: 1, SampleRate: 48000}, want)
+	valid, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// withChunk appends a chunk header claiming nearly 4 GB with no body.
+	withChunk := func(id string) *bytes.Reader {
+		b := append(slices.Clone(valid), id...)
+		return bytes.NewReader(binary.LittleEndian.AppendUint32(b, 0xFFFFFFF0))
+	}
+
+	// Discarded chunks are skipped without being read.
+	r, err := NewReader(withChunk("JUNK"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got[0]) != len(want[0]) {
+		t.Fatalf("read %d frames, want %d", len(got[0]), len(want[0]))
+	}
+
+	// Kept chunks are refused rather than allocated.
+	if _, err := NewReader(withChunk("LIST")); !errors.Is(err, ErrFormat) {
+		t.Errorf("oversized LIST chunk: error %v, want ErrFormat", err)
+	}
+}
+
+func TestEncodeClips(t *testing.T) {
+	b := make([]byte, 4)
+	for _, tc := range []struct {
+		sf   SampleFormat
+		in   float64
+		want float64
+	}{
+		{PCM16, 2, 32767.0 / 32768},
+		{PCM16, -2, -1},
+		{PCM24, 1, 8388607.0 / 8388608},
+		{PCM32, -1.5, -1},
+	} {
+		tc.sf.Encode(b, tc.in)
+		if got := tc.sf.Decode(b); got != tc.want {
+			t.Errorf("%v: Encode(%v) decodes to %v, want %v", tc.sf, tc.in, got, tc.want)
+		}
+	}
+}
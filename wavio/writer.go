@@ -0,0 +1,204 @@
+/****************************************************************************
+*
+* COPYRIGHT 2026 Mike Hughes <mike <AT> mikehughes <DOT> info
+*
+****************************************************************************/
+
+package wavio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxRIFFSize is the largest RIFF size field a plain WAV file can carry.
+// Files that grow beyond it are promoted to RF64 on Close. It is a variable
+// so tests can exercise the RF64 path without writing 4 GB.
+var maxRIFFSize int64 = 0xFFFFFFFF
+
+// ds64Size is the body size of a ds64 chunk with an empty table: RIFF size,
+// data size and sample count (8 bytes each) plus the table length.
+const ds64Size = 28
+
+// Writer encodes sample data to a WAV file. The header is written up front
+// with placeholder sizes which Close patches, so the destination must be
+// seekable.
+type Writer struct {
+	Format
+
+	w          io.WriteSeeker
+	chunks     []Chunk
+	junkOffset int64 // offset of the JUNK chunk reserved for ds64
+	dataOffset int64 // offset of the data chunk header
+	dataBytes  int64
+	buf        []byte
+	closed     bool
+}
+
+// NewWriter writes a WAV header for f followed by chunks (for example the
+// Chunks of a Reader, to pass LIST/INFO metadata through) and returns a
+// Writer positioned at the start of the sample data.
+//
+// WAVE_FORMAT_EXTENSIBLE is used when there are more than two channels, more
+// than 16 bits per sample or a ChannelMask is set, as recommended by
+// Microsoft; otherwise the plain PCM / IEEE float layout is written.
+func NewWriter(w io.WriteSeeker, f Format, chunks ...Chunk) (*Writer, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	ww := &Writer{Format: f, w: w, chunks: chunks}
+
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	var hdr []byte
+	hdr = append(hdr, "RIFF\x00\x00\x00\x00WAVE"...)
+
+	// Reserve room for a ds64 chunk in case the file outgrows RIFF.
+	ww.junkOffset = start + int64(len(hdr))
+	hdr = append(hdr, "JUNK"...)
+	hdr = binary.LittleEndian.AppendUint32(hdr, ds64Size)
+	hdr = append(hdr, make([]byte, ds64Size)...)
+
+	hdr = append(hdr, "fmt "...)
+	fmtBody := ww.fmtChunk()
+	hdr = binary.LittleEndian.AppendUint32(hdr, uint32(len(fmtBody)))
+	hdr = append(hdr, fmtBody...)
+
+	for _, c := range chunks {
+		hdr = append(hdr, c.ID[:]...)
+		hdr = binary.LittleEndian.AppendUint32(hdr, uint32(len(c.Data)))
+		hdr = append(hdr, c.Data...)
+		if len(c.Data)&1 == 1 {
+			hdr = append(hdr, 0)
+		}
+	}
+
+	ww.dataOffset = start + int64(len(hdr))
+	hdr = append(hdr, "data\x00\x00\x00\x00"...)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+// fmtChunk builds the body of the fmt chunk.
+func (ww *Writer) fmtChunk() []byte {
+	tag := uint16(formatTagPCM)
+	if ww.SampleFormat.IsFloat() {
+		tag = formatTagIEEEFloat
+	}
+	bits := uint16(ww.SampleFormat.Size() * 8)
+	extensible := ww.Channels > 2 || bits > 16 || ww.ChannelMask != 0
+
+	var b []byte
+	if extensible {
+		b = binary.LittleEndian.AppendUint16(b, formatTagExtensible)
+	} else {
+		b = binary.LittleEndian.AppendUint16(b, tag)
+	}
+	b = binary.LittleEndian.AppendUint16(b, uint16(ww.Channels))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ww.SampleRate))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ww.SampleRate*ww.FrameSize()))
+	b = binary.LittleEndian.AppendUint16(b, uint16(ww.FrameSize()))
+	b = binary.LittleEndian.AppendUint16(b, bits)
+	if extensible {
+		b = binary.LittleEndian.AppendUint16(b, 22) // cbSize
+		b = binary.LittleEndian.AppendUint16(b, bits)
+		b = binary.LittleEndian.AppendUint32(b, ww.ChannelMask)
+		b = binary.LittleEndian.AppendUint16(b, tag)
+		b = append(b, subFormatTail[:]...)
+	}
+	return b
+}
+
+// Write appends raw interleaved sample bytes; len(p) must be a whole number
+// of frames.
+func (ww *Writer) Write(p []byte) (int, error) {
+	if ww.closed {
+		return 0, errors.New("wavio: write to closed Writer")
+	}
+	if len(p)%ww.FrameSize() != 0 {
+		return 0, fmt.Errorf("wavio: write of %d bytes is not a whole number of %d-byte frames", len(p), ww.FrameSize())
+	}
+	n, err := ww.w.Write(p)
+	ww.dataBytes += int64(n)
+	return n, err
+}
+
+// WriteFloat encodes len(planes[0]) frames from planes, one slice per
+// channel.
+func (ww *Writer) WriteFloat(planes [][]float64) error {
+	if len(planes) != ww.Channels {
+		return fmt.Errorf("wavio: %d planes for %d channels", len(planes), ww.Channels)
+	}
+	n := len(planes[0])
+	size := n * ww.FrameSize()
+	if cap(ww.buf) < size {
+		ww.buf = make([]byte, size)
+	}
+	Interleave(ww.buf[:size], planes, ww.SampleFormat, n)
+	_, err := ww.Write(ww.buf[:size])
+	return err
+}
+
+// Close pads the data chunk to an even length and patches the header sizes,
+// promoting the file to RF64 if it exceeds the RIFF size limit. It does not
+// close the underlying writer.
+func (ww *Writer) Close() error {
+	if ww.closed {
+		return nil
+	}
+	ww.closed = true
+
+	if ww.dataBytes&1 == 1 {
+		if _, err := ww.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	end, err := ww.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	start := ww.junkOffset - 12
+	riffSize := end - start - 8
+
+	if riffSize > maxRIFFSize || ww.dataBytes > maxRIFFSize {
+		if err := ww.patch(start, []byte("RF64\xFF\xFF\xFF\xFF")); err != nil {
+			return err
+		}
+		ds64 := []byte("ds64")
+		ds64 = binary.LittleEndian.AppendUint32(ds64, ds64Size)
+		ds64 = binary.LittleEndian.AppendUint64(ds64, uint64(riffSize))
+		ds64 = binary.LittleEndian.AppendUint64(ds64, uint64(ww.dataBytes))
+		ds64 = binary.LittleEndian.AppendUint64(ds64, uint64(ww.dataBytes/int64(ww.FrameSize())))
+		ds64 = binary.LittleEndian.AppendUint32(ds64, 0) // table length
+		if err := ww.patch(ww.junkOffset, ds64); err != nil {
+			return err
+		}
+		if err := ww.patch(ww.dataOffset+4, []byte{0xFF, 0xFF, 0xFF, 0xFF}); err != nil {
+			return err
+		}
+	} else {
+		if err := ww.patch(start+4, binary.LittleEndian.AppendUint32(nil, uint32(riffSize))); err != nil {
+			return err
+		}
+		if err := ww.patch(ww.dataOffset+4, binary.LittleEndian.AppendUint32(nil, uint32(ww.dataBytes))); err != nil {
+			return err
+		}
+	}
+	_, err = ww.w.Seek(end, io.SeekStart)
+	return err
+}
+
+// patch overwrites the bytes at off with b.
+func (ww *Writer) patch(off int64, b []byte) error {
+	if _, err := ww.w.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := ww.w.Write(b)
+	return err
+}